@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Publish modes of a feed. Notes are kind 1, articles are NIP-23 kind 30023
+// addressable events, optionally announced by a kind 1 teaser. Videos are
+// NIP-71 events, optionally announced by a note; other items of the feed
+// are published as notes.
+var publishModes = []string{"note", "article", "article+teaser", "video", "video+note"}
+
+const articleSummaryLength = 280
+
+func validPublishMode(mode string) bool {
+	for _, m := range publishModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlToMarkdown converts an HTML string into Markdown for NIP-23 articles.
+// Junk and icon images are removed the same way as in htmlToPlainText.
+func htmlToMarkdown(rawHTML string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return html.UnescapeString(rawHTML)
+	}
+
+	doc.Find(".share_submission, iframe, script, style, noscript").Remove()
+
+	var sb strings.Builder
+	root := doc.Find("body")
+	if root.Length() == 0 {
+		root = doc.Selection
+	}
+	writeMarkdown(&sb, root.Contents(), "")
+
+	text := reMultiNewline.ReplaceAllString(sb.String(), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// writeMarkdown renders the given nodes. prefix is prepended to every new
+// line and is used for blockquotes and nested lists.
+func writeMarkdown(sb *strings.Builder, nodes *goquery.Selection, prefix string) {
+	nodes.Each(func(_ int, s *goquery.Selection) {
+		switch name := goquery.NodeName(s); name {
+		case "#text":
+			text := s.Text()
+			if strings.TrimSpace(text) == "" && strings.Contains(text, "\n") {
+				return
+			}
+			sb.WriteString(strings.ReplaceAll(text, "\n", " "))
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			level, _ := strconv.Atoi(name[1:])
+			sb.WriteString("\n\n" + prefix + strings.Repeat("#", level) + " " + strings.TrimSpace(inlineMarkdown(s)) + "\n\n" + prefix)
+		case "p", "div", "section", "article", "header", "footer", "figure":
+			sb.WriteString("\n\n" + prefix)
+			writeMarkdown(sb, s.Contents(), prefix)
+			sb.WriteString("\n\n" + prefix)
+		case "figcaption":
+			sb.WriteString("\n\n" + prefix + "*" + strings.TrimSpace(inlineMarkdown(s)) + "*\n\n" + prefix)
+		case "br":
+			sb.WriteString("  \n" + prefix)
+		case "hr":
+			sb.WriteString("\n\n" + prefix + "---\n\n" + prefix)
+		case "strong", "b":
+			if text := strings.TrimSpace(inlineMarkdown(s)); text != "" {
+				sb.WriteString("**" + text + "**")
+			}
+		case "em", "i":
+			if text := strings.TrimSpace(inlineMarkdown(s)); text != "" {
+				sb.WriteString("*" + text + "*")
+			}
+		case "code":
+			sb.WriteString("`" + s.Text() + "`")
+		case "pre":
+			code := strings.Trim(s.Text(), "\n")
+			lang := ""
+			if class, ok := s.Find("code").Attr("class"); ok {
+				if fields := strings.Fields(class); len(fields) > 0 {
+					lang = strings.TrimPrefix(fields[0], "language-")
+				}
+			}
+			sb.WriteString("\n\n" + prefix + "```" + lang + "\n")
+			for _, line := range strings.Split(code, "\n") {
+				sb.WriteString(prefix + line + "\n")
+			}
+			sb.WriteString(prefix + "```\n\n" + prefix)
+		case "blockquote":
+			sb.WriteString("\n\n" + prefix + "> ")
+			writeMarkdown(sb, s.Contents(), prefix+"> ")
+			sb.WriteString("\n\n" + prefix)
+		case "ul", "ol":
+			sb.WriteString("\n")
+			s.ChildrenFiltered("li").Each(func(i int, li *goquery.Selection) {
+				marker := "- "
+				if name == "ol" {
+					marker = strconv.Itoa(i+1) + ". "
+				}
+				sb.WriteString("\n" + prefix + marker)
+				writeMarkdown(sb, li.Contents(), prefix+strings.Repeat(" ", len(marker)))
+			})
+			sb.WriteString("\n\n" + prefix)
+		case "a":
+			href, _ := s.Attr("href")
+			text := strings.TrimSpace(inlineMarkdown(s))
+			switch {
+			case href == "":
+				sb.WriteString(text)
+			case text == "" || text == href:
+				sb.WriteString(href)
+			default:
+				sb.WriteString("[" + text + "](" + href + ")")
+			}
+		case "img":
+			src, _ := s.Attr("src")
+			if src == "" || isIconURL(src) {
+				return
+			}
+			alt, _ := s.Attr("alt")
+			sb.WriteString("![" + alt + "](" + src + ")")
+		case "table":
+			sb.WriteString("\n\n" + prefix)
+			s.Find("tr").Each(func(_ int, tr *goquery.Selection) {
+				var cells []string
+				tr.Find("th, td").Each(func(_ int, td *goquery.Selection) {
+					cells = append(cells, strings.TrimSpace(inlineMarkdown(td)))
+				})
+				sb.WriteString(strings.Join(cells, " | ") + "\n" + prefix)
+			})
+			sb.WriteString("\n" + prefix)
+		default:
+			writeMarkdown(sb, s.Contents(), prefix)
+		}
+	})
+}
+
+func inlineMarkdown(s *goquery.Selection) string {
+	var sb strings.Builder
+	writeMarkdown(&sb, s.Contents(), "")
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// articleIdentifier derives the stable "d" tag of an article from the item
+// GUID (or link), so republishing replaces the article instead of duplicating it.
+func articleIdentifier(postID string) string {
+	sum := sha256.Sum256([]byte(postID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// articleSummary returns a short plain text summary of the item.
+func articleSummary(feedPost *gofeed.Item) string {
+	summary := htmlToPlainText(feedPost.Description)
+	if summary == "" {
+		summary = htmlToPlainText(feedPost.Content)
+	}
+	summary = strings.Join(strings.Fields(summary), " ")
+	if runes := []rune(summary); len(runes) > articleSummaryLength {
+		summary = strings.TrimSpace(string(runes[:articleSummaryLength-1])) + "…"
+	}
+	return summary
+}
+
+// articleImage returns the lead image of the item, if any.
+func articleImage(feedPost *gofeed.Item) string {
+	if feedPost.Image != nil && feedPost.Image.URL != "" {
+		return feedPost.Image.URL
+	}
+	for _, enclosure := range feedPost.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") {
+			return enclosure.URL
+		}
+	}
+	return ""
+}
+
+// buildArticleEvent creates an unsigned NIP-23 long-form event for the item.
+func buildArticleEvent(feedItem feedStruct, feedPost *gofeed.Item, postID string, itemTime int64) nostr.Event {
+	body := feedPost.Content
+	if body == "" {
+		body = feedPost.Description
+	}
+	content := htmlToMarkdown(body)
+	if feedPost.Link != "" {
+		content = content + "\n\n[Original article](" + feedPost.Link + ")"
+	}
+
+	if postID == "" {
+		postID = feedPost.Title
+	}
+	tags := nostr.Tags{
+		{"d", articleIdentifier(postID)},
+		{"title", feedPost.Title},
+		{"published_at", strconv.FormatInt(itemTime, 10)},
+	}
+	if summary := articleSummary(feedPost); summary != "" {
+		tags = append(tags, nostr.Tag{"summary", summary})
+	}
+	if image := articleImage(feedPost); image != "" {
+		tags = append(tags, nostr.Tag{"image", image})
+	}
+	for _, category := range feedPost.Categories {
+		tags = append(tags, nostr.Tag{"t", category})
+	}
+	if feedPost.Link != "" {
+		tags = append(tags, nostr.Tag{"r", feedPost.Link})
+	}
+	tags = append(tags, mediaTags(feedItem, feedPost, content)...)
+	tags = append(tags, feedItem.proxyTag(feedPost))
+
+	return nostr.Event{
+		PubKey:    feedItem.Pub,
+		CreatedAt: nostr.Timestamp(itemTime),
+		Kind:      nostr.KindArticle,
+		Tags:      tags,
+		Content:   content,
+	}
+}
+
+// buildTeaserEvent creates an unsigned kind 1 note announcing an article,
+// referencing it with an "a" tag and an naddr link.
+func buildTeaserEvent(feedItem feedStruct, feedPost *gofeed.Item, article nostr.Event) nostr.Event {
+	dTag := article.Tags.GetD()
+	address := fmt.Sprintf("%d:%s:%s", nostr.KindArticle, feedItem.Pub, dTag)
+
+	content := feedPost.Title
+	if summary := articleSummary(feedPost); summary != "" {
+		content = content + "\n\n" + summary
+	}
+	naddr, err := nip19.EncodeEntity(feedItem.Pub, nostr.KindArticle, dTag, feedItem.publishRelays())
+	if err != nil {
+		log.Printf("[WARN] Can't encode naddr for %s: %v", feedPost.Link, err)
+	} else {
+		content = content + "\n\nnostr:" + naddr
+	}
+
+	tags := nostr.Tags{{"a", address, firstOrEmpty(feedItem.publishRelays())}}
+	for _, category := range feedPost.Categories {
+		tags = append(tags, nostr.Tag{"t", category})
+	}
+	tags = append(tags, feedItem.proxyTag(feedPost))
+
+	return nostr.Event{
+		PubKey:    feedItem.Pub,
+		CreatedAt: article.CreatedAt,
+		Kind:      nostr.KindTextNote,
+		Tags:      tags,
+		Content:   content,
+	}
+}
+
+func firstOrEmpty(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
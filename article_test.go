@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "heading and paragraph",
+			html: "<h2>Intro</h2><p>Hello <strong>bold</strong> and <em>italic</em> world.</p>",
+			want: "## Intro\n\nHello **bold** and *italic* world.",
+		},
+		{
+			name: "link and image",
+			html: `<p>See <a href="https://example.com">the site</a></p><img src="https://example.com/pic.jpg" alt="A pic">`,
+			want: "See [the site](https://example.com)\n\n![A pic](https://example.com/pic.jpg)",
+		},
+		{
+			name: "code block",
+			html: "<pre><code class=\"language-go\">func main() {\n}\n</code></pre>",
+			want: "```go\nfunc main() {\n}\n```",
+		},
+		{
+			name: "lists",
+			html: "<ul><li>one</li><li>two</li></ul><ol><li>first</li><li>second</li></ol>",
+			want: "- one\n- two\n\n1. first\n2. second",
+		},
+		{
+			name: "blockquote and junk",
+			html: `<blockquote>Quoted</blockquote><iframe src="x"></iframe><img src="https://example.com/share-icon.png">`,
+			want: "> Quoted",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := htmlToMarkdown(tt.html); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
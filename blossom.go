@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxMirrorSize limits the size of files mirrored to the Blossom server.
+const maxMirrorSize = 20 << 20
+
+// reBlobHash finds the SHA-256 in a Blossom URL (BUD-01: /<sha256>.<ext>).
+var reBlobHash = regexp.MustCompile(`/([0-9a-f]{64})(\.[A-Za-z0-9]+)?$`)
+
+// blobDescriptor is the answer of a Blossom server to an upload (BUD-02).
+type blobDescriptor struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Type   string `json:"type"`
+}
+
+// blossomEnabled reports whether media is mirrored.
+func blossomEnabled() bool {
+	return blossomServer != "" && !dryRunMode
+}
+
+// blobHash returns the SHA-256 of a Blossom URL, or "" for other URLs. The
+// server may answer with URLs on another host (e.g. a CDN), so only the
+// path is checked.
+func blobHash(mediaURL string) string {
+	if blossomServer == "" {
+		return ""
+	}
+	if m := reBlobHash.FindStringSubmatch(strings.SplitN(mediaURL, "?", 2)[0]); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// mirrorURL returns the Blossom URL of a media file, uploading it with the
+// key of the feed if it wasn't mirrored yet. The original URL is returned
+// if mirroring is disabled or fails.
+func (a *Atomstr) mirrorURL(feedItem feedStruct, sourceURL string) string {
+	if !blossomEnabled() || sourceURL == "" || !strings.HasPrefix(sourceURL, "http") || blobHash(sourceURL) != "" {
+		return sourceURL
+	}
+	var blossomURL string
+	err := a.db.QueryRow(`SELECT blossom_url FROM blossom_blobs WHERE source_url = ?`, sourceURL).Scan(&blossomURL)
+	if err == nil {
+		return blossomURL
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("[ERROR] can't read blossom blobs: %v", err)
+		return sourceURL
+	}
+
+	blob, err := mirrorToBlossom(feedItem.Sec, sourceURL)
+	if err != nil {
+		log.Printf("[WARN] Can't mirror %s to %s: %v", sourceURL, blossomServer, err)
+		return sourceURL
+	}
+	if _, err := a.db.Exec(`INSERT OR REPLACE INTO blossom_blobs (source_url, blossom_url, sha256, mime_type, size, uploaded_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		sourceURL, blob.URL, blob.SHA256, blob.Type, blob.Size, time.Now().UTC()); err != nil {
+		log.Printf("[ERROR] can't write blossom blobs: %v", err)
+	}
+	log.Printf("[DEBUG] Mirrored %s to %s", sourceURL, blob.URL)
+	return blob.URL
+}
+
+// mirrorPostMedia replaces the image URLs of a feed item with mirrored
+// copies on the Blossom server.
+func (a *Atomstr) mirrorPostMedia(feedItem feedStruct, feedPost *gofeed.Item) {
+	if !blossomEnabled() {
+		return
+	}
+	feedPost.Description = a.mirrorHTMLImages(feedItem, feedPost.Description)
+	feedPost.Content = a.mirrorHTMLImages(feedItem, feedPost.Content)
+	if feedPost.Image != nil {
+		feedPost.Image.URL = a.mirrorURL(feedItem, feedPost.Image.URL)
+	}
+	for _, enclosure := range feedPost.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") {
+			enclosure.URL = a.mirrorURL(feedItem, enclosure.URL)
+		}
+	}
+	a.mirrorMediaExtensions(feedItem, feedPost.Extensions["media"])
+}
+
+// mirrorMediaExtensions mirrors the images of media:content and
+// media:thumbnail elements, so their metadata still applies.
+func (a *Atomstr) mirrorMediaExtensions(feedItem feedStruct, elements map[string][]ext.Extension) {
+	for _, group := range elements["group"] {
+		a.mirrorMediaExtensions(feedItem, group.Children)
+	}
+	for _, name := range []string{"content", "thumbnail"} {
+		for _, element := range elements[name] {
+			isImage := name == "thumbnail" || element.Attrs["medium"] == "image" || strings.HasPrefix(element.Attrs["type"], "image/")
+			if isImage && element.Attrs["url"] != "" {
+				element.Attrs["url"] = a.mirrorURL(feedItem, element.Attrs["url"])
+			}
+		}
+	}
+}
+
+func (a *Atomstr) mirrorHTMLImages(feedItem feedStruct, rawHTML string) string {
+	if !strings.Contains(rawHTML, "<img") {
+		return rawHTML
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rawHTML))
+	if err != nil {
+		return rawHTML
+	}
+	doc.Find("img[src]").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		if isIconURL(src) {
+			return
+		}
+		s.SetAttr("src", a.mirrorURL(feedItem, src))
+		s.RemoveAttr("srcset") // would still point to the publisher
+	})
+	mirrored, err := doc.Find("body").Html()
+	if err != nil {
+		return rawHTML
+	}
+	return mirrored
+}
+
+// mirrorToBlossom downloads a file and uploads it to the Blossom server
+// (BUD-02), authorized by a kind 24242 event signed with sec.
+func mirrorToBlossom(sec, sourceURL string) (blobDescriptor, error) {
+	var blob blobDescriptor
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	body, contentType, err := downloadMedia(ctx, sourceURL)
+	if err != nil {
+		return blob, err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	auth := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      24242,
+		Tags: nostr.Tags{
+			{"t", "upload"},
+			{"x", hash},
+			{"expiration", strconv.FormatInt(time.Now().Add(5*time.Minute).Unix(), 10)},
+		},
+		Content: "Upload " + path.Base(strings.SplitN(sourceURL, "?", 2)[0]),
+	}
+	if err := auth.Sign(sec); err != nil {
+		return blob, fmt.Errorf("can't sign upload authorization: %w", err)
+	}
+	authJSON, _ := json.Marshal(auth)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", strings.TrimSuffix(blossomServer, "/")+"/upload", bytes.NewReader(body))
+	if err != nil {
+		return blob, err
+	}
+	req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(authJSON))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return blob, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return blob, fmt.Errorf("upload failed: HTTP %d %s", resp.StatusCode, resp.Header.Get("X-Reason"))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&blob); err != nil {
+		return blob, fmt.Errorf("invalid blob descriptor: %w", err)
+	}
+	if blob.SHA256 != hash {
+		return blob, fmt.Errorf("server returned hash %s, expected %s", blob.SHA256, hash)
+	}
+	if blob.URL == "" {
+		return blob, fmt.Errorf("server returned no URL")
+	}
+	return blob, nil
+}
+
+// downloadMedia fetches a media file up to maxMirrorSize.
+func downloadMedia(ctx context.Context, sourceURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMirrorSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxMirrorSize {
+		return nil, "", fmt.Errorf("file larger than %d bytes", maxMirrorSize)
+	}
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(body)
+	}
+	return body, contentType, nil
+}
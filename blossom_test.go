@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testBlossomServer is a Blossom server that checks the upload authorization
+// of pubkey and stores nothing.
+func testBlossomServer(t *testing.T, pubkey string, uploads *int64) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/upload" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt64(uploads, 1)
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		authJSON, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Nostr "))
+		if err != nil {
+			t.Errorf("invalid authorization header: %v", err)
+		}
+		var auth nostr.Event
+		if err := json.Unmarshal(authJSON, &auth); err != nil {
+			t.Errorf("invalid authorization event: %v", err)
+		}
+		if ok, err := auth.CheckSignature(); !ok || err != nil {
+			t.Errorf("invalid signature of authorization event: %v", err)
+		}
+		if auth.Kind != 24242 || auth.PubKey != pubkey {
+			t.Errorf("authorization event of kind %d by %s", auth.Kind, auth.PubKey)
+		}
+		if auth.Tags.GetFirst([]string{"t", "upload"}) == nil || auth.Tags.GetFirst([]string{"x", hash}) == nil {
+			t.Errorf("authorization event without upload and hash tags: %v", auth.Tags)
+		}
+		var expiration int64
+		if tag := auth.Tags.GetFirst([]string{"expiration", ""}); tag != nil {
+			expiration, _ = strconv.ParseInt(tag.Value(), 10, 64)
+		}
+		if expiration <= time.Now().Unix() {
+			t.Errorf("authorization event without future expiration: %v", auth.Tags)
+		}
+		if r.Header.Get("Content-Type") != "image/png" {
+			t.Errorf("upload with content type %q", r.Header.Get("Content-Type"))
+		}
+		json.NewEncoder(w).Encode(blobDescriptor{
+			URL:    server.URL + "/" + hash + ".png",
+			SHA256: hash,
+			Size:   int64(len(body)),
+			Type:   "image/png",
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMirrorPostMedia(t *testing.T) {
+	allowTestServer(t)
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.com/feed")
+
+	var uploads int64
+	blossom := testBlossomServer(t, feedItem.Pub, &uploads)
+	defer func(server string) { blossomServer = server }(blossomServer)
+	blossomServer = blossom.URL
+
+	image := "\x89PNG\r\n\x1a\nimage data"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png", "/thumb.png":
+			fmt.Fprint(w, image)
+		case "/large.png":
+			w.Write(make([]byte, maxMirrorSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	sum := sha256.Sum256([]byte(image))
+	mirrored := blossom.URL + "/" + hex.EncodeToString(sum[:]) + ".png"
+	feedPost := &gofeed.Item{
+		Description: fmt.Sprintf(`<p><img src="%[1]s/image.png" srcset="%[1]s/image.png 2x"><img src="%[1]s/favicon.ico"></p>`, origin.URL),
+		Image:       &gofeed.Image{URL: origin.URL + "/large.png"},
+		Enclosures: []*gofeed.Enclosure{
+			{URL: origin.URL + "/thumb.png", Type: "image/png"},
+			{URL: origin.URL + "/episode.mp3", Type: "audio/mpeg"},
+			{URL: origin.URL + "/missing.png", Type: "image/png"},
+		},
+	}
+	a.mirrorPostMedia(*feedItem, feedPost)
+
+	wantDescription := fmt.Sprintf(`<p><img src="%s"/><img src="%s/favicon.ico"/></p>`, mirrored, origin.URL)
+	if feedPost.Description != wantDescription {
+		t.Errorf("description = %s, want %s", feedPost.Description, wantDescription)
+	}
+	// too large or missing files and other media keep their URL
+	if feedPost.Image.URL != origin.URL+"/large.png" {
+		t.Errorf("large image was mirrored: %s", feedPost.Image.URL)
+	}
+	wantEnclosures := []string{mirrored, origin.URL + "/episode.mp3", origin.URL + "/missing.png"}
+	for i, enclosure := range feedPost.Enclosures {
+		if enclosure.URL != wantEnclosures[i] {
+			t.Errorf("enclosure %d = %s, want %s", i, enclosure.URL, wantEnclosures[i])
+		}
+	}
+	if uploads != 2 {
+		t.Errorf("%d uploads, want 2", uploads)
+	}
+
+	// mirrored files are looked up in the database
+	if got := a.mirrorURL(*feedItem, origin.URL+"/image.png"); got != mirrored {
+		t.Errorf("mirrorURL() = %s, want %s", got, mirrored)
+	}
+	if got := a.mirrorURL(*feedItem, mirrored); got != mirrored {
+		t.Errorf("mirrorURL() of a Blossom URL = %s", got)
+	}
+	if uploads != 2 {
+		t.Errorf("file was uploaded again")
+	}
+	if blobHash(mirrored) != hex.EncodeToString(sum[:]) || blobHash(origin.URL+"/image.png") != "" {
+		t.Error("blobHash() didn't recognize Blossom URLs")
+	}
+}
+
+func TestMirrorToBlossomRejected(t *testing.T) {
+	allowTestServer(t)
+	blossom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "quota exceeded")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer blossom.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "\x89PNG\r\n\x1a\nimage data")
+	}))
+	defer origin.Close()
+	defer func(server string) { blossomServer = server }(blossomServer)
+	blossomServer = blossom.URL
+
+	_, err := mirrorToBlossom(nostr.GeneratePrivateKey(), origin.URL+"/image.png")
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("mirrorToBlossom() = %v, want rejected upload", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-52 calendar event kinds
+const (
+	kindDateCalendarEvent = 31922
+	kindTimeCalendarEvent = 31923
+)
+
+// checkValidCalendarSource creates the feed of an iCalendar source. The
+// calendar takes the place of the feed items.
+func checkValidCalendarSource(feedURL string, body []byte) (*feedStruct, error) {
+	feedItem := feedStruct{URL: feedURL, Link: feedURL, State: "active"}
+	cal, err := parseCalendar(body)
+	if err != nil {
+		log.Println("[ERROR] Not a valid calendar")
+		return &feedItem, err
+	}
+	feedItem.Calendar = cal
+	feedItem.Title = cal.Name
+	if feedItem.Title == "" {
+		feedItem.Title = feedURL
+	}
+	feedItem.Description = cal.Description
+	feedItem.Image = fetchFavicon(feedURL)
+	return &feedItem, nil
+}
+
+// processCalendar updates the feed info of a calendar source and publishes
+// its events.
+func (a *Atomstr) processCalendar(feedItem feedStruct, cal *calendar, stats *scrapeStats) {
+	if cal.Name != "" && cal.Name != feedItem.Title {
+		if err := a.dbUpdateFeedInfo(feedItem.URL, cal.Name, feedItem.URL); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		feedItem.Title = cal.Name
+	}
+	feedItem.Description = cal.Description
+	a.publishCalendar(feedItem, cal, stats)
+	log.Println("[DEBUG] Finished updating calendar ", feedItem.URL)
+}
+
+// publishCalendar publishes the upcoming occurrences of a calendar within
+// calendarWindow. Occurrences that changed are replaced, cancelled and
+// removed ones are deleted.
+func (a *Atomstr) publishCalendar(feedItem feedStruct, cal *calendar, stats *scrapeStats) {
+	now := time.Now()
+	until := now.Add(calendarWindow)
+	rules, err := a.dbGetFeedFilters(feedItem.SourcePub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+
+	current := make(map[string]bool)
+	for _, occurrence := range cal.occurrences(now, until) {
+		key := occurrence.key()
+		published := a.dbGetPublishedItem(feedItem.Pub, key)
+		if occurrence.Status == "CANCELLED" {
+			if published != nil {
+				a.deleteCalendarEvent(feedItem, published, "cancelled")
+			}
+			continue
+		}
+		if ok, reason := filterPost(rules, occurrence.feedItem()); !ok {
+			log.Printf("[DEBUG] Filtered calendar event %s from %s: %s", key, feedItem.URL, reason)
+			if stats != nil {
+				atomic.AddInt64(&stats.postsFiltered, 1)
+			}
+			continue
+		}
+		current[key] = true
+
+		ev := buildCalendarEvent(feedItem, occurrence)
+		hash := calendarEventHash(ev)
+		if published != nil && published.ContentHash == hash {
+			continue
+		}
+		if published != nil {
+			log.Printf("[INFO] Calendar event %s from %s changed, publishing update", key, feedItem.URL)
+			if published.Kind != ev.Kind {
+				// e.g. an all-day event got a time, the old one isn't replaced
+				a.nostrPostItem(buildDeletionEvent(feedItem, published, "updated by the calendar"), feedItem.publishRelays())
+			}
+		}
+		ev.Sign(feedItem.Sec)
+		a.nostrPostItem(ev, feedItem.publishRelays())
+		if stats != nil {
+			atomic.AddInt64(&stats.postsPublished, 1)
+		}
+		if dryRunMode {
+			continue
+		}
+		start := occurrence.Start
+		item := publishedItem{ItemKey: key, EventID: ev.ID, Kind: ev.Kind, ContentHash: hash, ItemTime: &start}
+		if err := a.dbMarkPostPublished(feedItem, item); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+
+	// upcoming events that are gone from the calendar
+	upcoming, err := a.dbGetPublishedItemsSince(feedItem, now)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	for _, published := range upcoming {
+		if current[published.ItemKey] || published.ItemTime == nil || published.ItemTime.After(until) {
+			continue
+		}
+		a.deleteCalendarEvent(feedItem, &published, "removed from the calendar")
+	}
+}
+
+func (a *Atomstr) deleteCalendarEvent(feedItem feedStruct, published *publishedItem, reason string) {
+	log.Printf("[INFO] Calendar event %s was %s on %s, publishing deletion", published.ItemKey, reason, feedItem.URL)
+	a.nostrPostItem(buildDeletionEvent(feedItem, published, reason), feedItem.publishRelays())
+	if dryRunMode {
+		return
+	}
+	if err := a.dbDeletePublishedItem(feedItem.Pub, published.ItemKey); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// feedItem maps an event to a feed item, so the filter rules of the feed
+// apply to calendars as well.
+func (e calendarEvent) feedItem() *gofeed.Item {
+	return &gofeed.Item{
+		Title:       e.Summary,
+		Description: e.Description,
+		Link:        e.URL,
+		Categories:  e.Categories,
+	}
+}
+
+// buildCalendarEvent creates an unsigned NIP-52 event for an occurrence:
+// kind 31922 for all-day events, kind 31923 for events with a time. The d
+// tag is derived from the occurrence key, so updates replace the event.
+func buildCalendarEvent(feedItem feedStruct, event calendarEvent) nostr.Event {
+	title := event.Summary
+	if title == "" {
+		title = "Untitled event"
+	}
+	kind := kindTimeCalendarEvent
+	tags := nostr.Tags{{"d", articleIdentifier(event.key())}, {"title", title}}
+	if event.AllDay {
+		kind = kindDateCalendarEvent
+		tags = append(tags, nostr.Tag{"start", event.Start.Format("2006-01-02")})
+		// the end date is exclusive, like DTEND
+		if event.End.After(event.Start.AddDate(0, 0, 1)) {
+			tags = append(tags, nostr.Tag{"end", event.End.Format("2006-01-02")})
+		}
+	} else {
+		tags = append(tags, nostr.Tag{"start", strconv.FormatInt(event.Start.Unix(), 10)})
+		if event.End.After(event.Start) {
+			tags = append(tags, nostr.Tag{"end", strconv.FormatInt(event.End.Unix(), 10)})
+		}
+		if zone := event.Start.Location().String(); zone != "UTC" && zone != "Local" {
+			tags = append(tags, nostr.Tag{"start_tzid", zone}, nostr.Tag{"end_tzid", event.End.Location().String()})
+		}
+		// D tags index the days the event touches
+		for day := event.Start.Unix() / 86400; day <= max(event.End.Unix()-1, event.Start.Unix())/86400; day++ {
+			tags = append(tags, nostr.Tag{"D", strconv.FormatInt(day, 10)})
+		}
+	}
+	if event.Location != "" {
+		tags = append(tags, nostr.Tag{"location", event.Location})
+	}
+	for _, category := range event.Categories {
+		tags = append(tags, nostr.Tag{"t", category})
+	}
+	if event.URL != "" {
+		tags = append(tags, nostr.Tag{"r", event.URL})
+	}
+	tags = append(tags, nostr.Tag{"alt", "Calendar event: " + title})
+
+	return nostr.Event{
+		PubKey: feedItem.Pub,
+		// a replacement must be newer than the event it replaces
+		CreatedAt: nostr.Now(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   event.Description,
+	}
+}
+
+// calendarEventHash covers the tags as well as the content, most changes
+// of an event (time, location) are in tags.
+func calendarEventHash(ev nostr.Event) string {
+	tags, _ := json.Marshal(ev.Tags)
+	return postContentHash(strconv.Itoa(ev.Kind) + string(tags) + ev.Content)
+}
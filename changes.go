@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// updateChangedPost republishes an already published item if the item in
+// the feed changed, or deletes it if a filter rule drops it now. Notes are
+// deleted (NIP-09) and posted again, addressable events like articles are
+// simply replaced by a newer version. published has to be the item as
+// published by the feed itself, not by another source of its identity.
+func (a *Atomstr) updateChangedPost(feedItem feedStruct, feedPost *gofeed.Item, postID string, itemTime *time.Time, published *publishedItem) {
+	contentHash := itemContentHash(feedPost)
+	if contentHash == published.ContentHash {
+		return
+	}
+	// an edit can make the item match a filter rule, e.g. a new category
+	rules, err := a.dbGetFeedFilters(feedItem.SourcePub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	if ok, reason := a.filterAndExpandPost(feedItem, feedPost, rules); !ok {
+		log.Printf("[INFO] Changed post %s from %s is filtered (%s), publishing deletion", postID, feedItem.URL, reason)
+		a.nostrPostItem(buildDeletionEvent(feedItem, published, "filtered after an update"), feedItem.publishRelays())
+		if dryRunMode {
+			return
+		}
+		if err := a.dbDeletePublishedItem(feedItem.Pub, postID); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		return
+	}
+	a.mirrorPostMedia(feedItem, feedPost)
+	events := buildPostEvents(feedItem, feedPost, postID, itemTime.Unix())
+	log.Printf("[INFO] Post %s from %s changed, publishing update", postID, feedItem.URL)
+
+	if nostr.IsAddressableKind(events[0].Kind) && events[0].Kind == published.Kind {
+		// a replacement must be newer than the event it replaces
+		article := events[0]
+		article.CreatedAt = nostr.Now()
+		article.Sign(feedItem.Sec)
+		// keep the teaser that already announced the article
+		events = []nostr.Event{article}
+	} else {
+		a.nostrPostItem(buildDeletionEvent(feedItem, published, "updated by the feed"), feedItem.publishRelays())
+	}
+
+	for _, ev := range events {
+		a.nostrPostItem(ev, feedItem.publishRelays())
+	}
+
+	if dryRunMode {
+		return
+	}
+	item := newPublishedItem(postID, itemTime, events)
+	item.Link = feedPost.Link
+	item.ContentHash = contentHash
+	if item.TeaserID == "" {
+		item.TeaserID = published.TeaserID
+	}
+	if err := a.dbMarkPostPublished(feedItem, item); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// deleteRemovedPosts publishes deletions for items that were removed from the
+// feed. Only items newer than the oldest item still in the feed are
+// considered, older ones may simply have dropped off the end of the feed.
+func (a *Atomstr) deleteRemovedPosts(feedItem feedStruct, items []*gofeed.Item) {
+	var oldest *time.Time
+	current := make(map[string]bool)
+	for _, item := range items {
+		postID := item.GUID
+		if postID == "" {
+			postID = item.Link
+		}
+		current[postID] = true
+		if itemTime, err := parseFeedDate(item); err == nil && (oldest == nil || itemTime.Before(*oldest)) {
+			oldest = itemTime
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	removed, err := a.dbGetPublishedItemsSince(feedItem, *oldest)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	for _, published := range removed {
+		if current[published.ItemKey] {
+			continue
+		}
+		log.Printf("[INFO] Post %s was removed from %s, publishing deletion", published.ItemKey, feedItem.URL)
+		a.nostrPostItem(buildDeletionEvent(feedItem, &published, "removed from the feed"), feedItem.publishRelays())
+		if dryRunMode {
+			continue
+		}
+		if err := a.dbDeletePublishedItem(feedItem.Pub, published.ItemKey); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+}
+
+// buildDeletionEvent creates a signed NIP-09 deletion request for all events
+// that were published for an item.
+func buildDeletionEvent(feedItem feedStruct, published *publishedItem, reason string) nostr.Event {
+	tags := nostr.Tags{{"e", published.EventID}}
+	if published.TeaserID != "" {
+		tags = append(tags, nostr.Tag{"e", published.TeaserID})
+		tags = append(tags, nostr.Tag{"k", strconv.Itoa(nostr.KindTextNote)})
+	}
+	if nostr.IsAddressableKind(published.Kind) {
+		tags = append(tags, nostr.Tag{"a", fmt.Sprintf("%d:%s:%s", published.Kind, feedItem.Pub, articleIdentifier(published.ItemKey))})
+	}
+	tags = append(tags, nostr.Tag{"k", strconv.Itoa(published.Kind)})
+
+	ev := nostr.Event{
+		PubKey:    feedItem.Pub,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Tags:      tags,
+		Content:   reason,
+	}
+	ev.Sign(feedItem.Sec)
+	return ev
+}
+
+// dbGetPublishedItemsSince returns the items of the feed (not those of other
+// sources of its identity) with an item time since since.
+func (a *Atomstr) dbGetPublishedItemsSince(feedItem feedStruct, since time.Time) ([]publishedItem, error) {
+	rows, err := a.db.Query(`SELECT item_key, event_id, teaser_event_id, event_kind, content_hash, item_time, link FROM published_items WHERE feed_pub = ? AND source_pub = ? AND item_time >= ?`,
+		feedItem.Pub, feedItem.SourcePub, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("returning published items from DB failed: %w", err)
+	}
+	defer rows.Close()
+
+	var items []publishedItem
+	for rows.Next() {
+		item := publishedItem{}
+		if err := rows.Scan(&item.ItemKey, &item.EventID, &item.TeaserID, &item.Kind, &item.ContentHash, &item.ItemTime, &item.Link); err != nil {
+			return nil, fmt.Errorf("scanning for published items failed: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -10,6 +10,7 @@ import (
 
 var (
 	fetchInterval, _                  = time.ParseDuration(getEnv("FETCH_INTERVAL", "15m"))
+	maxFetchInterval, _               = time.ParseDuration(getEnv("MAX_FETCH_INTERVAL", "4h"))
 	metadataInterval, _               = time.ParseDuration(getEnv("METADATA_INTERVAL", "12h"))
 	historyInterval, _                = time.ParseDuration(getEnv("HISTORY_INTERVAL", "1h"))
 	logLevel                          = getEnv("LOG_LEVEL", "INFO")
@@ -21,15 +22,33 @@ var (
 	blasterRelays                     = splitAndTrim(getEnv("ATOMSTR_BLASTER_RELAYS", "wss://sendit.nosflare.com"))
 	defaultFeedImage                  = getEnv("DEFAULT_FEED_IMAGE", "https://upload.wikimedia.org/wikipedia/en/thumb/4/43/Feed-icon.svg/256px-Feed-icon.svg.png")
 	dbPath                            = getEnv("DB_PATH", "./atomstr.db")
+	videoPublishMode                  = getEnv("VIDEO_PUBLISH_MODE", "note")
+	noteTemplate                      = getEnv("NOTE_TEMPLATE", "default")
+	adminToken                        = getEnv("ADMIN_TOKEN", "")
+	websubCallbackBase                = getEnv("WEBSUB_CALLBACK_URL", "")
 	maxFailureAttempts, _             = strconv.Atoi(getEnv("MAX_FAILURE_ATTEMPTS", "3"))
 	maxFailureDelete, _               = strconv.Atoi(getEnv("MAX_FAILURE_DELETE", "100"))
 	brokenFeedRetryInterval, _        = time.ParseDuration(getEnv("BROKEN_FEED_RETRY_INTERVAL", "24h"))
+	maxBacklog, _                     = time.ParseDuration(getEnv("MAX_BACKLOG", "24h"))
+	outboxRetryInterval, _            = time.ParseDuration(getEnv("OUTBOX_RETRY_INTERVAL", "1m"))
+	outboxMaxBackoff, _               = time.ParseDuration(getEnv("OUTBOX_MAX_BACKOFF", "6h"))
+	outboxMaxAge, _                   = time.ParseDuration(getEnv("OUTBOX_MAX_AGE", "72h"))
+	blossomServer                     = getEnv("BLOSSOM_SERVER", "")
+	probeMedia, _                     = strconv.ParseBool(getEnv("MEDIA_PROBE", "false"))
+	calendarWindow, _                 = time.ParseDuration(getEnv("CALENDAR_WINDOW", "2160h"))
+	publishedRetention, _             = time.ParseDuration(getEnv("PUBLISHED_RETENTION", "720h"))
+	fetchAllowlist                    = splitAndTrim(getEnv("FETCH_ALLOWLIST", ""))
+	maxFetchSizeMB, _                 = strconv.Atoi(getEnv("FETCH_MAX_SIZE_MB", "25"))
 	dryRunMode                        = false
 	atomstrVersion             string = "0.9.13"
 )
 
 type Atomstr struct {
-	db *sql.DB
+	db        *sql.DB
+	pool      *relayPool
+	scheduler *scheduler
+	feedLocks feedLocks
+	stats     *scrapeStats // of the scheduler, also counts WebSub pushes
 }
 
 var sqlInit = `
@@ -56,30 +75,65 @@ type feedStruct struct {
 	Posts        []*gofeed.Item
 	State        string
 	FailureCount int
+	BackoffCount int
 	LastSuccess  *time.Time
 	LastFailure  *time.Time
 	ETag         string
 	LastModified string
+	LastItemAt   *time.Time
+	NextFetchAt  *time.Time
+	PollInterval time.Duration
+	Hints        feedHints
+	Hub          string // WebSub hub found by checkValidFeedSource
+	Topic        string // self link of the feed, also the WebSub topic
+	Podcast      *podcastInfo
+	Calendar     *calendar     // set instead of Posts for iCalendar sources
+	Scrape       *scrapeConfig // selectors of HTML pages without a feed
+	// PushLeaseExpires is set while the feed has an active WebSub subscription
+	PushLeaseExpires *time.Time
+	Settings         feedSettings
+	// Sec, Pub and Npub are the keys of the identity the feed publishes as,
+	// SourcePub identifies the feed itself. They differ for feeds that were
+	// merged into the identity of another feed.
+	SourcePub      string
+	Profile        identityProfile
+	PrimarySource  bool // the oldest source of the identity, it publishes the profile
+	SharedIdentity bool // other sources publish as the same identity
 }
 
 type webIndex struct {
 	Relays  []string
 	Feeds   []feedStruct
 	Version string
+	Admin   bool
 }
 type webAddFeed struct {
-	Status string
-	Feed   feedStruct
+	Status     string
+	Feed       feedStruct
+	Candidates []feedCandidate
+}
+
+type webFeedSettings struct {
+	Status   string
+	Feed     feedStruct
+	Settings []webFeedSetting
+	Filters  []filterRule
+}
+type webFeedSetting struct {
+	Key         string
+	Description string
+	Value       string
 }
 
 type asyncJob struct {
-	ID      string
-	URL     string
-	Status  string // "processing", "completed", "failed"
-	Message string
-	Error   string
-	FeedURL string
-	Npub    string
+	ID         string
+	URL        string
+	Status     string // "processing", "completed", "failed"
+	Message    string
+	Error      string
+	FeedURL    string
+	Npub       string
+	Candidates []feedCandidate
 }
 
 type asyncResponse struct {
@@ -88,9 +142,10 @@ type asyncResponse struct {
 }
 
 type statusResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
-	URL     string `json:"url,omitempty"`
-	Npub    string `json:"npub,omitempty"`
+	Status     string          `json:"status"`
+	Message    string          `json:"message,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	Npub       string          `json:"npub,omitempty"`
+	Candidates []feedCandidate `json:"candidates,omitempty"`
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// commonFeedPaths are tried when a page doesn't link its feeds.
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml", "/index.xml"}
+
+// feedLinkTypes are the MIME types of feed links in HTML pages.
+var feedLinkTypes = []string{"application/rss+xml", "application/atom+xml", "application/feed+json", "application/json"}
+
+// maxPageSize limits the size of web pages and feeds fetched during
+// discovery. Anyone can add feeds, so it's well below FETCH_MAX_SIZE_MB.
+const maxPageSize = 5 << 20
+
+// feedCandidate is a feed found on a web page.
+type feedCandidate struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// multipleFeedsError is returned if a web page offers more than one feed.
+// The user has to pick one of the candidates.
+type multipleFeedsError struct {
+	PageURL    string
+	Candidates []feedCandidate
+}
+
+func (e *multipleFeedsError) Error() string {
+	return fmt.Sprintf("found %d feeds on %s", len(e.Candidates), e.PageURL)
+}
+
+// findFeedSource is checkValidFeedSource with autodiscovery: if feedURL is
+// not a feed, the feeds offered by the web page are used instead. It picks
+// the feed if there is exactly one, and returns a *multipleFeedsError if
+// there are more.
+func findFeedSource(feedURL string) (*feedStruct, error) {
+	feedItem, err := checkValidFeedSource(feedURL)
+	if err == nil {
+		return feedItem, nil
+	}
+
+	candidates := discoverFeeds(feedURL)
+	switch len(candidates) {
+	case 0:
+		return feedItem, err
+	case 1:
+		log.Printf("[INFO] Discovered feed %s on %s", candidates[0].URL, feedURL)
+		return checkValidFeedSource(candidates[0].URL)
+	default:
+		return feedItem, &multipleFeedsError{PageURL: feedURL, Candidates: candidates}
+	}
+}
+
+// discoverFeeds returns the feeds linked by a web page with
+// <link rel="alternate">, or else the feeds found at common paths.
+func discoverFeeds(pageURL string) []feedCandidate {
+	body, err := fetchPage(pageURL)
+	if err != nil {
+		log.Printf("[DEBUG] Can't fetch %s for feed discovery: %v", pageURL, err)
+		return nil
+	}
+
+	var candidates []feedCandidate
+	seen := make(map[string]bool)
+	if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body)); err == nil {
+		base := pageURL
+		if href, ok := doc.Find("base[href]").Attr("href"); ok {
+			base = resolveURL(pageURL, href)
+		}
+		doc.Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+			rel, _ := s.Attr("rel")
+			linkType, _ := s.Attr("type")
+			href, _ := s.Attr("href")
+			if !hasToken(rel, "alternate") || !isFeedLinkType(linkType) {
+				return
+			}
+			feedURL := resolveURL(base, strings.TrimSpace(href))
+			if feedURL == "" || seen[feedURL] {
+				return
+			}
+			// plain JSON links are often APIs (e.g. wp-json), not JSON Feeds.
+			// Any JSON parses as a JSON Feed, a real one has a version.
+			if strings.EqualFold(strings.TrimSpace(linkType), "application/json") {
+				if feed, err := fetchFeedDocument(feedURL); err != nil || feed.FeedVersion == "" {
+					return
+				}
+			}
+			seen[feedURL] = true
+			title, _ := s.Attr("title")
+			candidates = append(candidates, feedCandidate{URL: feedURL, Title: strings.TrimSpace(title)})
+		})
+	}
+	if len(candidates) > 0 {
+		return candidates
+	}
+
+	for _, path := range commonFeedPaths {
+		feedURL := resolveURL(pageURL, path)
+		if feedURL == "" || seen[feedURL] {
+			continue
+		}
+		seen[feedURL] = true
+		if feed, err := fetchFeedDocument(feedURL); err == nil {
+			candidates = append(candidates, feedCandidate{URL: feedURL, Title: feed.Title})
+		}
+	}
+	return dropDuplicateFeeds(candidates)
+}
+
+// dropDuplicateFeeds removes candidates with the same feed title, which are
+// usually the same feed served at several of the common paths.
+func dropDuplicateFeeds(candidates []feedCandidate) []feedCandidate {
+	var unique []feedCandidate
+	titles := make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate.Title != "" && titles[candidate.Title] {
+			continue
+		}
+		titles[candidate.Title] = true
+		unique = append(unique, candidate)
+	}
+	return unique
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFeedLinkType(linkType string) bool {
+	linkType = strings.ToLower(strings.TrimSpace(linkType))
+	for _, t := range feedLinkTypes {
+		if linkType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func fetchPage(pageURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+}
+
+func fetchFeedDocument(feedURL string) (*gofeed.Feed, error) {
+	body, err := fetchPage(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	return gofeed.NewParser().Parse(bytes.NewReader(body))
+}
+
+// printFeedCandidates lists the feeds found on a page for the CLI.
+func printFeedCandidates(err *multipleFeedsError) {
+	fmt.Printf("Found %d feeds on %s, add one of them:\n", len(err.Candidates), err.PageURL)
+	for _, candidate := range err.Candidates {
+		if candidate.Title != "" {
+			fmt.Printf("  %s (%s)\n", candidate.URL, candidate.Title)
+		} else {
+			fmt.Printf("  %s\n", candidate.URL)
+		}
+	}
+}
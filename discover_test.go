@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// allowTestServer lets the fetch transport connect to httptest servers.
+func allowTestServer(t *testing.T) {
+	t.Helper()
+	allowlist := fetchAllowlist
+	t.Cleanup(func() { fetchAllowlist = allowlist })
+	fetchAllowlist = []string{"127.0.0.1"}
+}
+
+func testRSS(title string) string {
+	return fmt.Sprintf(`<?xml version="1.0"?><rss version="2.0"><channel><title>%s</title><link>https://example.org/</link></channel></rss>`, title)
+}
+
+func TestDiscoverFeeds(t *testing.T) {
+	allowTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/linked", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+			<link rel="stylesheet" href="/style.css">
+			<link rel="alternate" type="application/rss+xml" title="Posts" href="/posts.xml">
+			<link rel="Alternate" type="application/atom+xml" title="Comments" href="comments.atom">
+			<link rel="alternate" type="application/rss+xml" title="Posts again" href="/posts.xml">
+			<link rel="alternate" type="application/json" href="/wp-json/">
+			<link rel="alternate" type="application/json" title="JSON" href="/feed.json">
+			<link rel="alternate" type="text/html" hreflang="de" href="/de/">
+			</head></html>`)
+	})
+	mux.HandleFunc("/wp-json/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "not a feed"}`)
+	})
+	mux.HandleFunc("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"version": "https://jsonfeed.org/version/1.1", "title": "JSON", "items": []}`)
+	})
+	mux.HandleFunc("/plain/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>No feed links</title></head></html>`)
+	})
+	// the same feed at two common paths
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, testRSS("Plain")) })
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, testRSS("Plain")) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		page string
+		want []feedCandidate
+	}{
+		{"linked feeds", "/linked", []feedCandidate{
+			{URL: server.URL + "/posts.xml", Title: "Posts"},
+			{URL: server.URL + "/comments.atom", Title: "Comments"},
+			{URL: server.URL + "/feed.json", Title: "JSON"},
+		}},
+		{"common paths", "/plain/", []feedCandidate{{URL: server.URL + "/feed", Title: "Plain"}}},
+		{"unreachable", "/missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := discoverFeeds(server.URL + tt.page)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("discoverFeeds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropDuplicateFeeds(t *testing.T) {
+	candidates := []feedCandidate{
+		{URL: "https://example.org/feed", Title: "Example"},
+		{URL: "https://example.org/rss.xml", Title: "Example"},
+		{URL: "https://example.org/atom.xml", Title: "Example comments"},
+		{URL: "https://example.org/index.xml"},
+		{URL: "https://example.org/feed.json"},
+	}
+	want := []feedCandidate{candidates[0], candidates[2], candidates[3], candidates[4]}
+	if got := dropDuplicateFeeds(candidates); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("dropDuplicateFeeds() = %v, want %v", got, want)
+	}
+}
+
+func TestFetchPageSizeLimit(t *testing.T) {
+	allowTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxPageSize+1))
+	}))
+	defer server.Close()
+
+	body, err := fetchPage(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != maxPageSize {
+		t.Errorf("fetchPage() read %d bytes, want %d", len(body), maxPageSize)
+	}
+}
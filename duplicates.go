@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// duplicateItemOverlap is the share of the items of the smaller feed that
+// have to appear in the other feed as well, for two feeds to be reported as
+// duplicates. minDuplicateItems keeps feeds with few items from matching.
+const (
+	duplicateItemOverlap = 0.5
+	minDuplicateItems    = 3
+)
+
+// feedburnerHosts serve the same feeds under different names.
+var feedburnerHosts = map[string]bool{
+	"feeds.feedburner.com":  true,
+	"feeds2.feedburner.com": true,
+	"feedproxy.google.com":  true,
+}
+
+// normalizeFeedURL cleans up a feed URL: scheme and host are lowercased,
+// default ports, fragments and tracking parameters removed and the query
+// sorted. Servers normally ignore the order of parameters and tracking
+// parameters, but nothing guarantees it.
+func normalizeFeedURL(feedURL string) string {
+	feedURL = strings.TrimSpace(feedURL)
+	parsed, err := url.Parse(feedURL)
+	if err != nil || parsed.Host == "" {
+		return feedURL
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	if port := parsed.Port(); (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		parsed.Host = parsed.Hostname()
+	}
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+
+	// the parameters are kept as they are, only sorted
+	var params []string
+	for _, param := range strings.Split(parsed.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		name = strings.ToLower(name)
+		if param == "" || strings.HasPrefix(name, "utm_") || name == "fbclid" || name == "gclid" {
+			continue
+		}
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	parsed.RawQuery = strings.Join(params, "&")
+	return parsed.String()
+}
+
+// feedURLKey returns the form of a feed URL used to find duplicates. URLs
+// that only differ in their scheme, a www. prefix, a trailing slash or the
+// feedburner host have the same key. The key isn't necessarily fetchable.
+func feedURLKey(feedURL string) string {
+	parsed, err := url.Parse(normalizeFeedURL(feedURL))
+	if err != nil || parsed.Host == "" {
+		return feedURL
+	}
+	host := strings.TrimPrefix(parsed.Host, "www.")
+	path := strings.TrimSuffix(parsed.EscapedPath(), "/")
+	if feedburnerHosts[host] {
+		// feedburner names are case insensitive
+		host = "feedburner"
+		path = strings.ToLower(path)
+	}
+	key := host + path
+	if parsed.RawQuery != "" {
+		key += "?" + parsed.RawQuery
+	}
+	return key
+}
+
+// dbFindFeed is dbGetFeed for URLs that may differ from the stored one in
+// their form, see feedURLKey.
+func (a *Atomstr) dbFindFeed(feedURL string) *feedStruct {
+	if feedItem := a.dbGetFeed(feedURL); feedItem.URL != "" {
+		return feedItem
+	}
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return &feedStruct{}
+	}
+	key := feedURLKey(feedURL)
+	for _, feedItem := range *feeds {
+		if feedURLKey(feedItem.URL) == key {
+			return &feedItem
+		}
+	}
+	return &feedStruct{}
+}
+
+func (a *Atomstr) dbUpdateFeedSelfLink(feedURL, selfLink string) error {
+	_, err := a.db.Exec(`UPDATE feeds SET self_link = ? WHERE url = ?`, selfLink, feedURL)
+	if err != nil {
+		return fmt.Errorf("can't update feed self link: %w", err)
+	}
+	return nil
+}
+
+// duplicateFeeds is a pair of feeds that look like the same feed. Feed is
+// the older one.
+type duplicateFeeds struct {
+	Feed      feedStruct
+	Duplicate feedStruct
+	Reasons   []string
+}
+
+// findDuplicateFeeds compares the URLs and self links of all feeds, and the
+// GUIDs of the items they published.
+func (a *Atomstr) findDuplicateFeeds() ([]*duplicateFeeds, error) {
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates []*duplicateFeeds
+	pairs := make(map[[2]string]*duplicateFeeds)
+	add := func(feedItem, duplicate feedStruct, reason string) {
+		pair := [2]string{feedItem.SourcePub, duplicate.SourcePub}
+		if pairs[pair] == nil {
+			pairs[pair] = &duplicateFeeds{Feed: feedItem, Duplicate: duplicate}
+			duplicates = append(duplicates, pairs[pair])
+		}
+		pairs[pair].Reasons = append(pairs[pair].Reasons, reason)
+	}
+
+	bySource := make(map[string]feedStruct)
+	for i, feedItem := range *feeds {
+		bySource[feedItem.SourcePub] = feedItem
+		for _, other := range (*feeds)[:i] {
+			if feedURLKey(feedItem.URL) == feedURLKey(other.URL) {
+				add(other, feedItem, "same URL")
+			}
+			if sameSelfLink(feedItem, other) {
+				add(other, feedItem, "same self link")
+			}
+		}
+	}
+
+	// items published by both feeds, compared to the feed with fewer items
+	rows, err := a.db.Query(`SELECT a.source_pub, b.source_pub, COUNT(*),
+		(SELECT COUNT(*) FROM published_items WHERE source_pub = a.source_pub),
+		(SELECT COUNT(*) FROM published_items WHERE source_pub = b.source_pub)
+		FROM published_items a JOIN published_items b ON b.item_key = a.item_key AND b.source_pub != a.source_pub
+		JOIN feeds fa ON fa.pub = a.source_pub JOIN feeds fb ON fb.pub = b.source_pub
+		WHERE fa.rowid < fb.rowid
+		GROUP BY a.source_pub, b.source_pub`)
+	if err != nil {
+		return nil, fmt.Errorf("can't compare published items: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pubA, pubB string
+		var shared, countA, countB int
+		if err := rows.Scan(&pubA, &pubB, &shared, &countA, &countB); err != nil {
+			return nil, fmt.Errorf("can't compare published items: %w", err)
+		}
+		if shared >= minDuplicateItems && float64(shared) >= duplicateItemOverlap*float64(min(countA, countB)) {
+			add(bySource[pubA], bySource[pubB], fmt.Sprintf("%d of %d items shared", shared, min(countA, countB)))
+		}
+	}
+	return duplicates, rows.Err()
+}
+
+// sameSelfLink reports whether a feed names the other feed, or the same
+// URL, as its self link.
+func sameSelfLink(feedItem, other feedStruct) bool {
+	if feedItem.Topic == "" && other.Topic == "" {
+		return false
+	}
+	self, otherSelf := feedURLKey(feedItem.Topic), feedURLKey(other.Topic)
+	return (feedItem.Topic != "" && (self == otherSelf || self == feedURLKey(other.URL))) ||
+		(other.Topic != "" && otherSelf == feedURLKey(feedItem.URL))
+}
+
+// printDuplicateFeeds is the -duplicates command.
+func (a *Atomstr) printDuplicateFeeds() error {
+	duplicates, err := a.findDuplicateFeeds()
+	if err != nil {
+		return err
+	}
+	if len(duplicates) == 0 {
+		fmt.Println("No duplicate feeds found")
+		return nil
+	}
+	for _, duplicate := range duplicates {
+		fmt.Println(duplicate.Feed.Npub, duplicate.Feed.URL)
+		fmt.Println(duplicate.Duplicate.Npub, duplicate.Duplicate.URL)
+		fmt.Println("  " + strings.Join(duplicate.Reasons, ", "))
+		fmt.Printf("  merge with: -merge %s %s\n\n", duplicate.Feed.URL, duplicate.Duplicate.URL)
+	}
+	return nil
+}
+
+// mergeFeeds is the -merge command. The duplicate feed is removed, its NIP-05
+// names become aliases of the feed that is kept. Its followers aren't moved,
+// Nostr has no way to do that.
+func (a *Atomstr) mergeFeeds(keepURL, duplicateURL string) error {
+	feedItem := a.dbGetFeed(keepURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed %s not found", keepURL)
+	}
+	duplicate := a.dbGetFeed(duplicateURL)
+	if duplicate.URL == "" {
+		return fmt.Errorf("feed %s not found", duplicateURL)
+	}
+	if duplicate.SourcePub == feedItem.SourcePub {
+		return fmt.Errorf("can't merge a feed with itself")
+	}
+
+	if _, err := a.db.Exec(`UPDATE nip05_aliases SET feed_pub = ? WHERE feed_pub = ?`, feedItem.SourcePub, duplicate.SourcePub); err != nil {
+		return fmt.Errorf("can't move NIP-05 aliases: %w", err)
+	}
+	if err := a.deleteSource(duplicate.URL); err != nil {
+		return err
+	}
+	if err := a.dbSaveNip05Alias(feedURLToNip05Name(duplicate.URL), feedItem.SourcePub); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Merged %s into %s, %s isn't published anymore", duplicate.URL, feedItem.URL, duplicate.Npub)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeFeedURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"HTTPS://Example.ORG/Feed.xml", "https://example.org/Feed.xml"},
+		{"https://example.org:443/feed", "https://example.org/feed"},
+		{"http://example.org:80/feed", "http://example.org/feed"},
+		{"http://example.org:8080/feed", "http://example.org:8080/feed"},
+		{"https://example.org", "https://example.org/"},
+		{"  https://example.org/feed#top ", "https://example.org/feed"},
+		{"https://example.org/feed?utm_source=x&b=2&fbclid=y&a=1", "https://example.org/feed?a=1&b=2"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := normalizeFeedURL(tt.url); got != tt.want {
+			t.Errorf("normalizeFeedURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFeedURLKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"scheme", "http://example.org/feed", "https://example.org/feed", true},
+		{"www", "https://www.example.org/feed", "https://example.org/feed", true},
+		{"trailing slash", "https://example.org/feed/", "https://example.org/feed", true},
+		{"feedburner", "http://feeds.feedburner.com/ExampleBlog", "https://feedproxy.google.com/exampleblog", true},
+		{"feedburner mirror", "https://feeds2.feedburner.com/ExampleBlog/", "http://feeds.feedburner.com/exampleblog", true},
+		{"path case", "https://example.org/Feed", "https://example.org/feed", false},
+		{"query", "https://example.org/feed?cat=1", "https://example.org/feed?cat=2", false},
+		{"other host", "https://blog.example.org/feed", "https://example.org/feed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := feedURLKey(tt.a) == feedURLKey(tt.b); same != tt.same {
+				t.Errorf("feedURLKey(%q) = %q, feedURLKey(%q) = %q, want same %v", tt.a, feedURLKey(tt.a), tt.b, feedURLKey(tt.b), tt.same)
+			}
+		})
+	}
+}
+
+func TestMergeFeeds(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	duplicate := addTestFeed(t, a, "https://www.example.org/rss")
+	if err := a.dbSaveNip05Alias("example_old", duplicate.SourcePub); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := a.dbMarkPostPublished(*feedItem, publishedItem{ItemKey: "post-1", EventID: "event-1", ItemTime: &now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.mergeFeeds(feedItem.URL, duplicate.URL); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	if a.dbGetFeed(duplicate.URL).URL != "" {
+		t.Errorf("duplicate feed still exists")
+	}
+	for _, name := range []string{feedURLToNip05Name(duplicate.URL), "example_old"} {
+		alias, err := a.dbGetNip05Alias(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alias == nil || alias.SourcePub != feedItem.SourcePub {
+			t.Errorf("NIP-05 name %s doesn't point to the kept feed: %+v", name, alias)
+		}
+	}
+	if published := a.dbGetPublishedItem(feedItem.Pub, "post-1"); published == nil || published.EventID != "event-1" {
+		t.Errorf("published item of the kept feed is gone: %+v", published)
+	}
+	if a.processFeedPost(*testFeed(t, a, feedItem.URL), testItem("post-1", "https://example.org/post-1"), time.Hour, nil) {
+		t.Errorf("published item was published again after the merge")
+	}
+}
+
+func TestProcessFeedAsyncExistingFeed(t *testing.T) {
+	a := newTestAtomstr(t)
+	addTestFeed(t, a, "https://example.org/feed.xml")
+
+	job := &asyncJob{ID: "test", URL: "HTTPS://Example.org/feed.xml?utm_source=x", Status: "processing"}
+	a.processFeedAsync(job)
+	if job.Status != "failed" || job.Error != "Feed already exists" {
+		t.Errorf("adding an existing feed in another form: %s %q", job.Status, job.Error)
+	}
+}
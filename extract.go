@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// maxExtractedSize limits the HTML taken from an article page, relays
+	// reject overly large events
+	maxExtractedSize = 64 << 10
+	// minArticleText is the text length a content candidate needs to count
+	// as the article
+	minArticleText = 200
+	// failedExtractionRetry is how long a failed extraction is cached
+	failedExtractionRetry = time.Hour
+)
+
+// articleSelectors are tried in order to find the main content of a page.
+var articleSelectors = []string{
+	"[itemprop=articleBody]",
+	".entry-content",
+	".post-content",
+	".article-content",
+	".article-body",
+	"article",
+	"[role=main]",
+	"main",
+}
+
+// expandPost replaces the description and content of a summary-only item
+// with the full article from its link, if the feed has full_text enabled.
+func (a *Atomstr) expandPost(feedItem feedStruct, feedPost *gofeed.Item) {
+	if !feedItem.Settings.FullText || feedPost.Link == "" {
+		return
+	}
+	article := a.fullArticle(feedPost.Link)
+	if article == "" {
+		return
+	}
+	articleText := len(htmlToPlainText(article))
+	if articleText > len(htmlToPlainText(feedPost.Description)) {
+		feedPost.Description = article
+	}
+	if articleText > len(htmlToPlainText(feedPost.Content)) {
+		feedPost.Content = article
+	}
+}
+
+// filterAndExpandPost applies the rules of a feed to an item like
+// filterPost. Rules that don't need the full article come first, so items
+// they drop don't cost an extraction.
+func (a *Atomstr) filterAndExpandPost(feedItem feedStruct, feedPost *gofeed.Item, rules []filterRule) (bool, string) {
+	if ok, reason := filterPost(rulesBeforeExpansion(rules), feedPost); !ok {
+		return false, reason
+	}
+	a.expandPost(feedItem, feedPost)
+	return filterPost(rules, feedPost)
+}
+
+// fullArticle returns the extracted article HTML of a page, from the cache
+// if possible. Failures return "" and are cached for a while as well.
+func (a *Atomstr) fullArticle(link string) string {
+	var content string
+	var fetchedAt time.Time
+	err := a.db.QueryRow(`SELECT content, fetched_at FROM article_cache WHERE url = ?`, link).Scan(&content, &fetchedAt)
+	if err == nil && (content != "" || time.Since(fetchedAt) < failedExtractionRetry) {
+		return content
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[ERROR] can't read article cache: %v", err)
+	}
+
+	content, err = extractArticle(link)
+	if err != nil {
+		log.Printf("[WARN] Can't extract article from %s: %v", link, err)
+	}
+	if _, err := a.db.Exec(`INSERT OR REPLACE INTO article_cache (url, content, fetched_at) VALUES (?, ?, ?)`,
+		link, content, time.Now().UTC()); err != nil {
+		log.Printf("[ERROR] can't write article cache: %v", err)
+	}
+	return content
+}
+
+func (a *Atomstr) dbPruneArticleCache(maxAge time.Duration) error {
+	_, err := a.db.Exec(`DELETE FROM article_cache WHERE fetched_at < ?`, time.Now().UTC().Add(-maxAge))
+	if err != nil {
+		return fmt.Errorf("can't prune article cache: %w", err)
+	}
+	return nil
+}
+
+// extractArticle fetches a page and isolates its main content.
+func extractArticle(link string) (string, error) {
+	body, err := fetchPage(link)
+	if err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("can't parse page: %w", err)
+	}
+
+	doc.Find(`script, style, noscript, iframe, form, nav, header, footer, aside, button,
+		[role=navigation], [role=complementary], .share_submission, .sidebar, .comments, #comments`).Remove()
+
+	article := findArticleNode(doc)
+	if article == nil {
+		return "", fmt.Errorf("no article content found")
+	}
+
+	base := link
+	if href, ok := doc.Find("base[href]").Attr("href"); ok {
+		base = resolveURL(link, href)
+	}
+	article.Find("[href]").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		s.SetAttr("href", resolveURL(base, href))
+	})
+	article.Find("[src]").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		s.SetAttr("src", resolveURL(base, src))
+	})
+
+	return limitedHTML(article, maxExtractedSize), nil
+}
+
+// findArticleNode returns the main content of a page: the first well-known
+// article container with enough text, or else the element with the most
+// paragraph text.
+func findArticleNode(doc *goquery.Document) *goquery.Selection {
+	for _, selector := range articleSelectors {
+		var found *goquery.Selection
+		doc.Find(selector).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+			if len(strings.TrimSpace(s.Text())) >= minArticleText {
+				found = s
+				return false
+			}
+			return true
+		})
+		if found != nil {
+			return found
+		}
+	}
+
+	// readability-style fallback: score parents by their paragraph text
+	type candidate struct {
+		sel   *goquery.Selection
+		score int
+	}
+	candidates := make(map[any]*candidate)
+	var best *candidate
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		parent := p.Parent()
+		if parent.Length() == 0 {
+			return
+		}
+		c, ok := candidates[parent.Get(0)]
+		if !ok {
+			c = &candidate{sel: parent}
+			candidates[parent.Get(0)] = c
+		}
+		c.score += len(strings.TrimSpace(p.Text()))
+		if best == nil || c.score > best.score {
+			best = c
+		}
+	})
+	if best == nil || best.score < minArticleText {
+		return nil
+	}
+	return best.sel
+}
+
+// limitedHTML returns the inner HTML of s, dropping trailing child nodes
+// once maxSize is reached.
+func limitedHTML(s *goquery.Selection, maxSize int) string {
+	var sb strings.Builder
+	s.Contents().EachWithBreak(func(_ int, child *goquery.Selection) bool {
+		html, err := goquery.OuterHtml(child)
+		if err != nil {
+			return true
+		}
+		if sb.Len()+len(html) > maxSize {
+			return false
+		}
+		sb.WriteString(html)
+		return true
+	})
+	return strings.TrimSpace(sb.String())
+}
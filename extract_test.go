@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// testArticlePage returns a page with navigation around an article.
+func testArticlePage(text string) string {
+	return fmt.Sprintf(`<html><body><nav><a href="/">Home</a></nav>
+		<article><p>%s</p><img src="/image.jpg"></article>
+		<footer>Footer</footer></body></html>`, text)
+}
+
+func TestFilterAndExpandPost(t *testing.T) {
+	allowTestServer(t)
+	var fetches int64
+	article := strings.Repeat("The full article text. ", 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		fmt.Fprint(w, testArticlePage(article))
+	}))
+	defer server.Close()
+
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	feedItem.Settings.FullText = true
+	for _, text := range []string{"exclude title (?i)sponsored", "include content full article"} {
+		rule, err := parseFilterRule(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.dbAddFeedFilter(feedItem.SourcePub, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sponsored := testItem("sponsored", server.URL+"/sponsored")
+	sponsored.Title = "Sponsored: buy this"
+	if a.processFeedPost(*feedItem, sponsored, time.Hour, nil) {
+		t.Errorf("excluded item was published")
+	}
+	if fetches != 0 {
+		t.Errorf("the article of an excluded item was fetched")
+	}
+
+	// the include rule only matches the full article
+	if !a.processFeedPost(*feedItem, testItem("story", server.URL+"/story"), time.Hour, nil) {
+		t.Errorf("item matching the include rule with its full article was not published")
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d articles, want 1", fetches)
+	}
+}
+
+func TestExtractArticle(t *testing.T) {
+	allowTestServer(t)
+	text := strings.Repeat("Paragraph text of the article. ", 10)
+	pages := map[string]string{
+		"/article": testArticlePage(text),
+		"/entry": fmt.Sprintf(`<html><body><div class="sidebar"><p>%s</p></div>
+			<div class="entry-content"><p>%s</p><a href="other">more</a></div></body></html>`, text+text, text),
+		"/paragraphs": fmt.Sprintf(`<html><body><div id="menu"><p>Menu</p></div>
+			<div id="text"><p>%s</p><p>%s</p></div></body></html>`, text, text),
+		"/short": `<html><body><article><p>Too short.</p></article></body></html>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	article, err := extractArticle(server.URL + "/article")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(article, "Paragraph text") || strings.Contains(article, "Home") || strings.Contains(article, "Footer") {
+		t.Errorf("article has the wrong content: %s", article)
+	}
+	if !strings.Contains(article, `src="`+server.URL+`/image.jpg"`) {
+		t.Errorf("relative image URL wasn't resolved: %s", article)
+	}
+
+	// well-known containers win over longer text elsewhere
+	entry, err := extractArticle(server.URL + "/entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(entry, "Paragraph text") != 10 || !strings.Contains(entry, `href="`+server.URL+`/other"`) {
+		t.Errorf("entry content wasn't extracted: %s", entry)
+	}
+
+	paragraphs, err := extractArticle(server.URL + "/paragraphs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(paragraphs, "<p>") != 2 || strings.Contains(paragraphs, "Menu") {
+		t.Errorf("paragraphs weren't extracted: %s", paragraphs)
+	}
+
+	for _, path := range []string{"/short", "/missing"} {
+		if _, err := extractArticle(server.URL + path); err == nil {
+			t.Errorf("extraction of %s didn't fail", path)
+		}
+	}
+}
+
+func TestExpandPost(t *testing.T) {
+	allowTestServer(t)
+	var fetches int64
+	article := strings.Repeat("The full article text. ", 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		if r.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, testArticlePage(article))
+	}))
+	defer server.Close()
+
+	a := newTestAtomstr(t)
+	feedItem := feedStruct{Settings: feedSettings{FullText: true}}
+
+	summary := &gofeed.Item{Link: server.URL + "/story", Description: "Short summary"}
+	a.expandPost(feedItem, summary)
+	if !strings.Contains(summary.Description, "The full article text.") || !strings.Contains(summary.Content, "The full article text.") {
+		t.Errorf("summary wasn't replaced by the article: %+v", summary)
+	}
+
+	// the cached article is used for the next item with the link
+	long := strings.Repeat("A longer text in the feed itself. ", 30)
+	complete := &gofeed.Item{Link: server.URL + "/story", Description: long}
+	a.expandPost(feedItem, complete)
+	if complete.Description != long {
+		t.Errorf("longer description was replaced by the article")
+	}
+	if fetches != 1 {
+		t.Errorf("article was fetched %d times, want once", fetches)
+	}
+
+	// failures are cached as well
+	for i := 0; i < 2; i++ {
+		broken := &gofeed.Item{Link: server.URL + "/broken", Description: "Summary"}
+		a.expandPost(feedItem, broken)
+		if broken.Description != "Summary" {
+			t.Errorf("failed extraction changed the item: %+v", broken)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("fetched %d pages, want 2", fetches)
+	}
+
+	disabled := &gofeed.Item{Link: server.URL + "/other", Description: "Summary"}
+	a.expandPost(feedStruct{}, disabled)
+	if disabled.Description != "Summary" || fetches != 2 {
+		t.Errorf("item of a feed without full_text was expanded")
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html"
 	"io"
@@ -21,8 +26,7 @@ import (
 )
 
 var (
-	reNitterTelegram = regexp.MustCompile(`nitter|telegram`)
-	reMultiNewline   = regexp.MustCompile(`\n{3,}`)
+	reMultiNewline = regexp.MustCompile(`\n{3,}`)
 
 	// iconPatterns identifies small UI images (social buttons, icons) to strip
 	iconPatterns = []string{"icon", "button", "share", "/sd/", "logo_", "badge"}
@@ -110,52 +114,160 @@ func isIconURL(src string) bool {
 	return false
 }
 
-var publishedPosts = struct {
-	sync.RWMutex
-	items map[string]time.Time
-}{items: make(map[string]time.Time)}
+// postContentHash returns a hex SHA-256 of content, stored alongside
+// published items so later fetches can tell whether an item has changed.
+func postContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// itemContentHash returns the content hash of a feed item as fetched. It
+// must be taken before full-article extraction and media mirroring, a failed
+// extraction or upload or another template is no change of the item.
+func itemContentHash(feedPost *gofeed.Item) string {
+	fields := []string{feedPost.Title, feedPost.Description, feedPost.Content, feedPost.Updated}
+	// categories become tags and filter rules can match them
+	fields = append(fields, feedPost.Categories...)
+	for _, enclosure := range feedPost.Enclosures {
+		fields = append(fields, enclosure.URL, enclosure.Type, enclosure.Length)
+	}
+	return postContentHash(strings.Join(fields, "\x00"))
+}
+
+// publishedItem is the stored state of an item that was published to Nostr.
+type publishedItem struct {
+	ItemKey     string
+	EventID     string
+	TeaserID    string
+	Kind        int
+	ContentHash string
+	ItemTime    *time.Time
+	Link        string // to find items published by other sources of the identity
+	SourcePub   string // the feed that published the item
+}
+
+// dbGetPublishedItem returns the stored state of an already published item
+// of the given feed, looked up by its dedup key (GUID, falling back to link),
+// or nil if it was not published yet.
+func (a *Atomstr) dbGetPublishedItem(feedPub, itemKey string) *publishedItem {
+	item := publishedItem{ItemKey: itemKey}
+	err := a.db.QueryRow(`SELECT event_id, teaser_event_id, event_kind, content_hash, item_time, source_pub FROM published_items WHERE feed_pub = ? AND item_key = ?`,
+		feedPub, itemKey).Scan(&item.EventID, &item.TeaserID, &item.Kind, &item.ContentHash, &item.ItemTime, &item.SourcePub)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to check published state of %s: %v", itemKey, err)
+		return nil
+	}
+	return &item
+}
 
-func isPostPublished(feedURL, postID string) bool {
-	key := feedURL + "|" + postID
-	publishedPosts.RLock()
-	_, exists := publishedPosts.items[key]
-	publishedPosts.RUnlock()
+// dbIsLinkPublished reports whether an item with the link was published by
+// another source of the identity of the feed. Items of the feed itself are
+// told apart by their GUID, many feeds give all items the same link.
+func (a *Atomstr) dbIsLinkPublished(feedItem feedStruct, link string) bool {
+	var exists bool
+	err := a.db.QueryRow(`SELECT COUNT(*) > 0 FROM published_items WHERE feed_pub = ? AND link = ? AND source_pub != ?`,
+		feedItem.Pub, link, feedItem.SourcePub).Scan(&exists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check published state of %s: %v", link, err)
+		return false
+	}
 	return exists
 }
 
-func markPostPublished(feedURL, postID string) {
-	key := feedURL + "|" + postID
-	publishedPosts.Lock()
-	publishedPosts.items[key] = time.Now()
-	publishedPosts.Unlock()
+// dbMarkPostPublished stores a published item under the identity of the
+// feed, remembering the feed as its source.
+func (a *Atomstr) dbMarkPostPublished(feedItem feedStruct, item publishedItem) error {
+	if item.ItemTime != nil {
+		utc := item.ItemTime.UTC()
+		item.ItemTime = &utc
+	}
+	_, err := a.db.Exec(`INSERT OR REPLACE INTO published_items (feed_pub, item_key, event_id, teaser_event_id, event_kind, content_hash, item_time, published_at, source_pub, link) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		feedItem.Pub, item.ItemKey, item.EventID, item.TeaserID, item.Kind, item.ContentHash, item.ItemTime, time.Now(), feedItem.SourcePub, item.Link)
+	if err != nil {
+		return fmt.Errorf("can't mark post published: %w", err)
+	}
+	return nil
 }
 
-func prunePublishedPosts(maxAge time.Duration) {
-	publishedPosts.Lock()
-	defer publishedPosts.Unlock()
-	cutoff := time.Now().Add(-maxAge)
-	for k, t := range publishedPosts.items {
-		if t.Before(cutoff) {
-			delete(publishedPosts.items, k)
-		}
+func (a *Atomstr) dbDeletePublishedItem(feedPub, itemKey string) error {
+	_, err := a.db.Exec(`DELETE FROM published_items WHERE feed_pub = ? AND item_key = ?`, feedPub, itemKey)
+	if err != nil {
+		return fmt.Errorf("can't remove published item: %w", err)
+	}
+	return nil
+}
+
+// dbPrunePublishedPosts removes dedup entries older than maxAge. Items that
+// are still in a feed after that are also outside checkMaxAge, so they are
+// never republished. Upcoming calendar events are kept to track changes.
+func (a *Atomstr) dbPrunePublishedPosts(maxAge time.Duration) error {
+	res, err := a.db.Exec(`DELETE FROM published_items WHERE published_at < ? AND (item_time IS NULL OR item_time < ?)`,
+		time.Now().Add(-maxAge), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("can't prune published posts: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[DEBUG] Pruned %d published posts older than %v", n, maxAge)
+	}
+	return nil
+}
+
+// feedSelectSQL selects all columns scanned by scanFeed.
+const feedSelectSQL = `SELECT feeds.pub, COALESCE(i.pub, feeds.pub), COALESCE(i.sec, feeds.sec), url, title, site_link, feeds.state, failure_count, backoff_count, last_success, last_failure, etag, last_modified, last_item_at, next_fetch_at, poll_interval,
+		feed_ttl, skip_hours, skip_days, w.lease_expires,
+		COALESCE(s.publish_mode, ''), COALESCE(s.track_changes, 0), COALESCE(s.full_text, 0), COALESCE(s.fetch_interval, ''), COALESCE(s.relays, ''),
+		COALESCE(s.template, ''), COALESCE(s.max_items, 0), COALESCE(s.history_interval, ''),
+		COALESCE(c.item_selector, ''), COALESCE(c.title_selector, ''), COALESCE(c.link_selector, ''), COALESCE(c.date_selector, ''), COALESCE(c.date_format, ''), COALESCE(c.body_selector, ''),
+		COALESCE(i.name, ''), COALESCE(i.about, ''), COALESCE(i.picture, ''), COALESCE(i.nip05_name, ''), COALESCE(feeds.self_link, ''),
+		feeds.rowid = (SELECT MIN(f.rowid) FROM feeds f WHERE f.identity_pub = feeds.identity_pub),
+		(SELECT COUNT(*) > 1 FROM feeds f WHERE f.identity_pub = feeds.identity_pub)
+		FROM feeds LEFT JOIN identities i ON i.pub = feeds.identity_pub
+		LEFT JOIN feed_settings s ON s.feed_pub = feeds.pub
+		LEFT JOIN websub_subscriptions w ON w.feed_pub = feeds.pub
+		LEFT JOIN scrape_sources c ON c.feed_pub = feeds.pub`
+
+func scanFeed(row interface{ Scan(...any) error }) (feedStruct, error) {
+	feedItem := feedStruct{}
+	var fetch, relays, history string
+	var pollSeconds, ttlSeconds int64
+	var skipHours, skipDays string
+	var scrape scrapeConfig
+	if err := row.Scan(&feedItem.SourcePub, &feedItem.Pub, &feedItem.Sec, &feedItem.URL, &feedItem.Title, &feedItem.Link, &feedItem.State, &feedItem.FailureCount, &feedItem.BackoffCount, &feedItem.LastSuccess, &feedItem.LastFailure, &feedItem.ETag, &feedItem.LastModified, &feedItem.LastItemAt, &feedItem.NextFetchAt, &pollSeconds,
+		&ttlSeconds, &skipHours, &skipDays, &feedItem.PushLeaseExpires,
+		&feedItem.Settings.PublishMode, &feedItem.Settings.TrackChanges, &feedItem.Settings.FullText, &fetch, &relays, &feedItem.Settings.Template, &feedItem.Settings.MaxItems, &history,
+		&scrape.Item, &scrape.Title, &scrape.Link, &scrape.Date, &scrape.DateFormat, &scrape.Body,
+		&feedItem.Profile.Name, &feedItem.Profile.About, &feedItem.Profile.Picture, &feedItem.Profile.Nip05, &feedItem.Topic, &feedItem.PrimarySource, &feedItem.SharedIdentity); err != nil {
+		return feedItem, err
+	}
+	feedItem.PollInterval = time.Duration(pollSeconds) * time.Second
+	feedItem.Hints = parseStoredHints(ttlSeconds, skipHours, skipDays)
+	feedItem.Settings.FetchInterval, _ = time.ParseDuration(fetch)
+	feedItem.Settings.HistoryInterval, _ = time.ParseDuration(history)
+	feedItem.Settings.Relays = splitAndTrim(relays)
+	if scrape.Item != "" {
+		feedItem.Scrape = &scrape
+	}
+	feedItem.Npub, _ = nip19.EncodePublicKey(feedItem.Pub)
+	return feedItem, nil
 }
 
 func (a *Atomstr) dbGetAllFeeds() (*[]feedStruct, error) {
-	sqlStatement := `SELECT pub, sec, url, state, failure_count, last_success, last_failure, etag, last_modified FROM feeds`
-	rows, err := a.db.Query(sqlStatement)
+	rows, err := a.db.Query(feedSelectSQL)
 	if err != nil {
 		return nil, fmt.Errorf("returning feeds from DB failed: %w", err)
 	}
+	defer rows.Close()
 
 	feedItems := []feedStruct{}
 
 	for rows.Next() {
-		feedItem := feedStruct{}
-		if err := rows.Scan(&feedItem.Pub, &feedItem.Sec, &feedItem.URL, &feedItem.State, &feedItem.FailureCount, &feedItem.LastSuccess, &feedItem.LastFailure, &feedItem.ETag, &feedItem.LastModified); err != nil {
+		feedItem, err := scanFeed(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scanning for feeds failed: %w", err)
 		}
-		feedItem.Npub, _ = nip19.EncodePublicKey(feedItem.Pub)
 		feedItems = append(feedItems, feedItem)
 	}
 
@@ -181,7 +293,7 @@ func fetchFavicon(feedURL string) string {
 		baseURL + "/favicon.ico",
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := newFetchClient(5 * time.Second)
 
 	for _, faviconURL := range faviconURLs {
 		resp, err := client.Head(faviconURL)
@@ -212,7 +324,8 @@ func fetchFavicon(feedURL string) string {
 
 	// Simple favicon link extraction (basic implementation)
 	// Extract favicon URLs from HTML using regex
-	body, _ := io.ReadAll(resp.Body)
+	// the icons are in the head of the page
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
 	htmlContent := string(body)
 
 	// Look for various favicon link tags
@@ -248,15 +361,31 @@ func fetchFavicon(feedURL string) string {
 	return defaultFeedImage
 }
 
-// fetchFeedWithCaching fetches a feed URL using HTTP conditional GET.
-// Returns the parsed feed, new ETag, new Last-Modified, whether the feed was not modified, and any error.
-func fetchFeedWithCaching(feedURL string, etag string, lastModified string) (*gofeed.Feed, string, string, bool, error) {
+// fetchResult is the outcome of a conditional feed fetch.
+type fetchResult struct {
+	Feed         *gofeed.Feed
+	Calendar     *calendar // set instead of Feed for iCalendar documents
+	ETag         string
+	LastModified string
+	NotModified  bool
+	// MaxAge is the freshness lifetime announced by Cache-Control or Expires
+	MaxAge time.Duration
+	// Hints are the polling hints of an RSS document, nil if not modified
+	Hints *feedHints
+	// MovedTo is the new URL of a feed that redirects permanently
+	MovedTo string
+}
+
+// fetchFeedWithCaching fetches a feed URL using HTTP conditional GET. Pages
+// of scraped sources are turned into a feed with the scrape selectors.
+// Permanent redirects are reported in MovedTo.
+func fetchFeedWithCaching(feedURL string, etag string, lastModified string, scrape *scrapeConfig) (*fetchResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
 	if err != nil {
-		return nil, "", "", false, err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
 	if etag != "" {
@@ -266,195 +395,429 @@ func fetchFeedWithCaching(feedURL string, etag string, lastModified string) (*go
 		req.Header.Set("If-Modified-Since", lastModified)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// only a chain of 301 and 308 redirects moves the feed, behind a
+	// temporary redirect the old URL stays valid
+	var movedTo string
+	permanent := true
+	client := newFetchClient(0)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := checkFetchRedirect(req, via); err != nil {
+			return err
+		}
+		status := req.Response.StatusCode
+		permanent = permanent && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect)
+		if permanent {
+			movedTo = req.URL.String()
+		}
+		return nil
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", "", false, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, &backoffError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	result := &fetchResult{MaxAge: responseMaxAge(resp.Header), MovedTo: movedTo}
+
 	if resp.StatusCode == http.StatusNotModified {
 		log.Printf("[DEBUG] Feed %s not modified (304)", feedURL)
-		return nil, etag, lastModified, true, nil
+		result.ETag = etag
+		result.LastModified = lastModified
+		result.NotModified = true
+		return result, nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", "", false, fmt.Errorf("HTTP %d", resp.StatusCode)
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result.ETag = resp.Header.Get("ETag")
+	result.LastModified = resp.Header.Get("Last-Modified")
+	if scrape != nil {
+		result.Feed, err = scrapeFeed(feedURL, body, *scrape)
+		if err != nil {
+			return nil, err
+		}
+		result.Hints = &feedHints{}
+		return result, nil
+	}
+	if isCalendar(body) {
+		result.Calendar, err = parseCalendar(body)
+		if err != nil {
+			return nil, err
+		}
+		result.Hints = &feedHints{}
+		return result, nil
+	}
 	fp := gofeed.NewParser()
 	fp.UserAgent = "atomstr/" + atomstrVersion
-	feed, err := fp.Parse(resp.Body)
+	result.Feed, err = fp.Parse(bytes.NewReader(body))
 	if err != nil {
-		return nil, "", "", false, err
+		return nil, err
 	}
-
-	newETag := resp.Header.Get("ETag")
-	newLastMod := resp.Header.Get("Last-Modified")
-	return feed, newETag, newLastMod, false, nil
+	hints := feedHints{}
+	if result.Feed.FeedType == "rss" {
+		hints = parseFeedHints(body)
+	}
+	result.Hints = &hints
+	return result, nil
 }
 
+// processFeedURL is a scrape worker. After each feed it stores and schedules
+// the time of the next fetch.
 func (a *Atomstr) processFeedURL(ch chan feedStruct, wg *sync.WaitGroup, stats *scrapeStats) {
 	for feedItem := range ch {
-
-		// Check if we should fetch this feed
-		if !a.shouldFetchFeed(feedItem) {
-			log.Printf("[DEBUG] Skipping broken feed %s (last failure: %v)", feedItem.URL, feedItem.LastFailure)
-			atomic.AddInt64(&stats.feedsSkipped, 1)
+		unlock := a.feedLocks.lock(feedItem.SourcePub)
+		next, ok := a.processFeed(&feedItem, stats)
+		unlock()
+		if !ok {
 			continue
 		}
+		if err := a.dbUpdateFeedSchedule(feedItem.URL, next.At, next.Interval); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if a.scheduler != nil {
+			a.scheduler.schedule(feedItem.URL, next.At)
+		}
+	}
+	wg.Done()
+}
 
-		feed, newETag, newLastMod, notModified, err := fetchFeedWithCaching(feedItem.URL, feedItem.ETag, feedItem.LastModified)
+// processFeed fetches a feed and publishes new items. It returns when the
+// feed should be fetched next, or false if the feed was deleted. The URL of
+// feedItem changes if the feed moved.
+func (a *Atomstr) processFeed(feedItem *feedStruct, stats *scrapeStats) (nextFetch, bool) {
+	// Check if we should fetch this feed
+	if !a.shouldFetchFeed(*feedItem) {
+		log.Printf("[DEBUG] Skipping broken feed %s (last failure: %v)", feedItem.URL, feedItem.LastFailure)
+		atomic.AddInt64(&stats.feedsSkipped, 1)
+		return nextFetchAfter(feedItem.LastFailure.Add(brokenFeedRetryInterval), feedItem.PollInterval), true
+	}
 
-		if notModified {
-			a.dbResetFeedState(feedItem.URL)
-			atomic.AddInt64(&stats.feedsCached, 1)
-			atomic.AddInt64(&stats.feedsProcessed, 1)
-			continue
+	result, err := fetchFeedWithCaching(feedItem.URL, feedItem.ETag, feedItem.LastModified, feedItem.Scrape)
+	if err == nil && result.MovedTo != "" {
+		a.followPermanentRedirect(feedItem, result.MovedTo)
+	}
+
+	if err == nil && result.NotModified {
+		a.dbResetFeedState(feedItem.URL)
+		atomic.AddInt64(&stats.feedsCached, 1)
+		atomic.AddInt64(&stats.feedsProcessed, 1)
+		return scheduleNextFetch(*feedItem, nil, result.MaxAge), true
+	}
+
+	var backoff *backoffError
+	if errors.As(err, &backoff) {
+		backoffCount := feedItem.BackoffCount + 1
+		wait := backoffInterval(backoffCount, backoff.RetryAfter)
+		log.Printf("[WARN] Feed %s asked us to back off (HTTP %d), retrying in %v", feedItem.URL, backoff.StatusCode, wait)
+		atomic.AddInt64(&stats.feedsSkipped, 1)
+		a.dbUpdateFeedState(feedItem.URL, feedItem.State, feedItem.FailureCount, backoffCount, feedItem.LastSuccess, feedItem.LastFailure)
+		return nextFetchAfter(time.Now().Add(wait), feedItem.PollInterval), true
+	}
+
+	if err != nil {
+		log.Println("[ERROR] Can't update feed", feedItem.URL)
+		atomic.AddInt64(&stats.feedsErrored, 1)
+
+		// Update failure state
+		newFailureCount := feedItem.FailureCount + 1
+
+		// Auto-delete feeds that exceed the maximum failure threshold
+		if newFailureCount >= maxFailureDelete {
+			log.Printf("[WARN] Feed %s auto-deleted after %d consecutive failures", feedItem.URL, newFailureCount)
+			a.deleteSource(feedItem.URL)
+			return nextFetch{}, false
 		}
 
-		if err != nil {
-			log.Println("[ERROR] Can't update feed", feedItem.URL)
-			atomic.AddInt64(&stats.feedsErrored, 1)
+		newState := "active"
+		retry := feedItem.effectiveFetchInterval()
+		if newFailureCount >= maxFailureAttempts {
+			newState = "broken"
+			retry = brokenFeedRetryInterval
+			log.Printf("[WARN] Feed %s marked as broken after %d failures", feedItem.URL, newFailureCount)
+		}
+		now := time.Now()
+		a.dbUpdateFeedState(feedItem.URL, newState, newFailureCount, feedItem.BackoffCount, feedItem.LastSuccess, &now)
+		return nextFetchAfter(now.Add(retry), feedItem.PollInterval), true
+	}
 
-			// Update failure state
-			newFailureCount := feedItem.FailureCount + 1
+	feed := result.Feed
+	log.Println("[DEBUG] Updating feed ", feedItem.URL)
+	atomic.AddInt64(&stats.feedsProcessed, 1)
 
-			// Auto-delete feeds that exceed the maximum failure threshold
-			if newFailureCount >= maxFailureDelete {
-				log.Printf("[WARN] Feed %s auto-deleted after %d consecutive failures", feedItem.URL, newFailureCount)
-				a.deleteSource(feedItem.URL)
-				continue
-			}
+	// Reset state on successful fetch
+	a.dbResetFeedState(feedItem.URL)
+	a.dbUpdateFeedCache(feedItem.URL, result.ETag, result.LastModified)
+	feedItem.Hints = *result.Hints
+	if err := a.dbUpdateFeedHints(feedItem.URL, feedItem.Hints); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
 
-			newState := "active"
-			if newFailureCount >= maxFailureAttempts {
-				newState = "broken"
-				log.Printf("[WARN] Feed %s marked as broken after %d failures", feedItem.URL, newFailureCount)
-			}
-			now := time.Now()
-			a.dbUpdateFeedState(feedItem.URL, newState, newFailureCount, feedItem.LastSuccess, &now)
-		} else {
-			log.Println("[DEBUG] Updating feed ", feedItem.URL)
-			atomic.AddInt64(&stats.feedsProcessed, 1)
-
-			// Reset state on successful fetch
-			a.dbResetFeedState(feedItem.URL)
-			a.dbUpdateFeedCache(feedItem.URL, newETag, newLastMod)
-
-			// fmt.Println(feed)
-			feedItem.Title = feed.Title
-			feedItem.Description = feed.Description
-			feedItem.Link = feed.Link
-			if feed.Image != nil {
-				feedItem.Image = feed.Image.URL
-			} else {
-				feedItem.Image = fetchFavicon(feedItem.URL)
-				if feedItem.Image == defaultFeedImage {
-					log.Println("[DEBUG] No favicon found for", feedItem.URL, "using default image")
-				} else {
-					log.Println("[DEBUG] Using favicon for", feedItem.URL, ":", feedItem.Image)
-				}
-			}
-			// feedItem.Image = feed.Image
+	if result.Calendar != nil {
+		a.processCalendar(*feedItem, result.Calendar, stats)
+		return scheduleNextFetch(*feedItem, nil, result.MaxAge), true
+	}
 
-			for i := range feed.Items {
-				processFeedPost(feedItem, feed.Items[i], fetchInterval, stats)
-			}
-			log.Println("[DEBUG] Finished updating feed ", feedItem.URL)
+	if feedItem.Scrape != nil {
+		feed.Items = a.dateScrapedItems(feedItem.SourcePub, feed.Items, false)
+	}
+
+	a.publishFeedItems(feedItem, feed, stats)
+	if feedItem.Settings.TrackChanges {
+		a.deleteRemovedPosts(*feedItem, feed.Items)
+	}
+	log.Println("[DEBUG] Finished updating feed ", feedItem.URL)
+
+	return scheduleNextFetch(*feedItem, feed.Items, result.MaxAge), true
+}
+
+// publishFeedItems updates the feed info and publishes the new items of a
+// fetched or pushed feed, oldest first.
+func (a *Atomstr) publishFeedItems(feedItem *feedStruct, feed *gofeed.Feed, stats *scrapeStats) {
+	if feed.Title != "" && (feed.Title != feedItem.Title || feed.Link != feedItem.Link) {
+		if err := a.dbUpdateFeedInfo(feedItem.URL, feed.Title, feed.Link); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+	feedItem.Title = feed.Title
+	feedItem.Description = feed.Description
+	feedItem.Link = feed.Link
+	feedItem.Podcast = parsePodcastInfo(feed)
+	if feed.Image != nil {
+		feedItem.Image = feed.Image.URL
+	} else {
+		feedItem.Image = fetchFavicon(feedItem.URL)
+		if feedItem.Image == defaultFeedImage {
+			log.Println("[DEBUG] No favicon found for", feedItem.URL, "using default image")
+		} else {
+			log.Println("[DEBUG] Using favicon for", feedItem.URL, ":", feedItem.Image)
 		}
+	}
 
+	// Publish everything since the cursor so items from downtime are not lost
+	// Adaptive polling can leave hours between fetches
+	pollInterval := max(feedItem.effectiveFetchInterval(), feedItem.PollInterval)
+	interval := catchUpInterval(feedItem.LastItemAt, pollInterval)
+	if interval > pollInterval {
+		log.Printf("[DEBUG] Catching up %s for the last %v", feedItem.URL, interval.Round(time.Second))
+	}
+	// Oldest first, so a max_items limit leaves the newer items for
+	// the next fetch instead of skipping them behind the cursor
+	items := itemsOldestFirst(feed.Items)
+	published := 0
+	for i := range items {
+		if feedItem.Settings.MaxItems > 0 && published >= feedItem.Settings.MaxItems {
+			log.Printf("[DEBUG] Reached max items (%d) for %s", feedItem.Settings.MaxItems, feedItem.URL)
+			items = items[:i]
+			break
+		}
+		if a.processFeedPost(*feedItem, items[i], interval, stats) {
+			published++
+		}
+	}
+	if newest := newestItemTime(items); newest != nil && (feedItem.LastItemAt == nil || newest.After(*feedItem.LastItemAt)) {
+		if err := a.dbUpdateFeedCursor(feedItem.URL, newest); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
 	}
-	wg.Done()
 }
 
-func processFeedPost(feedItem feedStruct, feedPost *gofeed.Item, interval time.Duration, stats *scrapeStats) {
+// processFeedPost publishes a feed item if it is new and not older than
+// interval. It returns whether a new post was published.
+func (a *Atomstr) processFeedPost(feedItem feedStruct, feedPost *gofeed.Item, interval time.Duration, stats *scrapeStats) bool {
 	// Parse date with fallbacks
 	itemTime, err := parseFeedDate(feedPost)
 	if err != nil {
 		log.Printf("[WARN] Can't parse any date from post from %s: %v", feedItem.URL, err)
-		return
+		return false
+	}
+
+	// Dedup: use GUID if available, fall back to Link
+	postID := feedPost.GUID
+	if postID == "" {
+		postID = feedPost.Link
+	}
+	if postID != "" {
+		if published := a.dbGetPublishedItem(feedItem.Pub, postID); published != nil {
+			// the copy of another source of the identity isn't compared,
+			// it would be replaced whenever the two sources differ
+			if feedItem.Settings.TrackChanges && published.SourcePub == feedItem.SourcePub {
+				a.updateChangedPost(feedItem, feedPost, postID, itemTime, published)
+			} else {
+				log.Printf("[DEBUG] Skipping duplicate post %s from %s", postID, feedItem.URL)
+			}
+			return false
+		}
+	}
+	// the same article in another feed of the identity has another GUID
+	if feedItem.SharedIdentity && feedPost.Link != "" && a.dbIsLinkPublished(feedItem, feedPost.Link) {
+		log.Printf("[DEBUG] Skipping post %s from %s, its link was already published", postID, feedItem.URL)
+		return false
 	}
 
 	// if time right, then push
 	if checkMaxAge(itemTime, interval) {
-		// Dedup: use GUID if available, fall back to Link
-		postID := feedPost.GUID
-		if postID == "" {
-			postID = feedPost.Link
+		contentHash := itemContentHash(feedPost)
+		rules, err := a.dbGetFeedFilters(feedItem.SourcePub)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
 		}
-		if postID != "" && isPostPublished(feedItem.URL, postID) {
-			log.Printf("[DEBUG] Skipping duplicate post %s from %s", postID, feedItem.URL)
-			return
+		if ok, reason := a.filterAndExpandPost(feedItem, feedPost, rules); !ok {
+			log.Printf("[DEBUG] Filtered post %s from %s: %s", postID, feedItem.URL, reason)
+			if stats != nil {
+				atomic.AddInt64(&stats.postsFiltered, 1)
+			}
+			return false
 		}
+		a.mirrorPostMedia(feedItem, feedPost)
 
-		var feedText string
-		cleanDesc := htmlToPlainText(feedPost.Description)
-		if reNitterTelegram.MatchString(feedPost.Link) { // fix duplicated title in nitter/telegram
-			feedText = cleanDesc
-		} else {
-			feedText = feedPost.Title + "\n\n" + cleanDesc
+		events := buildPostEvents(feedItem, feedPost, postID, itemTime.Unix())
+
+		for _, ev := range events {
+			a.nostrPostItem(ev, feedItem.publishRelays())
+		}
+		if stats != nil {
+			atomic.AddInt64(&stats.postsPublished, 1)
 		}
 
-		if feedPost.Enclosures != nil { // allow enclosure images/links
-			for _, enclosure := range feedPost.Enclosures {
-				feedText = feedText + "\n\n" + enclosure.URL
+		// dry runs must not poison the dedup state of the real instance
+		if postID != "" && !dryRunMode {
+			item := newPublishedItem(postID, itemTime, events)
+			item.Link = feedPost.Link
+			item.ContentHash = contentHash
+			if err := a.dbMarkPostPublished(feedItem, item); err != nil {
+				log.Printf("[ERROR] %v", err)
 			}
 		}
+		return true
+	}
+	return false
+}
 
-		if feedPost.Link != "" {
-			feedText = feedText + "\n\n" + feedPost.Link
+// buildPostEvents creates the signed events for a feed item according to the
+// publish mode of the feed. The first event is the main one; in
+// article+teaser and video+note mode the second is the announcing note.
+func buildPostEvents(feedItem feedStruct, feedPost *gofeed.Item, postID string, itemTime int64) []nostr.Event {
+	var events []nostr.Event
+	video := parseVideoInfo(feedPost)
+	mode := feedItem.postPublishMode(video)
+	switch mode {
+	case "article", "article+teaser":
+		article := buildArticleEvent(feedItem, feedPost, postID, itemTime)
+		article.Sign(feedItem.Sec)
+		events = append(events, article)
+		if mode == "article+teaser" {
+			teaser := buildTeaserEvent(feedItem, feedPost, article)
+			teaser.Sign(feedItem.Sec)
+			events = append(events, teaser)
 		}
-
-		var tags nostr.Tags
-
-		if feedPost.Categories != nil { // use post categories as tags
-			for _, category := range feedPost.Categories {
-				tags = append(tags, nostr.Tag{"t", category})
-			}
+	case "video", "video+note":
+		videoEvent := buildVideoEvent(feedItem, feedPost, video, itemTime)
+		videoEvent.Sign(feedItem.Sec)
+		events = append(events, videoEvent)
+		if mode == "video+note" {
+			note := buildVideoNoteEvent(feedItem, feedPost, videoEvent, itemTime)
+			note.Sign(feedItem.Sec)
+			events = append(events, note)
 		}
+	default:
+		ev := buildNoteEvent(feedItem, feedPost, itemTime)
+		ev.Sign(feedItem.Sec)
+		events = append(events, ev)
+	}
+	return events
+}
 
-		tags = append(tags, nostr.Tag{"proxy", feedItem.URL + `#` + url.QueryEscape(feedPost.Link), "rss"})
+func newPublishedItem(postID string, itemTime *time.Time, events []nostr.Event) publishedItem {
+	item := publishedItem{
+		ItemKey:  postID,
+		EventID:  events[0].ID,
+		Kind:     events[0].Kind,
+		ItemTime: itemTime,
+	}
+	if len(events) > 1 {
+		item.TeaserID = events[1].ID
+	}
+	return item
+}
 
-		ev := nostr.Event{
-			PubKey:    feedItem.Pub,
-			CreatedAt: nostr.Timestamp(itemTime.Unix()),
-			Kind:      nostr.KindTextNote,
-			Tags:      tags,
-			Content:   feedText,
-		}
+// buildNoteEvent creates an unsigned kind 1 note for a feed item.
+func buildNoteEvent(feedItem feedStruct, feedPost *gofeed.Item, itemTime int64) nostr.Event {
+	feedText, err := renderNoteTemplate(noteTemplateFor(feedItem, feedPost), feedItem, feedPost)
+	if err != nil {
+		log.Printf("[WARN] Template of %s failed, using default layout: %v", feedItem.URL, err)
+		feedText, _ = renderNoteTemplate("default", feedItem, feedPost)
+	}
 
-		ev.Sign(feedItem.Sec)
+	var tags nostr.Tags
 
-		nostrPostItem(ev)
-		if stats != nil {
-			atomic.AddInt64(&stats.postsPublished, 1)
+	if feedPost.Categories != nil { // use post categories as tags
+		for _, category := range feedPost.Categories {
+			tags = append(tags, nostr.Tag{"t", category})
 		}
+	}
 
-		if postID != "" {
-			markPostPublished(feedItem.URL, postID)
-		}
+	tags = append(tags, mediaTags(feedItem, feedPost, feedText)...)
+	tags = append(tags, podcastTags(feedItem, feedPost)...)
+	tags = append(tags, feedItem.proxyTag(feedPost))
+
+	return nostr.Event{
+		PubKey:    feedItem.Pub,
+		CreatedAt: nostr.Timestamp(itemTime),
+		Kind:      nostr.KindTextNote,
+		Tags:      tags,
+		Content:   feedText,
 	}
 }
 
+// dbWriteFeed stores a new feed as the only source of a new identity with
+// the keys of the feed.
 func (a *Atomstr) dbWriteFeed(feedItem *feedStruct) error {
-	_, err := a.db.Exec(`insert into feeds (pub, sec, url, state, failure_count, last_success, last_failure) values(?, ?, ?, ?, ?, ?, ?)`,
-		feedItem.Pub, feedItem.Sec, feedItem.URL, feedItem.State, feedItem.FailureCount, feedItem.LastSuccess, feedItem.LastFailure)
+	feedItem.SourcePub = feedItem.Pub
+	feedItem.PrimarySource = true
+	_, err := a.db.Exec(`insert into feeds (pub, sec, url, title, site_link, state, failure_count, last_success, last_failure, identity_pub, self_link) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		feedItem.Pub, feedItem.Sec, feedItem.URL, feedItem.Title, feedItem.Link, feedItem.State, feedItem.FailureCount, feedItem.LastSuccess, feedItem.LastFailure, feedItem.Pub, feedItem.Topic)
 	if err != nil {
 		return fmt.Errorf("can't add feed: %w", err)
 	}
-	nip19Pub, _ := nip19.EncodePublicKey(feedItem.Pub)
-	log.Println("[INFO] Added feed " + feedItem.URL + " with public key " + nip19Pub)
+	if _, err := a.db.Exec(`INSERT OR IGNORE INTO identities (pub, sec) VALUES (?, ?)`, feedItem.Pub, feedItem.Sec); err != nil {
+		return fmt.Errorf("can't add identity: %w", err)
+	}
+	if err := a.dbSaveFeedSettings(feedItem.SourcePub, feedItem.Settings); err != nil {
+		return err
+	}
+	feedItem.Npub, _ = nip19.EncodePublicKey(feedItem.Pub)
+	log.Println("[INFO] Added feed " + feedItem.URL + " with public key " + feedItem.Npub)
 	return nil
 }
 
+func (a *Atomstr) dbGetFeedByPub(feedPub string) (*feedStruct, error) {
+	feedItem, err := scanFeed(a.db.QueryRow(feedSelectSQL+` WHERE feeds.pub=?;`, feedPub))
+	if err == sql.ErrNoRows {
+		return &feedStruct{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read feed: %w", err)
+	}
+	return &feedItem, nil
+}
+
 func (a *Atomstr) dbGetFeed(feedURL string) *feedStruct {
-	sqlStatement := `SELECT pub, sec, url, state, failure_count, last_success, last_failure FROM feeds WHERE url=?;`
-	row := a.db.QueryRow(sqlStatement, feedURL)
+	row := a.db.QueryRow(feedSelectSQL+` WHERE url=?;`, feedURL)
 
-	feedItem := feedStruct{}
-	err := row.Scan(&feedItem.Pub, &feedItem.Sec, &feedItem.URL, &feedItem.State, &feedItem.FailureCount, &feedItem.LastSuccess, &feedItem.LastFailure)
+	feedItem, err := scanFeed(row)
 	if err != nil {
 		log.Println("[DEBUG] Feed not found in DB")
+		return &feedStruct{}
 	}
 	return &feedItem
 }
@@ -464,20 +827,43 @@ func checkValidFeedSource(feedURL string) (*feedStruct, error) {
 	log.Println("[DEBUG] Trying to find feed at", feedURL)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	fp := gofeed.NewParser()
-	fp.UserAgent = "atomstr/" + atomstrVersion
-	feed, err := fp.ParseURLWithContext(feedURL, ctx)
 	feedItem := feedStruct{}
 
+	// fetched by hand instead of ParseURL to see headers and raw document
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return &feedItem, err
+	}
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		log.Println("[ERROR] Not a valid feed source")
+		return &feedItem, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Println("[ERROR] Not a valid feed source")
+		return &feedItem, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &feedItem, err
+	}
+	if isCalendar(body) {
+		return checkValidCalendarSource(feedURL, body)
+	}
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
 	if err != nil {
 		log.Println("[ERROR] Not a valid feed source")
 		return &feedItem, err
 	}
+	feedItem.Hub, feedItem.Topic = findWebSubLinks(feedURL, resp.Header, body)
 	// FIXME! That needs proper error handling.
 	feedItem.URL = feedURL
 	feedItem.Title = feed.Title
 	feedItem.Description = feed.Description
 	feedItem.Link = feed.Link
+	feedItem.Podcast = parsePodcastInfo(feed)
 	if feed.Image != nil {
 		feedItem.Image = feed.Image.URL
 	} else {
@@ -499,25 +885,48 @@ func checkValidFeedSource(feedURL string) (*feedStruct, error) {
 	return &feedItem, err
 }
 
-func (a *Atomstr) addSource(feedURL string) (*feedStruct, error) {
+// errFeedExists is returned with the existing feed when a feed is added
+// again, possibly in another form of its URL.
+var errFeedExists = errors.New("feed already exists")
+
+// addSource adds the feed at feedURL, discovering it if the URL is a web
+// page. It is shared by the CLI, the web and OPML imports.
+func (a *Atomstr) addSource(feedURL string, settings feedSettings) (*feedStruct, error) {
+	feedURL = normalizeFeedURL(feedURL)
+	if feedTest := a.dbFindFeed(feedURL); feedTest.URL != "" {
+		log.Println("[WARN] Feed already exists as", feedTest.URL)
+		return feedTest, errFeedExists
+	}
 	// var feedElem2 *feedStruct
-	feedItem, err := checkValidFeedSource(feedURL)
+	feedItem, err := findFeedSource(feedURL)
 	// if feedItem.Title == "" {
+	var multiple *multipleFeedsError
+	if errors.As(err, &multiple) {
+		log.Printf("[INFO] %v", err)
+		return feedItem, err
+	}
 	if err != nil {
 		log.Println("[ERROR] No valid feed found on", feedURL)
 		return feedItem, err
 	}
+	return a.addFeed(feedItem, settings)
+}
 
-	// check for existing feed
-	feedTest := a.dbGetFeed(feedURL)
+// addFeed stores a new feed found by findFeedSource or
+// checkValidScrapedSource and publishes its history.
+func (a *Atomstr) addFeed(feedItem *feedStruct, settings feedSettings) (*feedStruct, error) {
+	// check for existing feed, discovery can lead to another form of its URL
+	feedItem.URL = normalizeFeedURL(feedItem.URL)
+	feedTest := a.dbFindFeed(feedItem.URL)
 	if feedTest.URL != "" {
-		log.Println("[WARN] Feed already exists")
-		return feedItem, err
+		log.Println("[WARN] Feed already exists as", feedTest.URL)
+		return feedTest, errFeedExists
 	}
 
-	feedItemKeys := generateKeysForURL(feedURL)
+	feedItemKeys := generateKeysForURL(feedItem.URL)
 	feedItem.Pub = feedItemKeys.Pub
 	feedItem.Sec = feedItemKeys.Sec
+	feedItem.Settings = settings
 
 	// Initialize state fields for new feeds
 	feedItem.State = "active"
@@ -526,22 +935,36 @@ func (a *Atomstr) addSource(feedURL string) (*feedStruct, error) {
 	feedItem.LastSuccess = &now
 	feedItem.LastFailure = nil
 
-	fmt.Println(feedItem.Pub)
-
 	if err := a.dbWriteFeed(feedItem); err != nil {
 		return feedItem, err
 	}
+	if feedItem.Scrape != nil {
+		if err := a.dbSaveScrapeConfig(feedItem.SourcePub, *feedItem.Scrape); err != nil {
+			return feedItem, err
+		}
+		feedItem.Posts = a.dateScrapedItems(feedItem.SourcePub, feedItem.Posts, true)
+	}
 	if !dryRunMode {
-		nostrUpdateFeedMetadata(feedItem)
+		a.nostrUpdateFeedMetadata(feedItem)
 	}
 
 	log.Println("[INFO] Parsing post history of new feed")
 	for i := range feedItem.Posts {
-		processFeedPost(*feedItem, feedItem.Posts[i], historyInterval, nil)
+		a.processFeedPost(*feedItem, feedItem.Posts[i], feedItem.effectiveHistoryInterval(), nil)
+	}
+	if feedItem.Calendar != nil {
+		a.publishCalendar(*feedItem, feedItem.Calendar, nil)
+	}
+	if err := a.dbUpdateFeedCursor(feedItem.URL, newestItemTime(feedItem.Posts)); err != nil {
+		log.Printf("[ERROR] %v", err)
 	}
 	log.Println("[INFO] Finished parsing post history of new feed")
+	a.scheduleNewFeed(feedItem)
+	if err := a.dbSaveWebSubHub(feedItem.SourcePub, feedItem.Hub, feedItem.Topic); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
 
-	return feedItem, err
+	return feedItem, nil
 }
 
 func (a *Atomstr) deleteSource(feedURL string) error {
@@ -553,6 +976,28 @@ func (a *Atomstr) deleteSource(feedURL string) error {
 		if err != nil {
 			return fmt.Errorf("can't remove feed: %w", err)
 		}
+		if _, err := a.db.Exec(`DELETE FROM feed_settings WHERE feed_pub=?;`, feedTest.SourcePub); err != nil {
+			return fmt.Errorf("can't remove feed settings: %w", err)
+		}
+		if _, err := a.db.Exec(`DELETE FROM feed_filters WHERE feed_pub=?;`, feedTest.SourcePub); err != nil {
+			return fmt.Errorf("can't remove feed filters: %w", err)
+		}
+		if _, err := a.db.Exec(`DELETE FROM scrape_sources WHERE feed_pub=?;`, feedTest.SourcePub); err != nil {
+			return fmt.Errorf("can't remove scrape source: %w", err)
+		}
+		if _, err := a.db.Exec(`DELETE FROM scraped_items WHERE feed_pub=?;`, feedTest.SourcePub); err != nil {
+			return fmt.Errorf("can't remove scraped items: %w", err)
+		}
+		if _, err := a.db.Exec(`DELETE FROM nip05_aliases WHERE feed_pub=?;`, feedTest.SourcePub); err != nil {
+			return fmt.Errorf("can't remove NIP-05 aliases: %w", err)
+		}
+		if a.scheduler != nil {
+			a.scheduler.remove(feedURL)
+		}
+		a.websubUnsubscribe(feedTest.SourcePub)
+		if _, err := a.db.Exec(`DELETE FROM identities WHERE pub=? AND NOT EXISTS (SELECT 1 FROM feeds WHERE identity_pub = identities.pub);`, feedTest.Pub); err != nil {
+			return fmt.Errorf("can't remove identity: %w", err)
+		}
 		log.Println("[INFO] feed removed")
 		return nil
 	} else {
@@ -578,9 +1023,12 @@ func (a *Atomstr) listFeeds() error {
 	return nil
 }
 
-func (a *Atomstr) dbUpdateFeedState(feedURL string, state string, failureCount int, lastSuccess *time.Time, lastFailure *time.Time) error {
-	_, err := a.db.Exec(`UPDATE feeds SET state = ?, failure_count = ?, last_success = ?, last_failure = ? WHERE url = ?`,
-		state, failureCount, lastSuccess, lastFailure, feedURL)
+// dbUpdateFeedState stores the health of a feed. Failures and back-offs
+// (429/503 responses) are counted separately, only failures can get a feed
+// marked as broken.
+func (a *Atomstr) dbUpdateFeedState(feedURL string, state string, failureCount int, backoffCount int, lastSuccess *time.Time, lastFailure *time.Time) error {
+	_, err := a.db.Exec(`UPDATE feeds SET state = ?, failure_count = ?, backoff_count = ?, last_success = ?, last_failure = ? WHERE url = ?`,
+		state, failureCount, backoffCount, lastSuccess, lastFailure, feedURL)
 	if err != nil {
 		return fmt.Errorf("can't update feed state: %w", err)
 	}
@@ -589,7 +1037,17 @@ func (a *Atomstr) dbUpdateFeedState(feedURL string, state string, failureCount i
 
 func (a *Atomstr) dbResetFeedState(feedURL string) error {
 	now := time.Now()
-	return a.dbUpdateFeedState(feedURL, "active", 0, &now, nil)
+	return a.dbUpdateFeedState(feedURL, "active", 0, 0, &now, nil)
+}
+
+// dbUpdateFeedInfo stores the title and site link of a feed, e.g. for the
+// OPML export.
+func (a *Atomstr) dbUpdateFeedInfo(feedURL string, title string, link string) error {
+	_, err := a.db.Exec(`UPDATE feeds SET title = ?, site_link = ? WHERE url = ?`, title, link, feedURL)
+	if err != nil {
+		return fmt.Errorf("can't update feed info: %w", err)
+	}
+	return nil
 }
 
 func (a *Atomstr) dbUpdateFeedCache(feedURL string, etag string, lastModified string) error {
@@ -601,6 +1059,28 @@ func (a *Atomstr) dbUpdateFeedCache(feedURL string, etag string, lastModified st
 	return nil
 }
 
+// dbUpdateFeedCursor stores the publish time of the newest item seen in a
+// feed. It is the high-water mark used to catch up after downtime.
+func (a *Atomstr) dbUpdateFeedCursor(feedURL string, lastItemAt *time.Time) error {
+	if lastItemAt == nil {
+		return nil
+	}
+	_, err := a.db.Exec(`UPDATE feeds SET last_item_at = ? WHERE url = ?`, lastItemAt.UTC(), feedURL)
+	if err != nil {
+		return fmt.Errorf("can't update feed cursor: %w", err)
+	}
+	return nil
+}
+
+func (a *Atomstr) dbUpdateFeedSchedule(feedURL string, nextFetchAt time.Time, pollInterval time.Duration) error {
+	_, err := a.db.Exec(`UPDATE feeds SET next_fetch_at = ?, poll_interval = ? WHERE url = ?`,
+		nextFetchAt.UTC(), int64(pollInterval/time.Second), feedURL)
+	if err != nil {
+		return fmt.Errorf("can't update feed schedule: %w", err)
+	}
+	return nil
+}
+
 func (a *Atomstr) shouldFetchFeed(feedItem feedStruct) bool {
 	if feedItem.State != "broken" {
 		return true
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func testItem(guid, link string) *gofeed.Item {
+	now := time.Now()
+	return &gofeed.Item{Title: "Item " + guid, GUID: guid, Link: link, Description: "Text of " + guid, PublishedParsed: &now}
+}
+
+func TestProcessFeedPostSharedLink(t *testing.T) {
+	a := newTestAtomstr(t)
+
+	// podcasts often link every episode to the show page
+	feedItem := addTestFeed(t, a, "https://podcast.example.org/feed.xml")
+	for _, guid := range []string{"episode-1", "episode-2"} {
+		if !a.processFeedPost(*feedItem, testItem(guid, "https://podcast.example.org/"), time.Hour, nil) {
+			t.Errorf("%s with the shared link of a single feed was not published", guid)
+		}
+	}
+
+	// the same article in two feeds of one identity is published once
+	news := addTestFeed(t, a, "https://example.org/news.xml")
+	blog := addTestFeed(t, a, "https://example.org/blog.xml")
+	if err := a.dbSetFeedIdentity(*blog, news.Pub); err != nil {
+		t.Fatal(err)
+	}
+	news, blog = testFeed(t, a, news.URL), testFeed(t, a, blog.URL)
+	if !news.SharedIdentity || !blog.SharedIdentity {
+		t.Fatalf("joined feeds don't share their identity")
+	}
+	if !a.processFeedPost(*news, testItem("news-1", "https://example.org/story"), time.Hour, nil) {
+		t.Errorf("story was not published by the first feed")
+	}
+	if a.processFeedPost(*blog, testItem("blog-1", "https://example.org/story"), time.Hour, nil) {
+		t.Errorf("story was published again by the second feed of the identity")
+	}
+	if !a.processFeedPost(*news, testItem("news-2", "https://example.org/story"), time.Hour, nil) {
+		t.Errorf("second item of the first feed with the same link was not published")
+	}
+}
+
+func TestProcessFeedPostChangesOfOtherSource(t *testing.T) {
+	a := newTestAtomstr(t)
+	news := addTestFeed(t, a, "https://example.org/news.xml")
+	mirror := addTestFeed(t, a, "https://mirror.example.org/news.xml")
+	if err := a.dbSetFeedIdentity(*mirror, news.Pub); err != nil {
+		t.Fatal(err)
+	}
+	news, mirror = testFeed(t, a, news.URL), testFeed(t, a, mirror.URL)
+	mirror.Settings.TrackChanges = true
+
+	if !a.processFeedPost(*news, testItem("story", "https://example.org/story"), time.Hour, nil) {
+		t.Fatalf("story was not published")
+	}
+	published := a.dbGetPublishedItem(news.Pub, "story")
+	if published == nil || published.SourcePub != news.SourcePub {
+		t.Fatalf("story is not stored as published by the first feed: %+v", published)
+	}
+
+	// the mirror has the same GUID with other content, it's no change
+	changed := testItem("story", "https://mirror.example.org/story")
+	changed.Description = "Text of the mirror"
+	a.processFeedPost(*mirror, changed, time.Hour, nil)
+	after := a.dbGetPublishedItem(news.Pub, "story")
+	if after.SourcePub != news.SourcePub || after.EventID != published.EventID {
+		t.Errorf("story of the first feed was replaced by the mirror: %+v", after)
+	}
+}
+
+func TestProcessFeedPostTrackChanges(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	feedItem.Settings.TrackChanges = true
+
+	if !a.processFeedPost(*feedItem, testItem("story", "https://example.org/story"), time.Hour, nil) {
+		t.Fatalf("story was not published")
+	}
+	published := a.dbGetPublishedItem(feedItem.Pub, "story")
+
+	// another layout of the same item is no change
+	feedItem.Settings.Template = "title-link"
+	a.processFeedPost(*feedItem, testItem("story", "https://example.org/story"), time.Hour, nil)
+	if after := a.dbGetPublishedItem(feedItem.Pub, "story"); after.EventID != published.EventID {
+		t.Errorf("story was republished after a template change")
+	}
+
+	edited := testItem("story", "https://example.org/story")
+	edited.Title = "Corrected title"
+	a.processFeedPost(*feedItem, edited, time.Hour, nil)
+	after := a.dbGetPublishedItem(feedItem.Pub, "story")
+	if after.EventID == published.EventID || after.ContentHash != itemContentHash(edited) {
+		t.Errorf("edited story was not republished: %+v", after)
+	}
+}
+
+func TestPublishedItems(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	if a.dbGetPublishedItem(feedItem.Pub, "item") != nil {
+		t.Fatalf("unpublished item was found")
+	}
+
+	itemTime := time.Date(2026, 10, 15, 8, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	item := publishedItem{ItemKey: "item", EventID: "event", TeaserID: "teaser", Kind: 30023, ContentHash: "hash", ItemTime: &itemTime, Link: "https://example.org/item"}
+	if err := a.dbMarkPostPublished(*feedItem, item); err != nil {
+		t.Fatal(err)
+	}
+	got := a.dbGetPublishedItem(feedItem.Pub, "item")
+	if got == nil {
+		t.Fatalf("published item was not found")
+	}
+	if got.EventID != "event" || got.TeaserID != "teaser" || got.Kind != 30023 || got.ContentHash != "hash" || got.SourcePub != feedItem.SourcePub {
+		t.Errorf("stored item is %+v", got)
+	}
+	if got.ItemTime == nil || !got.ItemTime.Equal(itemTime) {
+		t.Errorf("item time is %v, want %v", got.ItemTime, itemTime)
+	}
+	if other := addTestFeed(t, a, "https://example.org/other.xml"); a.dbGetPublishedItem(other.Pub, "item") != nil {
+		t.Errorf("item was found for another feed")
+	}
+
+	// an update replaces the stored item
+	item.EventID = "update"
+	if err := a.dbMarkPostPublished(*feedItem, item); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.dbGetPublishedItem(feedItem.Pub, "item"); got == nil || got.EventID != "update" {
+		t.Errorf("updated item is %+v", got)
+	}
+
+	if err := a.dbDeletePublishedItem(feedItem.Pub, "item"); err != nil {
+		t.Fatal(err)
+	}
+	if a.dbGetPublishedItem(feedItem.Pub, "item") != nil {
+		t.Errorf("deleted item was found")
+	}
+}
+
+func TestPrunePublishedPosts(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	past := time.Now().Add(-48 * time.Hour)
+	upcoming := time.Now().Add(48 * time.Hour)
+	for _, item := range []publishedItem{
+		{ItemKey: "old", EventID: "1", ItemTime: &past},
+		{ItemKey: "old without time", EventID: "2"},
+		{ItemKey: "upcoming event", EventID: "3", ItemTime: &upcoming},
+		{ItemKey: "recent", EventID: "4", ItemTime: &past},
+	} {
+		if err := a.dbMarkPostPublished(*feedItem, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.db.Exec(`UPDATE published_items SET published_at = ? WHERE item_key != 'recent'`, time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.dbPrunePublishedPosts(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"old": false, "old without time": false, "upcoming event": true, "recent": true} {
+		if got := a.dbGetPublishedItem(feedItem.Pub, key) != nil; got != want {
+			t.Errorf("%q kept: %v, want %v", key, got, want)
+		}
+	}
+}
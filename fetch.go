@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is the number of redirects followed by fetchClient.
+const maxRedirects = 5
+
+// blockedNetworks are destinations that aren't public besides the loopback,
+// private and link-local ones known to the net package.
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // reserved, broadcast
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, embeds IPv4 addresses
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// errResponseTooLarge is returned when a response body exceeds
+// FETCH_MAX_SIZE_MB.
+var errResponseTooLarge = errors.New("response too large")
+
+// fetchTransport is the transport of all requests to URLs from feeds and
+// users. It only connects to public addresses, checked after DNS resolution
+// for every connection, so redirects and DNS rebinding can't reach internal
+// services. Hosts and networks in FETCH_ALLOWLIST are exempt.
+var fetchTransport http.RoundTripper = &limitedTransport{base: &http.Transport{
+	DialContext:           fetchDialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+}}
+
+// fetchClient is the client for requests that don't need their own timeout
+// or redirect handling.
+var fetchClient = newFetchClient(time.Minute)
+
+func newFetchClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: fetchTransport, CheckRedirect: checkFetchRedirect, Timeout: timeout}
+}
+
+// checkFetchRedirect limits the number of redirects. The destination is
+// checked by the transport like the first request.
+func checkFetchRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
+
+func fetchDialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if !fetchAllowed(host, nil) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !fetchAllowed(host, net.ParseIP(ip)) {
+				log.Printf("[WARN] Blocked request to %s (%s), it isn't a public address", host, ip)
+				return fmt.Errorf("%s resolves to %s, which isn't a public address", host, ip)
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// fetchAllowed reports whether a host may be fetched from the address ip.
+// With a nil ip it only checks the host against FETCH_ALLOWLIST and the
+// Blossom server.
+func fetchAllowed(host string, ip net.IP) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if blossomServer != "" {
+		if u, err := url.Parse(blossomServer); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	for _, entry := range fetchAllowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil {
+			if ip != nil && allowed.Equal(ip) {
+				return true
+			}
+		} else if strings.EqualFold(entry, host) {
+			return true
+		}
+	}
+	if ip == nil {
+		return false
+	}
+	return publicIP(ip)
+}
+
+// publicIP reports whether ip is a public unicast address.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitedTransport only allows http and https URLs and limits response
+// bodies to FETCH_MAX_SIZE_MB.
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", req.URL.Scheme)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := int64(maxFetchSizeMB) << 20
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s has %d bytes", errResponseTooLarge, req.URL, resp.ContentLength)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	return resp, nil
+}
+
+// limitedBody fails instead of returning a truncated body, so a cut off
+// feed isn't taken for a complete one.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:192.168.1.1", false},
+		{"::ffff:93.184.216.34", true},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestFetchAllowed(t *testing.T) {
+	defer func(allowlist []string, blossom string) {
+		fetchAllowlist, blossomServer = allowlist, blossom
+	}(fetchAllowlist, blossomServer)
+	fetchAllowlist = []string{"10.0.0.0/8", "192.168.1.10", "feeds.internal"}
+	blossomServer = "http://Blossom.local:3000/"
+
+	tests := []struct {
+		name string
+		host string
+		ip   string
+		want bool
+	}{
+		{"public", "example.org", "93.184.216.34", true},
+		{"private", "example.org", "192.168.1.11", false},
+		{"allowed network", "example.org", "10.20.30.40", true},
+		{"allowed ip", "example.org", "192.168.1.10", true},
+		{"allowed host", "feeds.internal", "127.0.0.1", true},
+		{"allowed host with dot", "Feeds.Internal.", "127.0.0.1", true},
+		{"allowed host without ip", "feeds.internal", "", true},
+		{"blossom server", "blossom.local", "127.0.0.1", true},
+		{"blossom server without ip", "blossom.local", "", true},
+		{"other host without ip", "example.org", "", false},
+		{"loopback", "localhost", "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fetchAllowed(tt.host, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("fetchAllowed(%s, %s) = %v, want %v", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"below limit", 99, nil},
+		{"at limit", 100, nil},
+		{"one byte over", 101, errResponseTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &limitedBody{ReadCloser: io.NopCloser(strings.NewReader(strings.Repeat("x", tt.size))), remaining: 100}
+			data, err := io.ReadAll(body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("read error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(data) != tt.size {
+				t.Errorf("read %d bytes, want %d", len(data), tt.size)
+			}
+		})
+	}
+}
+
+type fakeRoundTripper func(*http.Request) (*http.Response, error)
+
+func (f fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLimitedTransport(t *testing.T) {
+	limit := int64(maxFetchSizeMB) << 20
+	transport := &limitedTransport{base: fakeRoundTripper(func(req *http.Request) (*http.Response, error) {
+		length := limit
+		if req.URL.Path == "/large" {
+			length = limit + 1
+		}
+		return &http.Response{StatusCode: http.StatusOK, ContentLength: length, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://example.org/feed", false},
+		{"https://example.org/large", true},
+		{"file:///etc/passwd", true},
+		{"ftp://example.org/feed", true},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RoundTrip(%s) error %v, want error %v", tt.url, err, tt.wantErr)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
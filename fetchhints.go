@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// feedHints are the polling hints of an RSS document. They are stored with
+// the feed, so they still apply when the server answers with a 304.
+type feedHints struct {
+	TTL       time.Duration
+	SkipHours []int          // hours (GMT) in which the feed is not fetched
+	SkipDays  []time.Weekday // days (GMT) on which the feed is not fetched
+}
+
+// backoffError is returned for 429 and 503 responses. The server is asking
+// us to come back later, which is not a failure of the feed.
+type backoffError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("HTTP %d, retry after %v", e.StatusCode, e.RetryAfter)
+}
+
+// parseFeedHints reads ttl, skipHours and skipDays from an RSS document.
+func parseFeedHints(body []byte) feedHints {
+	var hints feedHints
+	feed, err := (&rss.Parser{}).Parse(bytes.NewReader(body))
+	if err != nil {
+		return hints
+	}
+	if minutes, err := strconv.Atoi(strings.TrimSpace(feed.TTL)); err == nil && minutes > 0 {
+		hints.TTL = time.Duration(minutes) * time.Minute
+	}
+	for _, hour := range feed.SkipHours {
+		if h, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil && h >= 0 && h <= 24 {
+			hints.SkipHours = append(hints.SkipHours, h%24) // some feeds use 1-24
+		}
+	}
+	for _, day := range feed.SkipDays {
+		if d, ok := parseWeekday(day); ok {
+			hints.SkipDays = append(hints.SkipDays, d)
+		}
+	}
+	return hints
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.TrimSpace(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// skipped reports whether t falls into a skipped hour or day.
+func (h feedHints) skipped(t time.Time) bool {
+	t = t.UTC()
+	for _, hour := range h.SkipHours {
+		if t.Hour() == hour {
+			return true
+		}
+	}
+	for _, day := range h.SkipDays {
+		if t.Weekday() == day {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAllowed moves t forward to the first hour that is not skipped.
+func (h feedHints) nextAllowed(t time.Time) time.Time {
+	// skipping every hour of the week would never fetch the feed again
+	for i := 0; i < 7*24 && h.skipped(t); i++ {
+		t = t.UTC().Truncate(time.Hour).Add(time.Hour)
+	}
+	return t
+}
+
+func (h feedHints) formatSkipHours() string {
+	hours := make([]string, len(h.SkipHours))
+	for i, hour := range h.SkipHours {
+		hours[i] = strconv.Itoa(hour)
+	}
+	return strings.Join(hours, ",")
+}
+
+func (h feedHints) formatSkipDays() string {
+	days := make([]string, len(h.SkipDays))
+	for i, day := range h.SkipDays {
+		days[i] = day.String()
+	}
+	return strings.Join(days, ",")
+}
+
+// parseStoredHints restores the hints saved by dbUpdateFeedHints.
+func parseStoredHints(ttlSeconds int64, skipHours, skipDays string) feedHints {
+	hints := feedHints{TTL: time.Duration(ttlSeconds) * time.Second}
+	for _, hour := range splitAndTrim(skipHours) {
+		if h, err := strconv.Atoi(hour); err == nil {
+			hints.SkipHours = append(hints.SkipHours, h)
+		}
+	}
+	for _, day := range splitAndTrim(skipDays) {
+		if d, ok := parseWeekday(day); ok {
+			hints.SkipDays = append(hints.SkipDays, d)
+		}
+	}
+	return hints
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header.
+func parseMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// responseMaxAge returns how long a response stays fresh. Cache-Control
+// max-age takes precedence over Expires, as in HTTP caches.
+func responseMaxAge(header http.Header) time.Duration {
+	if maxAge := parseMaxAge(header.Get("Cache-Control")); maxAge > 0 {
+		return maxAge
+	}
+	expires, err := http.ParseTime(header.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	now := time.Now()
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		now = date // avoid clock skew between us and the server
+	}
+	if maxAge := expires.Sub(now); maxAge > 0 {
+		return maxAge
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// backoffInterval returns the delay after the n-th consecutive 429/503
+// response. Retry-After wins, otherwise the delay doubles. Both are capped
+// at maxFetchInterval, so a server can't stop us from ever coming back.
+func backoffInterval(n int, retryAfter time.Duration) time.Duration {
+	limit := max(maxFetchInterval, fetchInterval)
+	if retryAfter > 0 {
+		return min(retryAfter, limit)
+	}
+	wait := fetchInterval
+	for i := 1; i < n && wait < maxFetchInterval; i++ {
+		wait *= 2
+	}
+	return min(wait, limit)
+}
+
+func (a *Atomstr) dbUpdateFeedHints(feedURL string, hints feedHints) error {
+	_, err := a.db.Exec(`UPDATE feeds SET feed_ttl = ?, skip_hours = ?, skip_days = ? WHERE url = ?`,
+		int64(hints.TTL/time.Second), hints.formatSkipHours(), hints.formatSkipDays(), feedURL)
+	if err != nil {
+		return fmt.Errorf("can't update feed hints: %w", err)
+	}
+	return nil
+}
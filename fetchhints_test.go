@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"", 0},
+		{"max-age=600", 10 * time.Minute},
+		{"public, Max-Age=3600, must-revalidate", time.Hour},
+		{`max-age="120"`, 2 * time.Minute},
+		{"no-cache", 0},
+		{"max-age=-1", 0},
+		{"max-age=abc", 0},
+		{"s-maxage=600", 0},
+	}
+	for _, tt := range tests {
+		if got := parseMaxAge(tt.cacheControl); got != tt.want {
+			t.Errorf("parseMaxAge(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
+
+func TestResponseMaxAge(t *testing.T) {
+	date := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"max-age", http.Header{"Cache-Control": {"max-age=900"}}, 15 * time.Minute},
+		{"expires relative to date", http.Header{"Date": {date.Format(http.TimeFormat)}, "Expires": {date.Add(2 * time.Hour).Format(http.TimeFormat)}}, 2 * time.Hour},
+		{"max-age wins", http.Header{"Cache-Control": {"max-age=60"}, "Date": {date.Format(http.TimeFormat)}, "Expires": {date.Add(2 * time.Hour).Format(http.TimeFormat)}}, time.Minute},
+		{"expired", http.Header{"Date": {date.Format(http.TimeFormat)}, "Expires": {date.Add(-time.Hour).Format(http.TimeFormat)}}, 0},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseMaxAge(tt.header); got != tt.want {
+				t.Errorf("responseMaxAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"negative", "-5", 0},
+		{"date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Hour},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+		{"invalid", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value).Round(time.Minute); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedHints(t *testing.T) {
+	body := []byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Hints</title><ttl>90</ttl>
+		<skipHours><hour>0</hour><hour>24</hour><hour>7</hour></skipHours>
+		<skipDays><day>Saturday</day><day>sunday</day></skipDays></channel></rss>`)
+	hints := parseFeedHints(body)
+	if hints.TTL != 90*time.Minute {
+		t.Errorf("TTL = %v, want 90m", hints.TTL)
+	}
+	if got := hints.formatSkipHours(); got != "0,0,7" {
+		t.Errorf("skip hours = %s, want 0,0,7", got)
+	}
+	if got := hints.formatSkipDays(); got != "Saturday,Sunday" {
+		t.Errorf("skip days = %s, want Saturday,Sunday", got)
+	}
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	if next := hints.nextAllowed(saturday); !next.Equal(time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("nextAllowed(%v) = %v, want monday 01:00", saturday, next)
+	}
+}
+
+func TestBackoffInterval(t *testing.T) {
+	defer func(interval, maxInterval time.Duration) {
+		fetchInterval, maxFetchInterval = interval, maxInterval
+	}(fetchInterval, maxFetchInterval)
+	fetchInterval, maxFetchInterval = 15*time.Minute, 4*time.Hour
+
+	tests := []struct {
+		name       string
+		n          int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"first", 1, 0, 15 * time.Minute},
+		{"doubled", 3, 0, time.Hour},
+		{"capped", 10, 0, 4 * time.Hour},
+		{"retry-after", 5, 10 * time.Minute, 10 * time.Minute},
+		{"retry-after capped", 1, 30 * 24 * time.Hour, 4 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoffInterval(tt.n, tt.retryAfter); got != tt.want {
+				t.Errorf("backoffInterval(%d, %v) = %v, want %v", tt.n, tt.retryAfter, got, tt.want)
+			}
+		})
+	}
+}
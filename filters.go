@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// filterFields are the item fields filter rules can match.
+var filterFields = []string{"title", "content", "link", "category", "author"}
+
+// filterRule is a per-feed rule deciding whether an item is published.
+// Rules have the form "include <field> <regexp>", "exclude <field> <regexp>"
+// or "min_length <characters>".
+type filterRule struct {
+	ID        int64
+	Action    string // include, exclude or min_length
+	Field     string
+	Pattern   string
+	MinLength int
+	re        *regexp.Regexp
+}
+
+func (r filterRule) String() string {
+	if r.Action == "min_length" {
+		return fmt.Sprintf("min_length %d", r.MinLength)
+	}
+	return fmt.Sprintf("%s %s %s", r.Action, r.Field, r.Pattern)
+}
+
+// parseFilterRule parses a rule given as text.
+func parseFilterRule(text string) (filterRule, error) {
+	var rule filterRule
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("empty filter rule")
+	}
+	rule.Action = strings.ToLower(fields[0])
+	switch rule.Action {
+	case "min_length":
+		if len(fields) != 2 {
+			return rule, fmt.Errorf("use min_length <characters>")
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return rule, fmt.Errorf("invalid length %q", fields[1])
+		}
+		rule.MinLength = n
+		return rule, nil
+	case "include", "exclude":
+		if len(fields) < 3 {
+			return rule, fmt.Errorf("use %s <field> <regexp>", rule.Action)
+		}
+	default:
+		return rule, fmt.Errorf("unknown filter action %q, use include, exclude or min_length", fields[0])
+	}
+
+	rule.Field = strings.ToLower(fields[1])
+	if !validFilterField(rule.Field) {
+		return rule, fmt.Errorf("unknown filter field %q, use one of %s", fields[1], strings.Join(filterFields, ", "))
+	}
+	// the pattern is the rest of the rule and may contain spaces
+	rest := strings.TrimSpace(text)
+	for i := 0; i < 2; i++ {
+		rest = strings.TrimSpace(rest[len(strings.Fields(rest)[0]):])
+	}
+	re, err := regexp.Compile(rest)
+	if err != nil {
+		return rule, fmt.Errorf("invalid regexp %q: %w", rest, err)
+	}
+	rule.Pattern = rest
+	rule.re = re
+	return rule, nil
+}
+
+func validFilterField(field string) bool {
+	for _, f := range filterFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the regexp of an include or exclude rule matches
+// the item.
+func (r filterRule) matches(feedPost *gofeed.Item) bool {
+	switch r.Field {
+	case "title":
+		return r.re.MatchString(feedPost.Title)
+	case "content":
+		return r.re.MatchString(htmlToPlainText(feedPost.Description)) || r.re.MatchString(htmlToPlainText(feedPost.Content))
+	case "link":
+		return r.re.MatchString(feedPost.Link)
+	case "category":
+		for _, category := range feedPost.Categories {
+			if r.re.MatchString(category) {
+				return true
+			}
+		}
+	case "author":
+		if feedPost.Author != nil && r.re.MatchString(feedPost.Author.Name) {
+			return true
+		}
+		for _, author := range feedPost.Authors {
+			if author != nil && r.re.MatchString(author.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// onText reports whether the rule looks at the text of the item, which the
+// full article can change.
+func (r filterRule) onText() bool {
+	return r.Action == "min_length" || r.Field == "content"
+}
+
+// rulesBeforeExpansion returns the rules that don't depend on the full
+// article of an item. Include rules are left out if one of them is on the
+// text, an item only has to match one of them.
+func rulesBeforeExpansion(rules []filterRule) []filterRule {
+	includeOnText := false
+	for _, rule := range rules {
+		if rule.Action == "include" && rule.onText() {
+			includeOnText = true
+		}
+	}
+	var before []filterRule
+	for _, rule := range rules {
+		if rule.onText() || (rule.Action == "include" && includeOnText) {
+			continue
+		}
+		before = append(before, rule)
+	}
+	return before
+}
+
+// filterPost applies the rules of a feed to an item. It returns false and
+// the rule responsible if the item must not be published. With include
+// rules, an item has to match at least one of them.
+func filterPost(rules []filterRule, feedPost *gofeed.Item) (bool, string) {
+	included, hasInclude := false, false
+	for _, rule := range rules {
+		switch rule.Action {
+		case "min_length":
+			text := htmlToPlainText(feedPost.Content)
+			if desc := htmlToPlainText(feedPost.Description); len(desc) > len(text) {
+				text = desc
+			}
+			if len([]rune(text)) < rule.MinLength {
+				return false, rule.String()
+			}
+		case "exclude":
+			if rule.matches(feedPost) {
+				return false, rule.String()
+			}
+		case "include":
+			hasInclude = true
+			if !included && rule.matches(feedPost) {
+				included = true
+			}
+		}
+	}
+	if hasInclude && !included {
+		return false, "no include rule matched"
+	}
+	return true, ""
+}
+
+func (a *Atomstr) dbGetFeedFilters(feedPub string) ([]filterRule, error) {
+	rows, err := a.db.Query(`SELECT id, rule FROM feed_filters WHERE feed_pub = ? ORDER BY id`, feedPub)
+	if err != nil {
+		return nil, fmt.Errorf("can't read filters: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []filterRule
+	for rows.Next() {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, fmt.Errorf("can't read filters: %w", err)
+		}
+		rule, err := parseFilterRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %d: %w", id, err)
+		}
+		rule.ID = id
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (a *Atomstr) dbAddFeedFilter(feedPub string, rule filterRule) error {
+	if _, err := a.db.Exec(`INSERT INTO feed_filters (feed_pub, rule) VALUES (?, ?)`, feedPub, rule.String()); err != nil {
+		return fmt.Errorf("can't add filter: %w", err)
+	}
+	return nil
+}
+
+func (a *Atomstr) dbRemoveFeedFilter(feedPub string, id int64) error {
+	res, err := a.db.Exec(`DELETE FROM feed_filters WHERE feed_pub = ? AND id = ?`, feedPub, id)
+	if err != nil {
+		return fmt.Errorf("can't remove filter: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("filter %d not found", id)
+	}
+	return nil
+}
+
+// updateFeedFilters adds or removes a filter rule of a feed, args are
+// "add <rule>" or "remove <id>".
+func (a *Atomstr) updateFeedFilters(feedURL string, args []string) error {
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed not found")
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("use add <rule> or remove <id>")
+	}
+	switch args[0] {
+	case "add":
+		rule, err := parseFilterRule(strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+		return a.dbAddFeedFilter(feedItem.SourcePub, rule)
+	case "remove":
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid filter id %q", args[1])
+		}
+		return a.dbRemoveFeedFilter(feedItem.SourcePub, id)
+	}
+	return fmt.Errorf("unknown filter command %q, use add or remove", args[0])
+}
+
+// feedFilters is the -filter command. Without arguments it lists the rules
+// of the feed.
+func (a *Atomstr) feedFilters(feedURL string, args []string) error {
+	if len(args) > 0 {
+		return a.updateFeedFilters(feedURL, args)
+	}
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed not found")
+	}
+	rules, err := a.dbGetFeedFilters(feedItem.SourcePub)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Println("no filters")
+	}
+	for _, rule := range rules {
+		fmt.Printf("%d: %s\n", rule.ID, rule)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestParseFilterRule(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"include title bitcoin", "include title bitcoin", false},
+		{"EXCLUDE Category  (?i)sponsored post", "exclude category (?i)sponsored post", false},
+		{"min_length 200", "min_length 200", false},
+		{"min_length 0", "", true},
+		{"min_length", "", true},
+		{"include title", "", true},
+		{"include body x", "", true},
+		{"exclude title (", "", true},
+		{"drop title x", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		rule, err := parseFilterRule(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFilterRule(%q) error %v, want error %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if err == nil && rule.String() != tt.want {
+			t.Errorf("parseFilterRule(%q) = %q, want %q", tt.text, rule, tt.want)
+		}
+	}
+}
+
+func TestFilterPost(t *testing.T) {
+	post := &gofeed.Item{
+		Title:       "Weekly Bitcoin news",
+		Description: "<p>Short <b>teaser</b></p>",
+		Content:     "<p>The full article about the halving.</p>",
+		Link:        "https://example.org/news/42",
+		Categories:  []string{"Crypto", "Sponsored"},
+		Authors:     []*gofeed.Person{{Name: "Alice"}},
+	}
+	rules := func(texts ...string) []filterRule {
+		var rules []filterRule
+		for _, text := range texts {
+			rule, err := parseFilterRule(text)
+			if err != nil {
+				t.Fatalf("parseFilterRule(%q): %v", text, err)
+			}
+			rules = append(rules, rule)
+		}
+		return rules
+	}
+
+	tests := []struct {
+		name       string
+		rules      []filterRule
+		want       bool
+		wantReason string
+	}{
+		{"no rules", nil, true, ""},
+		{"include title", rules("include title (?i)bitcoin"), true, ""},
+		{"include no match", rules("include title ethereum"), false, "no include rule matched"},
+		{"any include", rules("include title ethereum", "include link /news/"), true, ""},
+		{"exclude category", rules("exclude category ^Sponsored$"), false, "exclude category ^Sponsored$"},
+		{"exclude wins over include", rules("include title Bitcoin", "exclude author Alice"), false, "exclude author Alice"},
+		{"content without html", rules("include content halving\\."), true, ""},
+		{"content tags stripped", rules("exclude content <b>"), true, ""},
+		{"min_length", rules("min_length 40"), false, "min_length 40"},
+		{"min_length of content", rules("min_length 30"), true, ""},
+		{"case sensitive", rules("exclude title bitcoin"), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := filterPost(tt.rules, post)
+			if ok != tt.want || reason != tt.wantReason {
+				t.Errorf("filterPost() = %v, %q, want %v, %q", ok, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestUpdateChangedPostFiltered(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	feedItem.Settings.TrackChanges = true
+	rule, err := parseFilterRule("exclude category sponsored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.dbAddFeedFilter(feedItem.SourcePub, rule); err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.processFeedPost(*feedItem, testItem("story", "https://example.org/story"), time.Hour, nil) {
+		t.Fatalf("story was not published")
+	}
+	relabelled := testItem("story", "https://example.org/story")
+	relabelled.Categories = []string{"sponsored"}
+	a.processFeedPost(*feedItem, relabelled, time.Hour, nil)
+	if published := a.dbGetPublishedItem(feedItem.Pub, "story"); published != nil {
+		t.Errorf("story relabelled as sponsored is still published: %+v", published)
+	}
+	if a.processFeedPost(*feedItem, relabelled, time.Hour, nil) {
+		t.Errorf("filtered story was published again")
+	}
+}
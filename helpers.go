@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -40,6 +41,62 @@ func checkMaxAge(itemTime *time.Time, maxAgeHours time.Duration) bool {
 	return itemTime.UTC().After(maxAge)
 }
 
+// catchUpInterval returns the max age for items of a feed whose newest known
+// item was published at lastItemAt. It never goes below the feed's fetch
+// interval and is capped by maxBacklog, or the fetch interval if that is
+// longer, so a long outage doesn't flood the relays.
+func catchUpInterval(lastItemAt *time.Time, feedInterval time.Duration) time.Duration {
+	interval := feedInterval
+	if lastItemAt != nil {
+		if since := time.Since(*lastItemAt); since > interval {
+			interval = since
+		}
+	}
+	return min(interval, max(maxBacklog, feedInterval))
+}
+
+// itemsOldestFirst returns the items sorted by date, oldest first. Items
+// without a parseable date are moved to the end.
+func itemsOldestFirst(items []*gofeed.Item) []*gofeed.Item {
+	type datedItem struct {
+		item *gofeed.Item
+		time *time.Time
+	}
+	dated := make([]datedItem, len(items))
+	for i, item := range items {
+		itemTime, _ := parseFeedDate(item)
+		dated[i] = datedItem{item, itemTime}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		if dated[i].time == nil || dated[j].time == nil {
+			return dated[j].time == nil && dated[i].time != nil
+		}
+		return dated[i].time.Before(*dated[j].time)
+	})
+	sorted := make([]*gofeed.Item, len(items))
+	for i, d := range dated {
+		sorted[i] = d.item
+	}
+	return sorted
+}
+
+// newestItemTime returns the publish time of the newest item, ignoring items
+// without a parseable date or dated in the future.
+func newestItemTime(items []*gofeed.Item) *time.Time {
+	var newest *time.Time
+	now := time.Now()
+	for _, item := range items {
+		itemTime, err := parseFeedDate(item)
+		if err != nil || itemTime.After(now) {
+			continue
+		}
+		if newest == nil || itemTime.After(*newest) {
+			newest = itemTime
+		}
+	}
+	return newest
+}
+
 func dbInit() *sql.DB {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
@@ -111,6 +168,544 @@ func migrateDB(db *sql.DB) {
 			log.Println("[INFO] HTTP caching columns migration completed")
 		}
 	}
+
+	// Check if published_items table exists
+	var publishedExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'published_items'
+	`).Scan(&publishedExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for published_items table: %v", err)
+		return
+	}
+
+	if !publishedExists {
+		log.Println("[INFO] Migrating database: adding published_items table")
+		_, err := db.Exec(`
+			CREATE TABLE published_items (
+				feed_pub VARCHAR(64) NOT NULL,
+				item_key TEXT NOT NULL,
+				event_id VARCHAR(64),
+				content_hash VARCHAR(64),
+				published_at DATETIME,
+				PRIMARY KEY (feed_pub, item_key)
+			);
+			CREATE INDEX published_items_published_at ON published_items (published_at);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for published_items table: %v", err)
+		} else {
+			log.Println("[INFO] published_items table migration completed")
+		}
+	}
+
+	// Check if last_item_at column exists
+	var cursorExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('feeds')
+		WHERE name = 'last_item_at'
+	`).Scan(&cursorExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for last_item_at column: %v", err)
+		return
+	}
+
+	if !cursorExists {
+		log.Println("[INFO] Migrating database: adding feed cursor column")
+		_, err := db.Exec(`ALTER TABLE feeds ADD COLUMN last_item_at DATETIME;`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed cursor column: %v", err)
+		} else {
+			log.Println("[INFO] Feed cursor column migration completed")
+		}
+	}
+
+	// Check if publish_results table exists
+	var resultsExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'publish_results'
+	`).Scan(&resultsExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for publish_results table: %v", err)
+		return
+	}
+
+	if !resultsExists {
+		log.Println("[INFO] Migrating database: adding publish_results table")
+		_, err := db.Exec(`
+			CREATE TABLE publish_results (
+				event_id VARCHAR(64) NOT NULL,
+				relay TEXT NOT NULL,
+				type TEXT NOT NULL DEFAULT 'ok',
+				ok INTEGER NOT NULL DEFAULT 0,
+				message TEXT DEFAULT '',
+				created_at DATETIME
+			);
+			CREATE INDEX publish_results_event_id ON publish_results (event_id);
+			CREATE INDEX publish_results_created_at ON publish_results (created_at);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for publish_results table: %v", err)
+		} else {
+			log.Println("[INFO] publish_results table migration completed")
+		}
+	}
+
+	// Check if outbox table exists
+	var outboxExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'outbox'
+	`).Scan(&outboxExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for outbox table: %v", err)
+		return
+	}
+
+	if !outboxExists {
+		log.Println("[INFO] Migrating database: adding outbox table")
+		_, err := db.Exec(`
+			CREATE TABLE outbox (
+				event_id VARCHAR(64) NOT NULL,
+				relay TEXT NOT NULL,
+				event TEXT NOT NULL,
+				attempts INTEGER DEFAULT 0,
+				next_attempt_at DATETIME,
+				last_error TEXT DEFAULT '',
+				created_at DATETIME,
+				PRIMARY KEY (event_id, relay)
+			);
+			CREATE INDEX outbox_next_attempt_at ON outbox (next_attempt_at);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for outbox table: %v", err)
+		} else {
+			log.Println("[INFO] outbox table migration completed")
+		}
+	}
+
+	// Check if the change tracking columns exist
+	var trackChangesExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('published_items')
+		WHERE name = 'teaser_event_id'
+	`).Scan(&trackChangesExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for teaser_event_id column: %v", err)
+		return
+	}
+
+	if !trackChangesExists {
+		log.Println("[INFO] Migrating database: adding item change tracking columns")
+		_, err := db.Exec(`
+			ALTER TABLE published_items ADD COLUMN teaser_event_id VARCHAR(64) DEFAULT '';
+			ALTER TABLE published_items ADD COLUMN event_kind INTEGER DEFAULT 1;
+			ALTER TABLE published_items ADD COLUMN item_time DATETIME;
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for change tracking columns: %v", err)
+		} else {
+			log.Println("[INFO] Change tracking columns migration completed")
+		}
+	}
+
+	// Check if feed_settings table exists
+	var settingsExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'feed_settings'
+	`).Scan(&settingsExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for feed_settings table: %v", err)
+		return
+	}
+
+	if !settingsExists {
+		log.Println("[INFO] Migrating database: adding feed_settings table")
+		_, err := db.Exec(`
+			CREATE TABLE feed_settings (
+				feed_pub VARCHAR(64) PRIMARY KEY,
+				publish_mode TEXT DEFAULT '',
+				track_changes INTEGER DEFAULT 0,
+				fetch_interval TEXT DEFAULT '',
+				relays TEXT DEFAULT '',
+				template TEXT DEFAULT '',
+				max_items INTEGER DEFAULT 0,
+				history_interval TEXT DEFAULT ''
+			);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed_settings table: %v", err)
+		} else {
+			log.Println("[INFO] feed_settings table migration completed")
+		}
+	}
+
+	// publish_mode and track_changes were columns of feeds before there
+	// were feed settings
+	var feedSettingsColumns int
+	err = db.QueryRow(`
+		SELECT COUNT(*)
+		FROM pragma_table_info('feeds')
+		WHERE name IN ('publish_mode', 'track_changes')
+	`).Scan(&feedSettingsColumns)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for publish_mode column: %v", err)
+		return
+	}
+
+	if feedSettingsColumns == 2 {
+		log.Println("[INFO] Migrating database: moving publish mode and change tracking to feed_settings")
+		// an existing feed_settings table already has them
+		move := ""
+		if !settingsExists {
+			move = `INSERT OR REPLACE INTO feed_settings (feed_pub, publish_mode, track_changes)
+				SELECT pub, COALESCE(publish_mode, ''), COALESCE(track_changes, 0) FROM feeds;`
+		}
+		_, err := db.Exec(move + `
+			ALTER TABLE feeds DROP COLUMN publish_mode;
+			ALTER TABLE feeds DROP COLUMN track_changes;
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed_settings columns: %v", err)
+		} else {
+			log.Println("[INFO] feed_settings columns migration completed")
+		}
+	}
+
+	// Check if next_fetch_at column exists
+	var scheduleExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('feeds')
+		WHERE name = 'next_fetch_at'
+	`).Scan(&scheduleExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for next_fetch_at column: %v", err)
+		return
+	}
+
+	if !scheduleExists {
+		log.Println("[INFO] Migrating database: adding feed schedule columns")
+		_, err := db.Exec(`
+			ALTER TABLE feeds ADD COLUMN next_fetch_at DATETIME;
+			ALTER TABLE feeds ADD COLUMN poll_interval INTEGER DEFAULT 0;
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed schedule columns: %v", err)
+		} else {
+			log.Println("[INFO] Feed schedule columns migration completed")
+		}
+	}
+
+	// Check if backoff_count column exists
+	var hintsExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('feeds')
+		WHERE name = 'backoff_count'
+	`).Scan(&hintsExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for backoff_count column: %v", err)
+		return
+	}
+
+	if !hintsExists {
+		log.Println("[INFO] Migrating database: adding feed polling hint columns")
+		_, err := db.Exec(`
+			ALTER TABLE feeds ADD COLUMN backoff_count INTEGER DEFAULT 0;
+			ALTER TABLE feeds ADD COLUMN feed_ttl INTEGER DEFAULT 0;
+			ALTER TABLE feeds ADD COLUMN skip_hours TEXT DEFAULT '';
+			ALTER TABLE feeds ADD COLUMN skip_days TEXT DEFAULT '';
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for polling hint columns: %v", err)
+		} else {
+			log.Println("[INFO] Polling hint columns migration completed")
+		}
+	}
+
+	// Check if websub_subscriptions table exists
+	var websubExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'websub_subscriptions'
+	`).Scan(&websubExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for websub_subscriptions table: %v", err)
+		return
+	}
+
+	if !websubExists {
+		log.Println("[INFO] Migrating database: adding websub_subscriptions table")
+		_, err := db.Exec(`
+			CREATE TABLE websub_subscriptions (
+				feed_pub VARCHAR(64) PRIMARY KEY,
+				hub TEXT NOT NULL,
+				topic TEXT NOT NULL,
+				secret TEXT DEFAULT '',
+				state TEXT DEFAULT '',
+				lease_seconds INTEGER DEFAULT 0,
+				lease_expires DATETIME,
+				requested_at DATETIME
+			);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for websub_subscriptions table: %v", err)
+		} else {
+			log.Println("[INFO] websub_subscriptions table migration completed")
+		}
+	}
+
+	// Check if title column exists
+	var titleExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('feeds')
+		WHERE name = 'title'
+	`).Scan(&titleExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for title column: %v", err)
+		return
+	}
+
+	if !titleExists {
+		log.Println("[INFO] Migrating database: adding feed title columns")
+		_, err := db.Exec(`
+			ALTER TABLE feeds ADD COLUMN title TEXT DEFAULT '';
+			ALTER TABLE feeds ADD COLUMN site_link TEXT DEFAULT '';
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed title columns: %v", err)
+		} else {
+			log.Println("[INFO] Feed title columns migration completed")
+		}
+	}
+
+	// Check if article_cache table exists
+	var articleCacheExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'article_cache'
+	`).Scan(&articleCacheExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for article_cache table: %v", err)
+		return
+	}
+
+	if !articleCacheExists {
+		log.Println("[INFO] Migrating database: adding full text extraction")
+		_, err := db.Exec(`
+			ALTER TABLE feed_settings ADD COLUMN full_text INTEGER DEFAULT 0;
+			CREATE TABLE article_cache (
+				url TEXT PRIMARY KEY,
+				content TEXT DEFAULT '',
+				fetched_at DATETIME
+			);
+			CREATE INDEX article_cache_fetched_at ON article_cache (fetched_at);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for full text extraction: %v", err)
+		} else {
+			log.Println("[INFO] Full text extraction migration completed")
+		}
+	}
+
+	// Check if feed_filters table exists
+	var feedFiltersExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'feed_filters'
+	`).Scan(&feedFiltersExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for feed_filters table: %v", err)
+		return
+	}
+
+	if !feedFiltersExists {
+		log.Println("[INFO] Migrating database: adding feed_filters table")
+		_, err := db.Exec(`
+			CREATE TABLE feed_filters (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				feed_pub VARCHAR(64) NOT NULL,
+				rule TEXT NOT NULL
+			);
+			CREATE INDEX feed_filters_feed_pub ON feed_filters (feed_pub);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create feed_filters table: %v", err)
+		} else {
+			log.Println("[INFO] Feed filters migration completed")
+		}
+	}
+
+	// Check if blossom_blobs table exists
+	var blossomBlobsExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'blossom_blobs'
+	`).Scan(&blossomBlobsExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for blossom_blobs table: %v", err)
+		return
+	}
+
+	if !blossomBlobsExists {
+		log.Println("[INFO] Migrating database: adding blossom_blobs table")
+		_, err := db.Exec(`
+			CREATE TABLE blossom_blobs (
+				source_url TEXT PRIMARY KEY,
+				blossom_url TEXT NOT NULL,
+				sha256 TEXT NOT NULL,
+				mime_type TEXT DEFAULT '',
+				size INTEGER DEFAULT 0,
+				uploaded_at DATETIME
+			);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create blossom_blobs table: %v", err)
+		} else {
+			log.Println("[INFO] Blossom blobs migration completed")
+		}
+	}
+
+	// Check if scrape_sources table exists
+	var scrapeSourcesExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'scrape_sources'
+	`).Scan(&scrapeSourcesExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for scrape_sources table: %v", err)
+		return
+	}
+
+	if !scrapeSourcesExists {
+		log.Println("[INFO] Migrating database: adding scrape_sources table")
+		_, err := db.Exec(`
+			CREATE TABLE scrape_sources (
+				feed_pub VARCHAR(64) PRIMARY KEY,
+				item_selector TEXT NOT NULL,
+				title_selector TEXT DEFAULT '',
+				link_selector TEXT DEFAULT '',
+				date_selector TEXT DEFAULT '',
+				date_format TEXT DEFAULT '',
+				body_selector TEXT DEFAULT ''
+			);
+			CREATE TABLE scraped_items (
+				feed_pub VARCHAR(64) NOT NULL,
+				item_key TEXT NOT NULL,
+				first_seen DATETIME,
+				PRIMARY KEY (feed_pub, item_key)
+			);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create scrape_sources table: %v", err)
+		} else {
+			log.Println("[INFO] Scrape sources migration completed")
+		}
+	}
+
+	// Check if identities table exists
+	var identitiesExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'identities'
+	`).Scan(&identitiesExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for identities table: %v", err)
+		return
+	}
+
+	if !identitiesExists {
+		// every feed starts out as the only source of its own identity
+		log.Println("[INFO] Migrating database: adding identities table")
+		_, err := db.Exec(`
+			CREATE TABLE identities (
+				pub VARCHAR(64) PRIMARY KEY,
+				sec VARCHAR(64) NOT NULL,
+				name TEXT DEFAULT '',
+				about TEXT DEFAULT '',
+				picture TEXT DEFAULT '',
+				nip05_name TEXT DEFAULT ''
+			);
+			INSERT INTO identities (pub, sec) SELECT pub, sec FROM feeds;
+			ALTER TABLE feeds ADD COLUMN identity_pub VARCHAR(64) DEFAULT '';
+			UPDATE feeds SET identity_pub = pub;
+			ALTER TABLE published_items ADD COLUMN source_pub VARCHAR(64) DEFAULT '';
+			ALTER TABLE published_items ADD COLUMN link TEXT DEFAULT '';
+			UPDATE published_items SET source_pub = feed_pub;
+			CREATE INDEX published_items_link ON published_items (feed_pub, link);
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create identities table: %v", err)
+		} else {
+			log.Println("[INFO] Identities migration completed")
+		}
+	}
+
+	// Check if nip05_aliases table exists
+	var aliasesExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM sqlite_master
+		WHERE type = 'table' AND name = 'nip05_aliases'
+	`).Scan(&aliasesExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for nip05_aliases table: %v", err)
+		return
+	}
+
+	if !aliasesExists {
+		log.Println("[INFO] Migrating database: adding nip05_aliases table")
+		_, err := db.Exec(`
+			CREATE TABLE nip05_aliases (
+				name TEXT PRIMARY KEY,
+				feed_pub VARCHAR(64) NOT NULL
+			)
+		`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to create nip05_aliases table: %v", err)
+		} else {
+			log.Println("[INFO] NIP-05 aliases migration completed")
+		}
+	}
+
+	// Check if self_link column exists
+	var selfLinkExists bool
+	err = db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('feeds')
+		WHERE name = 'self_link'
+	`).Scan(&selfLinkExists)
+	if err != nil {
+		log.Printf("[WARN] Failed to check for self_link column: %v", err)
+		return
+	}
+
+	if !selfLinkExists {
+		log.Println("[INFO] Migrating database: adding feed self_link column")
+		_, err := db.Exec(`ALTER TABLE feeds ADD COLUMN self_link TEXT DEFAULT ''`)
+		if err != nil {
+			log.Printf("[ERROR] Failed to migrate database for feed self_link column: %v", err)
+		} else {
+			log.Println("[INFO] Feed self_link column migration completed")
+		}
+	}
 }
 
 // feedURLToNip05Name converts a feed URL into a NIP-05-compliant local-part.
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestAtomstr returns an Atomstr with a fresh, migrated database. Relays
+// in tests are unreachable, publishing fails but is recorded.
+func newTestAtomstr(t *testing.T) *Atomstr {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "atomstr.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(sqlInit); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	migrateDB(db)
+	return &Atomstr{db: db, pool: newRelayPool(func(string, string) {})}
+}
+
+// addTestFeed stores a new feed and returns it as loaded from the database.
+func addTestFeed(t *testing.T, a *Atomstr, feedURL string) *feedStruct {
+	t.Helper()
+	feedItem := generateKeysForURL(feedURL)
+	feedItem.State = "active"
+	if err := a.dbWriteFeed(feedItem); err != nil {
+		t.Fatalf("add feed: %v", err)
+	}
+	return testFeed(t, a, feedURL)
+}
+
+// testFeed loads a feed and points it to an unreachable relay.
+func testFeed(t *testing.T, a *Atomstr, feedURL string) *feedStruct {
+	t.Helper()
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		t.Fatalf("feed %s not found", feedURL)
+	}
+	feedItem.Settings.Relays = []string{"ws://127.0.0.1:1"}
+	return feedItem
+}
+
+func TestCatchUpInterval(t *testing.T) {
+	defer func(backlog time.Duration) { maxBacklog = backlog }(maxBacklog)
+	maxBacklog = 24 * time.Hour
+
+	ago := func(d time.Duration) *time.Time {
+		at := time.Now().Add(-d)
+		return &at
+	}
+	tests := []struct {
+		name         string
+		lastItemAt   *time.Time
+		feedInterval time.Duration
+		want         time.Duration
+	}{
+		{"no items yet", nil, time.Hour, time.Hour},
+		{"recent item", ago(10 * time.Minute), time.Hour, time.Hour},
+		{"outage", ago(5 * time.Hour), time.Hour, 5 * time.Hour},
+		{"capped by backlog", ago(72 * time.Hour), time.Hour, 24 * time.Hour},
+		{"capped by feed interval", ago(72 * time.Hour), 48 * time.Hour, 48 * time.Hour},
+		{"long feed interval", ago(time.Hour), 48 * time.Hour, 48 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := catchUpInterval(tt.lastItemAt, tt.feedInterval)
+			if got.Round(time.Minute) != tt.want {
+				t.Errorf("catchUpInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateFeedSettingsColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "atomstr.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	// a database from before feed_settings, with the settings in feeds
+	if _, err := db.Exec(sqlInit + `
+		ALTER TABLE feeds ADD COLUMN publish_mode TEXT DEFAULT 'note';
+		ALTER TABLE feeds ADD COLUMN track_changes INTEGER DEFAULT 0;
+		INSERT INTO feeds (pub, sec, url, publish_mode, track_changes) VALUES ('pub', 'sec', 'https://example.org/feed', 'article', 1);
+	`); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	migrateDB(db)
+	migrateDB(db)
+
+	var columns int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('feeds') WHERE name IN ('publish_mode', 'track_changes')`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 {
+		t.Errorf("feeds still has %d settings columns", columns)
+	}
+	a := &Atomstr{db: db}
+	settings, err := a.dbGetFeedSettings("pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.PublishMode != "article" || !settings.TrackChanges {
+		t.Errorf("settings weren't moved: %+v", settings)
+	}
+}
+
+func TestMigratePublishedItems(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "atomstr.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	// published_items as first added, before items had a kind, time and source
+	if _, err := db.Exec(sqlInit + `
+		CREATE TABLE published_items (
+			feed_pub VARCHAR(64) NOT NULL,
+			item_key TEXT NOT NULL,
+			event_id VARCHAR(64),
+			content_hash VARCHAR(64),
+			published_at DATETIME,
+			PRIMARY KEY (feed_pub, item_key)
+		);
+		INSERT INTO feeds (pub, sec, url) VALUES ('pub', 'sec', 'https://example.org/feed');
+		INSERT INTO published_items (feed_pub, item_key, event_id, content_hash, published_at) VALUES ('pub', 'item', 'event', 'hash', '2026-10-15 08:00:00');
+	`); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	migrateDB(db)
+	migrateDB(db)
+
+	a := &Atomstr{db: db}
+	item := a.dbGetPublishedItem("pub", "item")
+	if item == nil {
+		t.Fatalf("published item was lost")
+	}
+	if item.EventID != "event" || item.Kind != 1 || item.SourcePub != "pub" || item.ItemTime != nil {
+		t.Errorf("migrated item is %+v", item)
+	}
+}
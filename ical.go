@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // TZIDs must resolve in containers without zoneinfo
+)
+
+// maxRecurrencePeriods limits the expansion of recurring events.
+const maxRecurrencePeriods = 10000
+
+// calendar is a parsed iCalendar (RFC 5545) document.
+type calendar struct {
+	Name        string
+	Description string
+	Events      []calendarEvent
+}
+
+// calendarEvent is a VEVENT. Recurring events are expanded into one
+// calendarEvent per occurrence by occurrences.
+type calendarEvent struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	URL          string
+	Categories   []string
+	Status       string
+	Start        time.Time
+	End          time.Time
+	Duration     time.Duration // DURATION, applied by finish if there is no DTEND
+	AllDay       bool
+	RRule        string
+	RDates       []time.Time
+	ExDates      []time.Time
+	RecurrenceID *time.Time
+}
+
+// icalProperty is a content line: NAME;PARAM=VALUE:value
+type icalProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// isCalendar reports whether a document is iCalendar instead of a feed.
+func isCalendar(body []byte) bool {
+	body = bytes.TrimPrefix(bytes.TrimSpace(body), []byte("\xef\xbb\xbf"))
+	return len(body) >= 15 && strings.EqualFold(string(body[:15]), "BEGIN:VCALENDAR")
+}
+
+// parseCalendar parses the VEVENTs of an iCalendar document.
+func parseCalendar(body []byte) (*calendar, error) {
+	cal := &calendar{}
+	// floating times are in the time zone of the calendar, or the local one
+	floating := time.Local
+	var event *calendarEvent
+	var depth []string
+	for _, line := range unfoldICalLines(string(body)) {
+		prop, ok := parseICalLine(line)
+		if !ok {
+			continue
+		}
+		switch prop.Name {
+		case "BEGIN":
+			depth = append(depth, strings.ToUpper(prop.Value))
+			if strings.EqualFold(prop.Value, "VEVENT") {
+				event = &calendarEvent{}
+			}
+			continue
+		case "END":
+			if len(depth) > 0 {
+				depth = depth[:len(depth)-1]
+			}
+			if strings.EqualFold(prop.Value, "VEVENT") && event != nil {
+				if err := event.finish(); err != nil {
+					log.Printf("[DEBUG] Skipping calendar event %q: %v", event.UID, err)
+				} else {
+					cal.Events = append(cal.Events, *event)
+				}
+				event = nil
+			}
+			continue
+		}
+
+		if event == nil {
+			if len(depth) == 1 && depth[0] == "VCALENDAR" {
+				switch prop.Name {
+				case "X-WR-CALNAME", "NAME":
+					cal.Name = unescapeICalText(prop.Value)
+				case "X-WR-CALDESC", "DESCRIPTION":
+					cal.Description = unescapeICalText(prop.Value)
+				case "X-WR-TIMEZONE":
+					floating = loadICalLocation(strings.TrimSpace(prop.Value))
+				}
+			}
+			continue
+		}
+		if depth[len(depth)-1] != "VEVENT" {
+			continue // VALARM etc.
+		}
+		if err := event.set(prop, floating); err != nil {
+			log.Printf("[DEBUG] Invalid calendar property %s: %v", prop.Name, err)
+		}
+	}
+	if len(depth) > 0 || (cal.Events == nil && !strings.Contains(strings.ToUpper(string(body)), "END:VCALENDAR")) {
+		return nil, fmt.Errorf("incomplete calendar")
+	}
+	return cal, nil
+}
+
+func (e *calendarEvent) set(prop icalProperty, floating *time.Location) error {
+	var err error
+	switch prop.Name {
+	case "UID":
+		e.UID = strings.TrimSpace(prop.Value)
+	case "SUMMARY":
+		e.Summary = unescapeICalText(prop.Value)
+	case "DESCRIPTION":
+		e.Description = unescapeICalText(prop.Value)
+	case "LOCATION":
+		e.Location = unescapeICalText(prop.Value)
+	case "URL":
+		e.URL = strings.TrimSpace(prop.Value)
+	case "CATEGORIES":
+		for _, category := range splitICalList(prop.Value) {
+			if category = unescapeICalText(category); category != "" {
+				e.Categories = append(e.Categories, category)
+			}
+		}
+	case "STATUS":
+		e.Status = strings.ToUpper(strings.TrimSpace(prop.Value))
+	case "DTSTART":
+		e.Start, e.AllDay, err = parseICalTime(prop, floating)
+	case "DTEND":
+		e.End, _, err = parseICalTime(prop, floating)
+	case "DURATION":
+		e.Duration, err = parseICalDuration(prop.Value)
+	case "RRULE":
+		e.RRule = strings.TrimSpace(prop.Value)
+	case "RDATE", "EXDATE":
+		for _, value := range strings.Split(prop.Value, ",") {
+			t, _, err := parseICalTime(icalProperty{Params: prop.Params, Value: value}, floating)
+			if err != nil {
+				return err
+			}
+			if prop.Name == "RDATE" {
+				e.RDates = append(e.RDates, t)
+			} else {
+				e.ExDates = append(e.ExDates, t)
+			}
+		}
+	case "RECURRENCE-ID":
+		var t time.Time
+		if t, _, err = parseICalTime(prop, floating); err == nil {
+			e.RecurrenceID = &t
+		}
+	}
+	return err
+}
+
+// finish validates an event and fills in the end from DURATION or the
+// default. Properties come in any order, so this is done after the VEVENT.
+func (e *calendarEvent) finish() error {
+	if e.UID == "" {
+		return fmt.Errorf("missing UID")
+	}
+	if e.Start.IsZero() {
+		return fmt.Errorf("missing DTSTART")
+	}
+	if e.End.IsZero() && e.Duration > 0 {
+		e.End = e.Start.Add(e.Duration)
+	}
+	if e.End.IsZero() || e.End.Before(e.Start) {
+		if e.AllDay {
+			e.End = e.Start.AddDate(0, 0, 1)
+		} else {
+			e.End = e.Start
+		}
+	}
+	return nil
+}
+
+// key identifies an occurrence of an event.
+func (e calendarEvent) key() string {
+	if e.RRule == "" && len(e.RDates) == 0 && e.RecurrenceID == nil {
+		return e.UID
+	}
+	start := e.Start
+	if e.RecurrenceID != nil {
+		start = *e.RecurrenceID
+	}
+	return e.UID + "/" + start.UTC().Format("20060102T150405Z")
+}
+
+// occurrences expands recurring events and returns all occurrences that
+// end after from and start before to. Modified instances (RECURRENCE-ID)
+// replace the generated ones.
+func (c *calendar) occurrences(from, to time.Time) []calendarEvent {
+	overrides := make(map[string]calendarEvent)
+	for _, event := range c.Events {
+		if event.RecurrenceID != nil {
+			overrides[event.key()] = event
+		}
+	}
+
+	var result []calendarEvent
+	for _, event := range c.Events {
+		if event.RecurrenceID != nil {
+			continue
+		}
+		for _, occurrence := range event.expand(from, to) {
+			if override, ok := overrides[occurrence.key()]; ok {
+				occurrence = override
+			}
+			if occurrence.End.After(from) && occurrence.Start.Before(to) {
+				result = append(result, occurrence)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result
+}
+
+// expand returns the occurrences of an event starting before until. Those
+// of a rule that end before from may be left out. Events with a rule that
+// can't be expanded are skipped.
+func (e calendarEvent) expand(from, until time.Time) []calendarEvent {
+	if e.RRule == "" && len(e.RDates) == 0 {
+		return []calendarEvent{e}
+	}
+	duration := e.End.Sub(e.Start)
+	starts := append([]time.Time{}, e.RDates...)
+	if e.RRule != "" {
+		rule, err := parseRRule(e.RRule, e.Start.Location())
+		if err != nil {
+			log.Printf("[WARN] Skipping recurring calendar event %q: %v", e.UID, err)
+			return nil
+		}
+		starts = append(starts, rule.starts(e.Start, from.Add(-duration), until)...)
+	} else {
+		starts = append(starts, e.Start)
+	}
+
+	var result []calendarEvent
+	seen := make(map[int64]bool)
+	for _, start := range starts {
+		if seen[start.Unix()] || isExcluded(start, e.ExDates) {
+			continue
+		}
+		seen[start.Unix()] = true
+		occurrence := e
+		occurrence.Start = start
+		occurrence.End = start.Add(duration)
+		if e.AllDay {
+			// keep the number of days across DST changes
+			occurrence.End = start.AddDate(0, 0, int((duration+time.Hour)/(24*time.Hour)))
+		}
+		occurrence.RecurrenceID = &start
+		result = append(result, occurrence)
+	}
+	return result
+}
+
+func isExcluded(start time.Time, exDates []time.Time) bool {
+	for _, ex := range exDates {
+		if ex.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrenceRule is a parsed RRULE. BYDAY, BYMONTHDAY, BYMONTH and WKST
+// are supported, which covers what calendar applications produce.
+type recurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []weekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+// weekdayNum is a BYDAY entry like MO or -1FR (last friday).
+type weekdayNum struct {
+	Day time.Weekday
+	N   int
+}
+
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRRule(value string, loc *time.Location) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		name, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			if n, err := strconv.Atoi(val); err == nil && n > 0 {
+				rule.Interval = n
+			}
+		case "COUNT":
+			rule.Count, _ = strconv.Atoi(val)
+		case "UNTIL":
+			until, _, err := parseICalTime(icalProperty{Value: val}, loc)
+			if err != nil {
+				return rule, err
+			}
+			if len(val) == 8 {
+				// a date includes the whole day
+				until = time.Date(until.Year(), until.Month(), until.Day(), 23, 59, 59, 0, loc)
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				day = strings.ToUpper(strings.TrimSpace(day))
+				if len(day) < 2 {
+					continue
+				}
+				weekday, ok := icalWeekdays[day[len(day)-2:]]
+				if !ok {
+					return rule, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				n, _ := strconv.Atoi(day[:len(day)-2])
+				rule.ByDay = append(rule.ByDay, weekdayNum{Day: weekday, N: n})
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				if n, err := strconv.Atoi(day); err == nil && n != 0 {
+					rule.ByMonthDay = append(rule.ByMonthDay, n)
+				}
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(val, ",") {
+				if n, err := strconv.Atoi(month); err == nil && n >= 1 && n <= 12 {
+					rule.ByMonth = append(rule.ByMonth, time.Month(n))
+				}
+			}
+		case "WKST":
+			weekday, ok := icalWeekdays[strings.ToUpper(val)]
+			if !ok {
+				return rule, fmt.Errorf("invalid WKST %q", val)
+			}
+			rule.WeekStart = weekday
+		case "BYSETPOS", "BYWEEKNO", "BYYEARDAY", "BYHOUR", "BYMINUTE", "BYSECOND":
+			// these would be expanded wrongly, better no events than wrong ones
+			return rule, fmt.Errorf("unsupported %s", strings.ToUpper(name))
+		}
+	}
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return rule, nil
+	}
+	return rule, fmt.Errorf("unsupported FREQ %q", rule.Freq)
+}
+
+// starts returns the start times of the occurrences of the rule, beginning
+// with dtstart, until the end of the rule or until. Without COUNT, periods
+// before from are skipped, so long running series still reach the present.
+func (r recurrenceRule) starts(dtstart, from, until time.Time) []time.Time {
+	var result []time.Time
+	count := 0
+	first := r.firstPeriod(dtstart, from)
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		candidates := r.periodStarts(dtstart, period)
+		if len(candidates) > 0 && candidates[0].After(until) {
+			break
+		}
+		for _, start := range candidates {
+			if start.Before(dtstart) {
+				continue
+			}
+			if (!r.Until.IsZero() && start.After(r.Until)) || (r.Count > 0 && count >= r.Count) {
+				return result
+			}
+			count++
+			if !start.After(until) {
+				result = append(result, start)
+			}
+		}
+	}
+	return result
+}
+
+// firstPeriod returns the number of the period before the one containing
+// from. Occurrences have to be counted from dtstart if the rule has a COUNT.
+func (r recurrenceRule) firstPeriod(dtstart, from time.Time) int {
+	if r.Count > 0 || !from.After(dtstart) {
+		return 0
+	}
+	var n int
+	switch r.Freq {
+	case "DAILY":
+		n = int(from.Sub(dtstart)/(24*time.Hour)) / r.Interval
+	case "WEEKLY":
+		n = int(from.Sub(dtstart)/(7*24*time.Hour)) / r.Interval
+	case "MONTHLY":
+		n = ((from.Year()-dtstart.Year())*12 + int(from.Month()-dtstart.Month())) / r.Interval
+	case "YEARLY":
+		n = (from.Year() - dtstart.Year()) / r.Interval
+	}
+	// the length of days and months varies
+	return max(n-1, 0)
+}
+
+// periodStarts returns the sorted candidate starts in the n-th period
+// (day, week, month or year) of the rule.
+func (r recurrenceRule) periodStarts(dtstart time.Time, n int) []time.Time {
+	loc := dtstart.Location()
+	hour, minute, second := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, loc)
+	}
+	var candidates []time.Time
+	switch r.Freq {
+	case "DAILY":
+		candidates = []time.Time{dtstart.AddDate(0, 0, n*r.Interval)}
+	case "WEEKLY":
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := dtstart.AddDate(0, 0, n*7*r.Interval-offset)
+		if len(r.ByDay) == 0 {
+			candidates = []time.Time{weekStart.AddDate(0, 0, offset)}
+		}
+		for _, day := range r.ByDay {
+			candidates = append(candidates, weekStart.AddDate(0, 0, (int(day.Day)-int(r.WeekStart)+7)%7))
+		}
+	case "MONTHLY":
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(n*r.Interval), 1, 0, 0, 0, 0, loc)
+		candidates = r.monthStarts(first.Year(), first.Month(), dtstart.Day(), at)
+	case "YEARLY":
+		year := dtstart.Year() + n*r.Interval
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range months {
+			candidates = append(candidates, r.monthStarts(year, month, dtstart.Day(), at)...)
+		}
+	}
+
+	if len(r.ByMonth) > 0 && r.Freq != "YEARLY" {
+		var filtered []time.Time
+		for _, start := range candidates {
+			for _, month := range r.ByMonth {
+				if start.Month() == month {
+					filtered = append(filtered, start)
+				}
+			}
+		}
+		candidates = filtered
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	return candidates
+}
+
+// monthStarts returns the starts in a month according to BYDAY and
+// BYMONTHDAY, or on day if there are none.
+func (r recurrenceRule) monthStarts(year int, month time.Month, day int, at func(int, time.Month, int) time.Time) []time.Time {
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []int
+	for _, d := range r.ByMonthDay {
+		if d < 0 {
+			d = daysInMonth + d + 1
+		}
+		days = append(days, d)
+	}
+	for _, weekday := range r.ByDay {
+		firstWeekday := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+		first := 1 + (int(weekday.Day)-int(firstWeekday)+7)%7
+		switch {
+		case weekday.N > 0:
+			days = append(days, first+(weekday.N-1)*7)
+		case weekday.N < 0:
+			last := first + (daysInMonth-first)/7*7
+			days = append(days, last+(weekday.N+1)*7)
+		default:
+			for d := first; d <= daysInMonth; d += 7 {
+				days = append(days, d)
+			}
+		}
+	}
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		days = []int{day}
+	}
+
+	var starts []time.Time
+	for _, d := range days {
+		if d >= 1 && d <= daysInMonth { // e.g. no 31st in april
+			starts = append(starts, at(year, month, d))
+		}
+	}
+	return starts
+}
+
+// unfoldICalLines joins folded lines (continuations start with a space or
+// tab).
+func unfoldICalLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+	}
+	return lines
+}
+
+// parseICalLine splits a content line into name, parameters and value.
+// Parameter values may be quoted and contain ":" and ";".
+func parseICalLine(line string) (icalProperty, bool) {
+	prop := icalProperty{Params: make(map[string]string)}
+	inQuotes := false
+	nameEnd := -1
+	for i, c := range line {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ';' && !inQuotes && nameEnd < 0:
+			nameEnd = i
+		case c == ':' && !inQuotes:
+			head := line[:i]
+			prop.Value = line[i+1:]
+			if nameEnd < 0 {
+				prop.Name = strings.ToUpper(head)
+				return prop, prop.Name != ""
+			}
+			prop.Name = strings.ToUpper(head[:nameEnd])
+			for _, param := range splitICalParams(head[nameEnd+1:]) {
+				name, value, _ := strings.Cut(param, "=")
+				prop.Params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+			}
+			return prop, prop.Name != ""
+		}
+	}
+	return prop, false
+}
+
+func splitICalParams(s string) []string {
+	var params []string
+	inQuotes := false
+	start := 0
+	for i, c := range s {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ';' && !inQuotes {
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+// splitICalList splits a comma separated value, commas can be escaped.
+func splitICalList(value string) []string {
+	var list []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+		} else if value[i] == ',' {
+			list = append(list, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, value[start:])
+}
+
+func unescapeICalText(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n', 'N':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(value[i])
+			}
+			continue
+		}
+		sb.WriteByte(value[i])
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// parseICalTime parses a DATE or DATE-TIME value. Times with TZID are in
+// that zone, times ending in Z are UTC and floating times are in the zone
+// floating. Dates are midnight UTC.
+func parseICalTime(prop icalProperty, floating *time.Location) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.Value)
+	if prop.Params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := floating
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		loc = loadICalLocation(tzid)
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// loadICalLocation resolves a TZID. Some calendars prefix IANA names, like
+// "/mozilla.org/20050126_1/Europe/Berlin".
+func loadICalLocation(tzid string) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	parts := strings.Split(strings.Trim(tzid, "/"), "/")
+	for i := range parts {
+		if loc, err := time.LoadLocation(strings.Join(parts[i:], "/")); err == nil {
+			return loc
+		}
+	}
+	log.Printf("[DEBUG] Unknown time zone %q, using UTC", tzid)
+	return time.UTC
+}
+
+// parseICalDuration parses durations like P1D, PT1H30M or P2W.
+func parseICalDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+	}
+	value = strings.TrimLeft(value, "+-")
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var d time.Duration
+	number := ""
+	for _, c := range value[1:] {
+		if c >= '0' && c <= '9' {
+			number += string(c)
+			continue
+		}
+		if c == 'T' {
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+		switch c {
+		case 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			d += time.Duration(n) * time.Hour
+		case 'M':
+			d += time.Duration(n) * time.Minute
+		case 'S':
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	return sign * d, nil
+}
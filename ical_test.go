@@ -0,0 +1,198 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCalendar wraps VEVENT lines in a calendar and parses it.
+func testCalendar(t *testing.T, header string, events ...string) *calendar {
+	t.Helper()
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + header
+	for _, event := range events {
+		body += "BEGIN:VEVENT\r\n" + strings.ReplaceAll(strings.TrimSpace(event), "\n", "\r\n") + "\r\nEND:VEVENT\r\n"
+	}
+	cal, err := parseCalendar([]byte(body + "END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("parse calendar: %v", err)
+	}
+	return cal
+}
+
+func occurrenceDates(events []calendarEvent) []string {
+	var dates []string
+	for _, event := range events {
+		dates = append(dates, event.Start.UTC().Format("2006-01-02 15:04"))
+	}
+	return dates
+}
+
+func TestCalendarOccurrences(t *testing.T) {
+	utc := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		event    string
+		from, to time.Time
+		want     []string
+	}{
+		{
+			"daily",
+			"UID:daily\nDTSTART:20261001T090000Z\nDTEND:20261001T100000Z\nRRULE:FREQ=DAILY",
+			utc(2026, 10, 3), utc(2026, 10, 6),
+			[]string{"2026-10-03 09:00", "2026-10-04 09:00", "2026-10-05 09:00"},
+		},
+		{
+			"old daily series",
+			"UID:old\nDTSTART:19800101T090000Z\nRRULE:FREQ=DAILY",
+			utc(2026, 10, 3), utc(2026, 10, 5),
+			[]string{"2026-10-03 09:00", "2026-10-04 09:00"},
+		},
+		{
+			"every other day",
+			"UID:interval\nDTSTART:20200101T090000Z\nRRULE:FREQ=DAILY;INTERVAL=2",
+			utc(2026, 10, 3), utc(2026, 10, 8),
+			[]string{"2026-10-04 09:00", "2026-10-06 09:00"},
+		},
+		{
+			"weekly by day",
+			"UID:weekly\nDTSTART:20261005T180000Z\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE",
+			utc(2026, 10, 5), utc(2026, 10, 15),
+			[]string{"2026-10-05 18:00", "2026-10-07 18:00", "2026-10-12 18:00", "2026-10-14 18:00"},
+		},
+		{
+			"biweekly with week start",
+			"UID:wkst\nDTSTART:20261004T180000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;WKST=SU;BYDAY=SU,MO",
+			utc(2026, 10, 1), utc(2026, 10, 25),
+			[]string{"2026-10-04 18:00", "2026-10-05 18:00", "2026-10-18 18:00", "2026-10-19 18:00"},
+		},
+		{
+			"monthly second tuesday",
+			"UID:second\nDTSTART:20260113T190000Z\nRRULE:FREQ=MONTHLY;BYDAY=2TU",
+			utc(2026, 10, 1), utc(2026, 12, 1),
+			[]string{"2026-10-13 19:00", "2026-11-10 19:00"},
+		},
+		{
+			"monthly last friday",
+			"UID:last\nDTSTART:20260130T190000Z\nRRULE:FREQ=MONTHLY;BYDAY=-1FR",
+			utc(2026, 10, 1), utc(2026, 12, 1),
+			[]string{"2026-10-30 19:00", "2026-11-27 19:00"},
+		},
+		{
+			"monthly every monday",
+			"UID:mondays\nDTSTART:20261005T070000Z\nRRULE:FREQ=MONTHLY;BYDAY=MO",
+			utc(2026, 10, 1), utc(2026, 11, 1),
+			[]string{"2026-10-05 07:00", "2026-10-12 07:00", "2026-10-19 07:00", "2026-10-26 07:00"},
+		},
+		{
+			"until",
+			"UID:until\nDTSTART:20261001T090000Z\nRRULE:FREQ=DAILY;UNTIL=20261003T090000Z",
+			utc(2026, 10, 1), utc(2026, 11, 1),
+			[]string{"2026-10-01 09:00", "2026-10-02 09:00", "2026-10-03 09:00"},
+		},
+		{
+			"count before the window",
+			"UID:count\nDTSTART:20261001T090000Z\nRRULE:FREQ=DAILY;COUNT=5",
+			utc(2026, 10, 4), utc(2026, 11, 1),
+			[]string{"2026-10-04 09:00", "2026-10-05 09:00"},
+		},
+		{
+			"exdate",
+			"UID:exdate\nDTSTART:20261001T090000Z\nRRULE:FREQ=DAILY;COUNT=3\nEXDATE:20261002T090000Z",
+			utc(2026, 10, 1), utc(2026, 11, 1),
+			[]string{"2026-10-01 09:00", "2026-10-03 09:00"},
+		},
+		{
+			"unsupported rule",
+			"UID:setpos\nDTSTART:20261001T090000Z\nRRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			utc(2026, 10, 1), utc(2026, 11, 1),
+			nil,
+		},
+		{
+			"hourly",
+			"UID:hourly\nDTSTART:20261001T090000Z\nRRULE:FREQ=HOURLY",
+			utc(2026, 10, 1), utc(2026, 11, 1),
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := testCalendar(t, "", tt.event)
+			got := occurrenceDates(cal.occurrences(tt.from, tt.to))
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalendarRecurrenceOverride(t *testing.T) {
+	cal := testCalendar(t, "",
+		"UID:meetup\nSUMMARY:Meetup\nDTSTART:20261001T180000Z\nDTEND:20261001T200000Z\nRRULE:FREQ=WEEKLY;COUNT=3",
+		"UID:meetup\nSUMMARY:Meetup (moved)\nRECURRENCE-ID:20261008T180000Z\nDTSTART:20261009T190000Z\nDTEND:20261009T210000Z",
+	)
+	occurrences := cal.occurrences(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+	got := occurrenceDates(occurrences)
+	want := []string{"2026-10-01 18:00", "2026-10-09 19:00", "2026-10-15 18:00"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("occurrences = %v, want %v", got, want)
+	}
+	if occurrences[1].Summary != "Meetup (moved)" {
+		t.Errorf("override has summary %q", occurrences[1].Summary)
+	}
+	if occurrences[1].key() != "meetup/20261008T180000Z" {
+		t.Errorf("override has key %q, want the one of the replaced occurrence", occurrences[1].key())
+	}
+}
+
+func TestCalendarEventDuration(t *testing.T) {
+	cal := testCalendar(t, "X-WR-TIMEZONE:UTC\r\n",
+		"UID:after\nDTSTART:20261005T100000Z\nDURATION:PT1H30M",
+		"UID:before\nDURATION:PT1H30M\nDTSTART:20261005T100000Z",
+		"UID:end\nDURATION:PT1H30M\nDTSTART:20261005T100000Z\nDTEND:20261005T120000Z",
+		"UID:day\nDURATION:P2D\nDTSTART;VALUE=DATE:20261005",
+	)
+	want := map[string]string{
+		"after":  "2026-10-05 11:30",
+		"before": "2026-10-05 11:30",
+		"end":    "2026-10-05 12:00",
+		"day":    "2026-10-07 00:00",
+	}
+	for _, event := range cal.Events {
+		if got := event.End.UTC().Format("2006-01-02 15:04"); got != want[event.UID] {
+			t.Errorf("%s: end = %s, want %s", event.UID, got, want[event.UID])
+		}
+	}
+	if len(cal.Events) != len(want) {
+		t.Errorf("%d events, want %d", len(cal.Events), len(want))
+	}
+}
+
+func TestParseICalFloatingTime(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Local = newYork
+
+	event := "UID:floating\nDTSTART:20261005T100000"
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"calendar time zone", "X-WR-TIMEZONE:Europe/Berlin\r\n", "2026-10-05 08:00"},
+		{"local time zone", "", "2026-10-05 14:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := testCalendar(t, tt.header, event)
+			if got := cal.Events[0].Start.UTC().Format("2006-01-02 15:04"); got != tt.want {
+				t.Errorf("start = %s UTC, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// reNip05Name matches the local-part of a NIP-05 address.
+var reNip05Name = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// identityProfile holds the profile fields of an identity that replace the
+// ones taken from its primary feed. Empty fields use the feed.
+type identityProfile struct {
+	Name    string
+	About   string
+	Picture string
+	Nip05   string // local-part of the NIP-05 address
+}
+
+// identityProfileKeys are the fields that can be changed with -identity set.
+var identityProfileKeys = []string{"name", "about", "picture", "nip05"}
+
+// set parses and validates value for the profile field key.
+func (p *identityProfile) set(key, value string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "name":
+		p.Name = value
+	case "about":
+		p.About = value
+	case "picture":
+		if value != "" && !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+			return fmt.Errorf("invalid picture URL %q", value)
+		}
+		p.Picture = value
+	case "nip05":
+		value = strings.ToLower(value)
+		if value != "" && !reNip05Name.MatchString(value) {
+			return fmt.Errorf("invalid NIP-05 name %q, use a-z, 0-9, '.', '-' and '_'", value)
+		}
+		p.Nip05 = value
+	default:
+		return fmt.Errorf("unknown profile field %q", key)
+	}
+	return nil
+}
+
+func (p identityProfile) get(key string) string {
+	switch key {
+	case "name":
+		return p.Name
+	case "about":
+		return p.About
+	case "picture":
+		return p.Picture
+	case "nip05":
+		return p.Nip05
+	}
+	return ""
+}
+
+// nip05Name returns the NIP-05 name of the identity of the feed.
+func (f feedStruct) nip05Name() string {
+	if f.Profile.Nip05 != "" {
+		return f.Profile.Nip05
+	}
+	return feedURLToNip05Name(f.URL)
+}
+
+// dbGetIdentitySources returns the feeds that publish as the identity
+// feedPub, the primary one first.
+func (a *Atomstr) dbGetIdentitySources(feedPub string) ([]feedStruct, error) {
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		return nil, err
+	}
+	var sources []feedStruct
+	for _, feedItem := range *feeds {
+		if feedItem.Pub != feedPub {
+			continue
+		}
+		if feedItem.PrimarySource {
+			sources = append([]feedStruct{feedItem}, sources...)
+		} else {
+			sources = append(sources, feedItem)
+		}
+	}
+	return sources, nil
+}
+
+func (a *Atomstr) dbSaveIdentityProfile(feedPub string, profile identityProfile) error {
+	_, err := a.db.Exec(`UPDATE identities SET name = ?, about = ?, picture = ?, nip05_name = ? WHERE pub = ?`,
+		profile.Name, profile.About, profile.Picture, profile.Nip05, feedPub)
+	if err != nil {
+		return fmt.Errorf("can't save identity profile: %w", err)
+	}
+	return nil
+}
+
+// dbSetFeedIdentity makes a feed publish as the identity feedPub, the old
+// identity is removed if no feed uses it anymore. The items published by the
+// feed move along, so they aren't published again under the new identity.
+// An item the new identity already has stays as it is.
+func (a *Atomstr) dbSetFeedIdentity(feedItem feedStruct, feedPub string) error {
+	if feedPub == feedItem.Pub {
+		return nil
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("can't change identity: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE feeds SET identity_pub = ? WHERE pub = ?`, feedPub, feedItem.SourcePub); err != nil {
+		return fmt.Errorf("can't change identity: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE OR IGNORE published_items SET feed_pub = ? WHERE feed_pub = ? AND source_pub = ?`,
+		feedPub, feedItem.Pub, feedItem.SourcePub); err != nil {
+		return fmt.Errorf("can't move published items: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM published_items WHERE feed_pub = ? AND source_pub = ?`, feedItem.Pub, feedItem.SourcePub); err != nil {
+		return fmt.Errorf("can't move published items: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM identities WHERE pub = ? AND NOT EXISTS (SELECT 1 FROM feeds WHERE identity_pub = identities.pub)`, feedItem.Pub); err != nil {
+		return fmt.Errorf("can't remove identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't change identity: %w", err)
+	}
+	return nil
+}
+
+// feedIdentity is the -identity command. It shows the identity of a feed,
+// merges the feed into the identity of another feed (join), gives it back
+// its own identity (leave), or changes the profile (set key=value).
+func (a *Atomstr) feedIdentity(feedURL string, args []string) error {
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed not found")
+	}
+	if len(args) == 0 {
+		return a.printFeedIdentity(*feedItem)
+	}
+
+	switch args[0] {
+	case "join":
+		if len(args) != 2 {
+			return fmt.Errorf("use join <feed url>")
+		}
+		target := a.dbGetFeed(args[1])
+		if target.URL == "" {
+			return fmt.Errorf("feed %s not found", args[1])
+		}
+		if target.Pub == feedItem.Pub {
+			return fmt.Errorf("%s already publishes as %s", feedURL, target.Npub)
+		}
+		if err := a.dbSetFeedIdentity(*feedItem, target.Pub); err != nil {
+			return err
+		}
+		log.Printf("[INFO] %s now publishes as %s", feedURL, target.Npub)
+	case "leave":
+		if feedItem.Pub == feedItem.SourcePub {
+			return fmt.Errorf("%s already publishes with its own key", feedURL)
+		}
+		if _, err := a.db.Exec(`INSERT OR IGNORE INTO identities (pub, sec) SELECT pub, sec FROM feeds WHERE pub = ?`, feedItem.SourcePub); err != nil {
+			return fmt.Errorf("can't add identity: %w", err)
+		}
+		if err := a.dbSetFeedIdentity(*feedItem, feedItem.SourcePub); err != nil {
+			return err
+		}
+		feedItem = a.dbGetFeed(feedURL)
+		log.Printf("[INFO] %s now publishes as %s", feedURL, feedItem.Npub)
+		if !dryRunMode {
+			a.nostrUpdateFeedMetadata(feedItem)
+		}
+	case "set":
+		profile := feedItem.Profile
+		for _, arg := range args[1:] {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("invalid profile field %q, use key=value", arg)
+			}
+			if err := profile.set(key, value); err != nil {
+				return err
+			}
+		}
+		if err := a.dbCheckNip05Name(feedItem.Pub, profile.Nip05); err != nil {
+			return err
+		}
+		if err := a.dbSaveIdentityProfile(feedItem.Pub, profile); err != nil {
+			return err
+		}
+		log.Println("[INFO] Updated profile of", feedItem.Npub)
+		sources, err := a.dbGetIdentitySources(feedItem.Pub)
+		if err != nil {
+			return err
+		}
+		if len(sources) > 0 && !dryRunMode {
+			a.nostrUpdateFeedMetadata(&sources[0])
+		}
+	default:
+		return fmt.Errorf("unknown identity command %q, use join, leave or set", args[0])
+	}
+	return nil
+}
+
+// dbCheckNip05Name returns an error if another identity uses the NIP-05
+// name.
+func (a *Atomstr) dbCheckNip05Name(feedPub, name string) error {
+	if name == "" {
+		return nil
+	}
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		return err
+	}
+	for _, feedItem := range *feeds {
+		if feedItem.Pub != feedPub && (feedItem.Profile.Nip05 == name || feedURLToNip05Name(feedItem.URL) == name) {
+			return fmt.Errorf("NIP-05 name %q is used by %s", name, feedItem.Npub)
+		}
+	}
+	alias, err := a.dbGetNip05Alias(name)
+	if err != nil {
+		return err
+	}
+	if alias != nil && alias.Pub != feedPub {
+		return fmt.Errorf("NIP-05 name %q is used by %s", name, alias.Npub)
+	}
+	return nil
+}
+
+func (a *Atomstr) printFeedIdentity(feedItem feedStruct) error {
+	sources, err := a.dbGetIdentitySources(feedItem.Pub)
+	if err != nil {
+		return err
+	}
+	fmt.Println(feedItem.Npub)
+	for _, key := range identityProfileKeys {
+		value := feedItem.Profile.get(key)
+		if value == "" {
+			value = "(from " + sources[0].URL + ")"
+		}
+		fmt.Printf("%s = %s\n", key, value)
+	}
+	fmt.Println("sources:")
+	for _, source := range sources {
+		fmt.Print("  " + source.URL)
+		if source.SourcePub != source.Pub {
+			own, _ := nip19.EncodePublicKey(source.SourcePub)
+			fmt.Print(" (own key " + own + ")")
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetFeedIdentityMovesPublishedItems(t *testing.T) {
+	a := newTestAtomstr(t)
+	news := addTestFeed(t, a, "https://example.org/news.xml")
+	blog := addTestFeed(t, a, "https://example.org/blog.xml")
+	if !a.processFeedPost(*blog, testItem("post", "https://example.org/post"), time.Hour, nil) {
+		t.Fatalf("post was not published")
+	}
+	if !a.processFeedPost(*news, testItem("shared", "https://example.org/shared"), time.Hour, nil) {
+		t.Fatalf("shared was not published")
+	}
+	if !a.processFeedPost(*blog, testItem("shared", "https://example.org/shared"), time.Hour, nil) {
+		t.Fatalf("shared was not published by the blog")
+	}
+
+	if err := a.dbSetFeedIdentity(*blog, news.Pub); err != nil {
+		t.Fatal(err)
+	}
+	blog = testFeed(t, a, blog.URL)
+	if a.processFeedPost(*blog, testItem("post", "https://example.org/post"), time.Hour, nil) {
+		t.Errorf("post was published again after joining the identity")
+	}
+	if published := a.dbGetPublishedItem(news.Pub, "shared"); published == nil || published.SourcePub != news.SourcePub {
+		t.Errorf("item of the identity was replaced by the joining feed: %+v", published)
+	}
+	if a.dbGetPublishedItem(blog.SourcePub, "post") != nil || a.dbGetPublishedItem(blog.SourcePub, "shared") != nil {
+		t.Errorf("items are still stored under the old identity")
+	}
+
+	if err := a.dbSetFeedIdentity(*blog, blog.SourcePub); err != nil {
+		t.Fatal(err)
+	}
+	blog = testFeed(t, a, blog.URL)
+	if a.processFeedPost(*blog, testItem("post", "https://example.org/post"), time.Hour, nil) {
+		t.Errorf("post was published again after leaving the identity")
+	}
+	if published := a.dbGetPublishedItem(news.Pub, "post"); published != nil {
+		t.Errorf("item of the feed stayed with the identity it left: %+v", published)
+	}
+	if a.dbGetPublishedItem(news.Pub, "shared") == nil {
+		t.Errorf("item of the identity was moved by the leaving feed")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -14,16 +15,18 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// scrapeStats tracks per-cycle scrape statistics
+// scrapeStats tracks scrape statistics between summaries
 type scrapeStats struct {
 	feedsProcessed int64
 	feedsCached    int64
 	feedsErrored   int64
 	feedsSkipped   int64
 	postsPublished int64
+	postsFiltered  int64
 }
 
-func (a *Atomstr) startWorkers(work string) error {
+// startMetadataWorkers updates the nostr metadata of all feeds.
+func (a *Atomstr) startMetadataWorkers() error {
 	feeds, err := a.dbGetAllFeeds()
 	if err != nil {
 		return fmt.Errorf("failed to get feeds: %w", err)
@@ -32,11 +35,7 @@ func (a *Atomstr) startWorkers(work string) error {
 		log.Println("[WARN] No feeds found")
 	}
 
-	log.Printf("[DEBUG] Start %s (%d feeds)", work, len(*feeds))
-
-	if work == "scrape" {
-		prunePublishedPosts(1 * time.Hour)
-	}
+	log.Printf("[DEBUG] Start metadata (%d feeds)", len(*feeds))
 
 	stats := &scrapeStats{}
 	ch := make(chan feedStruct)
@@ -45,12 +44,7 @@ func (a *Atomstr) startWorkers(work string) error {
 	// start the workers
 	for t := 0; t < maxWorkers; t++ {
 		wg.Add(1)
-		switch work {
-		case "metadata":
-			go a.processFeedMetadata(ch, &wg, stats)
-		default:
-			go a.processFeedURL(ch, &wg, stats)
-		}
+		go a.processFeedMetadata(ch, &wg, stats)
 	}
 
 	// push the lines to the queue channel for processing
@@ -61,14 +55,8 @@ func (a *Atomstr) startWorkers(work string) error {
 	close(ch) // this will cause the workers to stop and exit their receive loop
 	wg.Wait() // make sure they all exit
 
-	// Log cycle summary at INFO level
-	if work == "scrape" {
-		log.Printf("[INFO] Scrape complete: %d feeds (%d cached, %d errors, %d skipped), %d posts published",
-			stats.feedsProcessed, stats.feedsCached, stats.feedsErrored, stats.feedsSkipped, stats.postsPublished)
-	} else {
-		log.Printf("[INFO] Metadata update complete: %d feeds (%d errors)",
-			stats.feedsProcessed, stats.feedsErrored)
-	}
+	log.Printf("[INFO] Metadata update complete: %d feeds (%d errors)",
+		stats.feedsProcessed, stats.feedsErrored)
 	return nil
 }
 
@@ -76,6 +64,17 @@ func main() {
 	dryRun := flag.Bool("dry-run", false, "Enable dry-run mode (log JSON instead of publishing to relays)")
 	feedNew := flag.String("a", "", "Add a new URL to scrape")
 	feedDelete := flag.String("d", "", "Remove a feed from db")
+	feedSet := flag.String("set", "", "Change settings of a feed, followed by key=value arguments")
+	feedSettingsShow := flag.String("settings", "", "Show the settings of a feed")
+	templatePreview := flag.String("preview", "", "Show the notes of the newest items of a feed, optionally followed by key=value settings")
+	feedFilter := flag.String("filter", "", "List the filter rules of a feed, or change them with add <rule> or remove <id>")
+	scrapePage := flag.String("scrape", "", "Add a web page without a feed, followed by item=<selector> and optional title, link, date, date_format and body arguments")
+	feedMove := flag.String("move", "", "Change the URL of a feed to the URL given as argument, keeping its keys and followers")
+	feedMerge := flag.String("merge", "", "Remove the duplicate feed given as argument, keeping this feed")
+	flag.Bool("duplicates", false, "List feeds that look like duplicates of each other")
+	feedIdentity := flag.String("identity", "", "Show the identity of a feed, or change it with join <feed url>, leave or set key=value")
+	opmlImport := flag.String("import-opml", "", "Add all feeds of an OPML file")
+	opmlExport := flag.String("export-opml", "", "Write all feeds to an OPML file (- for stdout)")
 	flag.Bool("l", false, "List all feeds with npubs")
 	flag.Bool("v", false, "Shows version")
 	flag.Parse()
@@ -86,10 +85,76 @@ func main() {
 
 	logger()
 
+	if err := validateNoteTemplate(noteTemplate); err != nil {
+		log.Fatalf("[FATAL] NOTE_TEMPLATE: %v", err)
+	}
+	switch videoPublishMode {
+	case "note", "video", "video+note":
+	default:
+		log.Fatalf("[FATAL] VIDEO_PUBLISH_MODE: use note, video or video+note")
+	}
+
 	a := &Atomstr{db: dbInit()}
+	a.pool = newRelayPool(a.dbRecordRelayNotice)
 
 	if flagset["a"] {
-		a.addSource(*feedNew)
+		var settings feedSettings
+		if err := parseFeedSettingArgs(&settings, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return
+		}
+		var multiple *multipleFeedsError
+		if _, err := a.addSource(*feedNew, settings); errors.As(err, &multiple) {
+			printFeedCandidates(multiple)
+		}
+	} else if flagset["set"] {
+		if err := a.updateFeedSettings(*feedSet, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["settings"] {
+		if err := a.printFeedSettings(*feedSettingsShow); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["filter"] {
+		if err := a.feedFilters(*feedFilter, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["scrape"] {
+		if err := a.scrapeSource(*scrapePage, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["move"] {
+		if flag.NArg() != 1 {
+			log.Println("[ERROR] use -move <old url> <new url>")
+		} else if _, err := a.moveSource(*feedMove, flag.Arg(0), true); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["duplicates"] {
+		if err := a.printDuplicateFeeds(); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["merge"] {
+		if flag.NArg() != 1 {
+			log.Println("[ERROR] use -merge <feed url> <duplicate url>")
+		} else if err := a.mergeFeeds(*feedMerge, flag.Arg(0)); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["identity"] {
+		if err := a.feedIdentity(*feedIdentity, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["preview"] {
+		if err := a.previewNoteTemplate(*templatePreview, flag.Args()); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["import-opml"] {
+		if err := a.importOPMLFile(*opmlImport); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	} else if flagset["export-opml"] {
+		if err := a.exportOPMLFile(*opmlExport); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
 	} else if flagset["l"] {
 		if err := a.listFeeds(); err != nil {
 			log.Printf("[ERROR] %v", err)
@@ -103,34 +168,33 @@ func main() {
 	} else {
 		log.Println("[INFO] Starting atomstr v", atomstrVersion)
 		// slog.Info("Starting atomstr v", atomstrVersion)
+		a.checkFeedTemplates()
+		a.scheduler = newScheduler()
+		a.stats = &scrapeStats{}
 		go a.webserver()
+		go a.outboxPublisher()
+		if websubCallbackBase != "" {
+			go a.websubManager()
+		}
 
 		// first run
-		if err := a.startWorkers("metadata"); err != nil {
-			log.Printf("[ERROR] %v", err)
-		}
-		if err := a.startWorkers("scrape"); err != nil {
+		if err := a.startMetadataWorkers(); err != nil {
 			log.Printf("[ERROR] %v", err)
 		}
 
+		// every feed is fetched on its own schedule
+		go a.runScheduler()
+
 		metadataTicker := time.NewTicker(metadataInterval)
-		updateTicker := time.NewTicker(fetchInterval)
 
 		cancelChan := make(chan os.Signal, 1)
 		// catch SIGETRM or SIGINTERRUPT
 		signal.Notify(cancelChan, syscall.SIGTERM, syscall.SIGINT)
 
 		go func() {
-			for {
-				select {
-				case <-metadataTicker.C:
-					if err := a.startWorkers("metadata"); err != nil {
-						log.Printf("[ERROR] %v", err)
-					}
-				case <-updateTicker.C:
-					if err := a.startWorkers("scrape"); err != nil {
-						log.Printf("[ERROR] %v", err)
-					}
+			for range metadataTicker.C {
+				if err := a.startMetadataWorkers(); err != nil {
+					log.Printf("[ERROR] %v", err)
 				}
 			}
 		}()
@@ -138,7 +202,8 @@ func main() {
 
 		log.Printf("[DEBUG] Caught signal %v", sig)
 		metadataTicker.Stop()
-		updateTicker.Stop()
+		log.Println("[INFO] Closing relay connections")
+		a.pool.close()
 		log.Println("[INFO] Closing DB")
 		a.db.Close()
 		log.Println("[INFO] Shutting down")
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// mediaProbeSize is how much of a media file is fetched to find its type,
+// size and dimensions.
+const mediaProbeSize = 64 << 10
+
+// mediaItem is an image, video or audio file of a feed item, described by a
+// NIP-92 imeta tag.
+type mediaItem struct {
+	URL      string
+	MimeType string
+	Width    int
+	Height   int
+	Alt      string
+	Size     int64
+	SHA256   string // set for media mirrored to the Blossom server
+	Duration int    // seconds, for podcast episodes
+	Image    string // artwork of podcast episodes
+}
+
+// imetaTag returns the NIP-92 imeta tag of the media item.
+func (m mediaItem) imetaTag() nostr.Tag {
+	tag := nostr.Tag{"imeta", "url " + m.URL}
+	if m.MimeType != "" {
+		tag = append(tag, "m "+m.MimeType)
+	}
+	if m.Width > 0 && m.Height > 0 {
+		tag = append(tag, fmt.Sprintf("dim %dx%d", m.Width, m.Height))
+	}
+	if m.Alt != "" {
+		tag = append(tag, "alt "+m.Alt)
+	}
+	if m.Size > 0 {
+		tag = append(tag, "size "+strconv.FormatInt(m.Size, 10))
+	}
+	if m.SHA256 != "" {
+		tag = append(tag, "x "+m.SHA256)
+	}
+	if m.Duration > 0 {
+		tag = append(tag, "duration "+strconv.Itoa(m.Duration))
+	}
+	if m.Image != "" {
+		tag = append(tag, "image "+m.Image)
+	}
+	return tag
+}
+
+// merge fills the missing fields of m from other.
+func (m *mediaItem) merge(other mediaItem) {
+	if m.MimeType == "" {
+		m.MimeType = other.MimeType
+	}
+	if m.Width == 0 || m.Height == 0 {
+		m.Width, m.Height = other.Width, other.Height
+	}
+	if m.Alt == "" {
+		m.Alt = other.Alt
+	}
+	if m.Size == 0 {
+		m.Size = other.Size
+	}
+}
+
+// collectMedia returns the content images, media:content and
+// media:thumbnail elements, and enclosures of a feed item.
+func collectMedia(feedPost *gofeed.Item) []mediaItem {
+	var media []mediaItem
+	index := make(map[string]int)
+	add := func(m mediaItem) {
+		m.URL = resolveURL(feedPost.Link, strings.TrimSpace(m.URL))
+		if m.URL == "" || !strings.HasPrefix(m.URL, "http") || isIconURL(m.URL) {
+			return
+		}
+		if i, ok := index[m.URL]; ok {
+			media[i].merge(m)
+			return
+		}
+		m.SHA256 = blobHash(m.URL)
+		index[m.URL] = len(media)
+		media = append(media, m)
+	}
+
+	for _, html := range []string{feedPost.Description, feedPost.Content} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+		if err != nil {
+			continue
+		}
+		doc.Find("img[src]").Each(func(_ int, s *goquery.Selection) {
+			src, _ := s.Attr("src")
+			alt, _ := s.Attr("alt")
+			width, _ := strconv.Atoi(s.AttrOr("width", ""))
+			height, _ := strconv.Atoi(s.AttrOr("height", ""))
+			add(mediaItem{URL: src, Alt: strings.TrimSpace(alt), Width: width, Height: height})
+		})
+	}
+
+	for _, m := range mediaExtensions(feedPost.Extensions["media"]) {
+		add(m)
+	}
+	if feedPost.Image != nil {
+		add(mediaItem{URL: feedPost.Image.URL, Alt: feedPost.Image.Title})
+	}
+	for _, enclosure := range feedPost.Enclosures {
+		size, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+		add(mediaItem{URL: enclosure.URL, MimeType: enclosure.Type, Size: size})
+	}
+
+	for i := range media {
+		if media[i].MimeType == "" {
+			media[i].MimeType = mime.TypeByExtension(path.Ext(strings.SplitN(media[i].URL, "?", 2)[0]))
+		}
+	}
+	return media
+}
+
+// mediaExtensions reads media:content and media:thumbnail elements (Media
+// RSS), including those inside media:group.
+func mediaExtensions(elements map[string][]ext.Extension) []mediaItem {
+	var media []mediaItem
+	for _, group := range elements["group"] {
+		media = append(media, mediaExtensions(group.Children)...)
+	}
+	for _, name := range []string{"content", "thumbnail"} {
+		for _, element := range elements[name] {
+			m := mediaItem{URL: element.Attrs["url"], MimeType: element.Attrs["type"]}
+			m.Width, _ = strconv.Atoi(element.Attrs["width"])
+			m.Height, _ = strconv.Atoi(element.Attrs["height"])
+			m.Size, _ = strconv.ParseInt(element.Attrs["fileSize"], 10, 64)
+			for _, description := range element.Children["description"] {
+				m.Alt = strings.TrimSpace(description.Value)
+			}
+			media = append(media, m)
+		}
+	}
+	return media
+}
+
+// mediaTags returns the imeta tags for the media of a feed item that are
+// referenced in content. NIP-92 clients ignore imeta tags of other URLs.
+func mediaTags(feedItem feedStruct, feedPost *gofeed.Item, content string) nostr.Tags {
+	var tags nostr.Tags
+	episode := episodeEnclosure(feedItem, feedPost)
+	for _, m := range collectMedia(feedPost) {
+		if !strings.Contains(content, m.URL) {
+			continue
+		}
+		if episode != nil && m.URL == resolveURL(feedPost.Link, episode.URL) {
+			m.Duration = episodeDuration(feedPost)
+			m.Image = episodeImage(feedItem, feedPost)
+		}
+		if probeMedia && (m.MimeType == "" || m.Size == 0 || (m.Width == 0 && strings.HasPrefix(m.MimeType, "image/"))) {
+			if probed, err := probeMediaURL(m.URL); err != nil {
+				log.Printf("[DEBUG] Can't probe %s: %v", m.URL, err)
+			} else {
+				m.merge(probed)
+			}
+		}
+		tags = append(tags, m.imetaTag())
+	}
+	return tags
+}
+
+// mediaProbeCache keeps probe results, items are often published more than
+// once (e.g. note and article, or updated items).
+var mediaProbeCache = struct {
+	sync.Mutex
+	items map[string]mediaItem
+}{items: make(map[string]mediaItem)}
+
+// probeMediaURL fetches the start of a media file to find its MIME type,
+// size and, for images, dimensions.
+func probeMediaURL(mediaURL string) (mediaItem, error) {
+	mediaProbeCache.Lock()
+	cached, ok := mediaProbeCache.items[mediaURL]
+	mediaProbeCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", mediaURL, nil)
+	if err != nil {
+		return mediaItem{}, err
+	}
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", mediaProbeSize-1))
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return mediaItem{}, err
+	}
+	defer resp.Body.Close()
+
+	m := mediaItem{URL: mediaURL}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-65535/1234567
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			m.Size, _ = strconv.ParseInt(total, 10, 64)
+		}
+	case http.StatusOK:
+		m.Size = resp.ContentLength
+	default:
+		return m, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if m.Size < 0 {
+		m.Size = 0
+	}
+	if mimeType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		m.MimeType = mimeType
+	}
+
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, mediaProbeSize))
+	if m.MimeType == "" || m.MimeType == "application/octet-stream" {
+		m.MimeType = http.DetectContentType(head)
+	}
+	if strings.HasPrefix(m.MimeType, "image/") {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+			m.Width, m.Height = config.Width, config.Height
+		}
+	}
+
+	mediaProbeCache.Lock()
+	if len(mediaProbeCache.items) > 1000 {
+		mediaProbeCache.items = make(map[string]mediaItem)
+	}
+	mediaProbeCache.items[mediaURL] = m
+	mediaProbeCache.Unlock()
+	return m, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testMediaRSS = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+<channel><title>Media</title><link>https://example.com/</link>
+<item>
+	<title>Photos</title>
+	<link>https://example.com/posts/photos</link>
+	<description><![CDATA[<p><img src="/images/a.jpg" alt=" First " width="640" height="480">
+		<img src="https://example.com/favicon.ico"><img src="data:image/png;base64,AAAA"></p>]]></description>
+	<media:content url="https://example.com/images/a.jpg" type="image/jpeg" fileSize="1234"/>
+	<media:group>
+		<media:content url="https://example.com/videos/b.mp4" type="video/mp4" width="1920" height="1080">
+			<media:description>A video</media:description>
+		</media:content>
+	</media:group>
+	<media:thumbnail url="https://example.com/images/c.png?size=large"/>
+	<enclosure url="https://example.com/audio/d.mp3" type="audio/mpeg" length="5678"/>
+</item>
+</channel></rss>`
+
+func parseTestMediaItem(t *testing.T) *gofeed.Item {
+	t.Helper()
+	feed, err := gofeed.NewParser().ParseString(testMediaRSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed.Items[0]
+}
+
+func TestCollectMedia(t *testing.T) {
+	got := collectMedia(parseTestMediaItem(t))
+	want := []mediaItem{
+		{URL: "https://example.com/images/a.jpg", MimeType: "image/jpeg", Width: 640, Height: 480, Alt: "First", Size: 1234},
+		{URL: "https://example.com/videos/b.mp4", MimeType: "video/mp4", Width: 1920, Height: 1080, Alt: "A video"},
+		{URL: "https://example.com/images/c.png?size=large", MimeType: "image/png"},
+		{URL: "https://example.com/audio/d.mp3", MimeType: "audio/mpeg", Size: 5678},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectMedia() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMediaTags(t *testing.T) {
+	feedPost := parseTestMediaItem(t)
+	content := "Photos\n\nhttps://example.com/images/a.jpg\n\nhttps://example.com/audio/d.mp3"
+	got := mediaTags(feedStruct{}, feedPost, content)
+	want := nostr.Tags{
+		{"imeta", "url https://example.com/images/a.jpg", "m image/jpeg", "dim 640x480", "alt First", "size 1234"},
+		{"imeta", "url https://example.com/audio/d.mp3", "m audio/mpeg", "size 5678"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mediaTags() = %v, want %v", got, want)
+	}
+}
+
+func TestProbeMediaURL(t *testing.T) {
+	allowTestServer(t)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	var fetches int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		switch r.URL.Path {
+		case "/image":
+			if !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+				t.Errorf("probe without range: %q", r.Header.Get("Range"))
+			}
+			// no Content-Type, it's sniffed from the data
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Range", "bytes 0-99/123456")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(buf.Bytes())
+		case "/audio":
+			w.Header().Set("Content-Type", "audio/mpeg; charset=binary")
+			w.Write(make([]byte, 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	m, err := probeMediaURL(server.URL + "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mediaItem{URL: server.URL + "/image", MimeType: "image/png", Width: 30, Height: 20, Size: 123456}
+	if m != want {
+		t.Errorf("probeMediaURL(image) = %+v, want %+v", m, want)
+	}
+	if _, err := probeMediaURL(server.URL + "/image"); err != nil || fetches != 1 {
+		t.Errorf("probe result wasn't cached (%d fetches, %v)", fetches, err)
+	}
+
+	m, err = probeMediaURL(server.URL + "/audio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = mediaItem{URL: server.URL + "/audio", MimeType: "audio/mpeg", Size: 100}
+	if m != want {
+		t.Errorf("probeMediaURL(audio) = %+v, want %+v", m, want)
+	}
+
+	if _, err := probeMediaURL(server.URL + "/missing"); err == nil {
+		t.Error("probe of a missing file didn't fail")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// moveSource changes the URL of a source in place, so it keeps its keys,
+// followers and published items. The NIP-05 name derived from the old URL
+// stays valid as an alias. With verify the new URL has to be a valid source
+// first, otherwise the caller already fetched it (e.g. a permanent redirect).
+func (a *Atomstr) moveSource(oldURL, newURL string, verify bool) (*feedStruct, error) {
+	feedItem := a.dbGetFeed(oldURL)
+	if feedItem.URL == "" {
+		return feedItem, fmt.Errorf("feed %s not found", oldURL)
+	}
+	newURL = normalizeFeedURL(newURL)
+	parsed, err := url.Parse(newURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return feedItem, fmt.Errorf("invalid URL %q", newURL)
+	}
+	if newURL == oldURL {
+		return feedItem, fmt.Errorf("%s is the current URL", newURL)
+	}
+	// e.g. moving to https is fine, but not to the URL of another feed
+	if existing := a.dbFindFeed(newURL); existing.URL != "" && existing.SourcePub != feedItem.SourcePub {
+		return feedItem, fmt.Errorf("feed %s already exists", existing.URL)
+	}
+
+	var data *feedStruct
+	if verify {
+		if feedItem.Scrape != nil {
+			data, err = checkValidScrapedSource(newURL, *feedItem.Scrape)
+		} else {
+			data, err = checkValidFeedSource(newURL)
+		}
+		if err != nil {
+			return feedItem, fmt.Errorf("no valid source at %s: %w", newURL, err)
+		}
+	}
+
+	// the validators of the old server don't apply to the new one
+	_, err = a.db.Exec(`UPDATE feeds SET url = ?, etag = '', last_modified = '', next_fetch_at = ? WHERE pub = ?`,
+		newURL, time.Now(), feedItem.SourcePub)
+	if err != nil {
+		return feedItem, fmt.Errorf("can't move feed: %w", err)
+	}
+	if oldName, newName := feedURLToNip05Name(oldURL), feedURLToNip05Name(newURL); oldName != newName {
+		if err := a.dbSaveNip05Alias(oldName, feedItem.SourcePub); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+	// the subscription is for the old topic, the hub of the new URL is
+	// subscribed when it is found
+	a.websubUnsubscribe(feedItem.SourcePub)
+	// the caller schedules the new URL: a worker that followed a redirect
+	// when it is done, so no other worker fetches the feed meanwhile
+	if a.scheduler != nil {
+		a.scheduler.remove(oldURL)
+	}
+	log.Printf("[INFO] Moved %s to %s, keeping %s", oldURL, newURL, feedItem.Npub)
+
+	feedItem.URL = newURL
+	if data != nil {
+		if err := a.dbSaveWebSubHub(feedItem.SourcePub, data.Hub, data.Topic); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if err := a.dbUpdateFeedSelfLink(newURL, data.Topic); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		// the profile has the NIP-05 name of the new URL
+		if feedItem.PrimarySource && !dryRunMode {
+			feedItem.Title = data.Title
+			feedItem.Description = data.Description
+			feedItem.Link = data.Link
+			feedItem.Image = data.Image
+			a.nostrUpdateFeedMetadata(feedItem)
+		}
+	}
+	return feedItem, nil
+}
+
+// followPermanentRedirect moves a feed whose URL answered with a permanent
+// redirect to the new URL.
+func (a *Atomstr) followPermanentRedirect(feedItem *feedStruct, newURL string) {
+	if dryRunMode {
+		log.Printf("[DEBUG] DRY-RUN: Would move %s to %s after a permanent redirect", feedItem.URL, newURL)
+		return
+	}
+	log.Printf("[INFO] Feed %s permanently redirects to %s", feedItem.URL, newURL)
+	if _, err := a.moveSource(feedItem.URL, newURL, false); err != nil {
+		log.Printf("[WARN] Can't follow redirect of %s: %v", feedItem.URL, err)
+		return
+	}
+	feedItem.URL = newURL
+}
+
+func (a *Atomstr) dbSaveNip05Alias(name, feedPub string) error {
+	_, err := a.db.Exec(`INSERT OR REPLACE INTO nip05_aliases (name, feed_pub) VALUES (?, ?)`, name, feedPub)
+	if err != nil {
+		return fmt.Errorf("can't save NIP-05 alias: %w", err)
+	}
+	return nil
+}
+
+// dbGetNip05Alias returns the feed a NIP-05 alias belongs to, or nil.
+func (a *Atomstr) dbGetNip05Alias(name string) (*feedStruct, error) {
+	var feedPub string
+	err := a.db.QueryRow(`SELECT feed_pub FROM nip05_aliases WHERE name = ?`, name).Scan(&feedPub)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get NIP-05 alias: %w", err)
+	}
+	feedItem, err := a.dbGetFeedByPub(feedPub)
+	if err != nil || feedItem.URL == "" {
+		return nil, err
+	}
+	return feedItem, nil
+}
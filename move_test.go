@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMoveSource(t *testing.T) {
+	allowTestServer(t)
+	defer func(relays []string) { relaysToPublishTo = relays }(relaysToPublishTo)
+	relaysToPublishTo = []string{"ws://127.0.0.1:1"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testRSS("Moved"))
+	}))
+	defer server.Close()
+
+	a := newTestAtomstr(t)
+	oldURL := "https://example.org/feed.xml"
+	feedItem := addTestFeed(t, a, oldURL)
+	addTestFeed(t, a, "https://example.org/other.xml")
+	if err := a.dbUpdateFeedCache(oldURL, `"etag"`, "yesterday"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.dbMarkPostPublished(*feedItem, publishedItem{ItemKey: "item", EventID: "event"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, newURL := range []string{oldURL, "ftp://example.org/feed.xml", "https://example.org/other.xml", server.URL + "/missing.xml"} {
+		if _, err := a.moveSource(oldURL, newURL, true); err == nil {
+			t.Errorf("move to %s didn't fail", newURL)
+		}
+	}
+	if _, err := a.moveSource("https://example.org/unknown.xml", server.URL+"/feed.xml", true); err == nil {
+		t.Error("move of an unknown feed didn't fail")
+	}
+	if a.dbGetFeed(oldURL).URL != oldURL {
+		t.Fatal("failed move changed the feed")
+	}
+
+	newURL := server.URL + "/feed.xml"
+	moved, err := a.moveSource(oldURL, newURL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.URL != newURL || moved.Title != "Moved" {
+		t.Errorf("moved feed is %s %q", moved.URL, moved.Title)
+	}
+	if a.dbGetFeed(oldURL).URL != "" {
+		t.Error("feed is still found at the old URL")
+	}
+	got := a.dbGetFeed(newURL)
+	if got.Pub != feedItem.Pub || got.Sec != feedItem.Sec {
+		t.Error("moved feed has other keys")
+	}
+	if got.ETag != "" || got.LastModified != "" {
+		t.Errorf("validators of the old URL were kept: %q %q", got.ETag, got.LastModified)
+	}
+	if a.dbGetPublishedItem(feedItem.Pub, "item") == nil {
+		t.Error("published items were lost")
+	}
+	alias, err := a.dbGetNip05Alias(feedURLToNip05Name(oldURL))
+	if err != nil || alias == nil || alias.URL != newURL {
+		t.Errorf("NIP-05 name of the old URL resolves to %+v (%v)", alias, err)
+	}
+}
+
+func TestPermanentRedirect(t *testing.T) {
+	allowTestServer(t)
+	mux := http.NewServeMux()
+	redirect := func(path, target string, status int) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, target, status) })
+	}
+	redirect("/old.xml", "/older.xml", http.StatusMovedPermanently)
+	redirect("/older.xml", "/feed.xml", http.StatusPermanentRedirect)
+	redirect("/temporary.xml", "/feed.xml", http.StatusFound)
+	redirect("/mixed.xml", "/temporary.xml", http.StatusMovedPermanently)
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, testRSS("Feed")) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/feed.xml", ""},
+		{"/old.xml", server.URL + "/feed.xml"},
+		{"/temporary.xml", ""},
+		// the feed moved to the temporary redirect
+		{"/mixed.xml", server.URL + "/temporary.xml"},
+	}
+	for _, tt := range tests {
+		result, err := fetchFeedWithCaching(server.URL+tt.path, "", "", nil)
+		if err != nil {
+			t.Fatalf("fetch %s: %v", tt.path, err)
+		}
+		if result.MovedTo != tt.want {
+			t.Errorf("fetch %s moved to %q, want %q", tt.path, result.MovedTo, tt.want)
+		}
+	}
+
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, server.URL+"/old.xml")
+	a.followPermanentRedirect(feedItem, server.URL+"/feed.xml")
+	if feedItem.URL != server.URL+"/feed.xml" || a.dbGetFeed(server.URL+"/feed.xml").Pub != feedItem.Pub {
+		t.Errorf("feed wasn't moved after a permanent redirect: %s", feedItem.URL)
+	}
+
+	// a redirect to another feed doesn't merge them
+	other := addTestFeed(t, a, server.URL+"/temporary.xml")
+	a.followPermanentRedirect(other, server.URL+"/feed.xml")
+	if other.URL != server.URL+"/temporary.xml" || a.dbGetFeed(server.URL+"/temporary.xml").Pub != other.Pub {
+		t.Errorf("redirect to another feed moved the feed to %s", other.URL)
+	}
+}
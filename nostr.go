@@ -1,26 +1,31 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
-func nostrUpdateFeedMetadata(feedItem *feedStruct) {
+func (a *Atomstr) nostrUpdateFeedMetadata(feedItem *feedStruct) {
 	// fmt.Println(feedItem)
 
 	metadata := map[string]string{
 		"name":    feedItem.Title + " (RSS Feed)",
 		"about":   feedItem.Description + "\n\n" + feedItem.Link,
 		"picture": feedItem.Image,
-		"nip05":   feedURLToNip05Name(feedItem.URL) + "@" + nip05Domain,
+		"nip05":   feedItem.nip05Name() + "@" + nip05Domain,
 	}
+	// the profile of the identity takes precedence over the feed
+	for _, key := range []string{"name", "about", "picture"} {
+		if value := feedItem.Profile.get(key); value != "" {
+			metadata[key] = value
+		}
+	}
+	metadata["picture"] = a.mirrorURL(*feedItem, metadata["picture"])
 
 	content, _ := json.Marshal(metadata)
 
@@ -36,18 +41,24 @@ func nostrUpdateFeedMetadata(feedItem *feedStruct) {
 	log.Println("[DEBUG] Updating feed metadata for", feedItem.Title)
 
 	if !dryRunMode {
-		nostrPostToRelays(ev, dedupeRelays(relaysToPublishTo, discoveryRelays, blasterRelays))
+		a.nostrPostToRelays(ev, dedupeRelays(feedItem.publishRelays(), discoveryRelays, blasterRelays))
 	} else {
 		eventJSON, _ := json.Marshal(ev)
 		log.Println("[DEBUG] DRY-RUN: Would publish metadata event:", string(eventJSON))
 	}
 
-	nostrPublishRelayList(feedItem)
+	a.nostrPublishRelayList(feedItem)
 }
 
 func (a *Atomstr) processFeedMetadata(ch chan feedStruct, wg *sync.WaitGroup, stats *scrapeStats) {
 	for feedItem := range ch {
-		data, err := checkValidFeedSource(feedItem.URL)
+		var data *feedStruct
+		var err error
+		if feedItem.Scrape != nil {
+			data, err = checkValidScrapedSource(feedItem.URL, *feedItem.Scrape)
+		} else {
+			data, err = checkValidFeedSource(feedItem.URL)
+		}
 		if err != nil {
 			log.Println("[ERROR] error updating feed metadata:", feedItem.URL)
 			atomic.AddInt64(&stats.feedsErrored, 1)
@@ -57,7 +68,19 @@ func (a *Atomstr) processFeedMetadata(ch chan feedStruct, wg *sync.WaitGroup, st
 		feedItem.Description = data.Description
 		feedItem.Link = data.Link
 		feedItem.Image = data.Image
-		nostrUpdateFeedMetadata(&feedItem)
+		// an identity with several sources takes its profile from the first
+		if feedItem.PrimarySource {
+			a.nostrUpdateFeedMetadata(&feedItem)
+		}
+		if err := a.dbUpdateFeedInfo(feedItem.URL, feedItem.Title, feedItem.Link); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if err := a.dbSaveWebSubHub(feedItem.SourcePub, data.Hub, data.Topic); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if err := a.dbUpdateFeedSelfLink(feedItem.URL, data.Topic); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
 		atomic.AddInt64(&stats.feedsProcessed, 1)
 	}
 	wg.Done()
@@ -81,15 +104,15 @@ func (a *Atomstr) ALTnostrUpdateAllFeedsMetadata() error {
 		feedItem.Description = data.Description
 		feedItem.Link = data.Link
 		feedItem.Image = data.Image
-		nostrUpdateFeedMetadata(&feedItem)
+		a.nostrUpdateFeedMetadata(&feedItem)
 	}
 	log.Println("[INFO] Finished updating feeds metadata")
 	return nil
 }
 
-func nostrPublishRelayList(feedItem *feedStruct) {
+func (a *Atomstr) nostrPublishRelayList(feedItem *feedStruct) {
 	var tags nostr.Tags
-	for _, url := range relaysToPublishTo {
+	for _, url := range feedItem.publishRelays() {
 		tags = append(tags, nostr.Tag{"r", url, "write"})
 	}
 
@@ -105,7 +128,7 @@ func nostrPublishRelayList(feedItem *feedStruct) {
 	log.Println("[DEBUG] Publishing NIP-65 relay list for", feedItem.Title)
 
 	if !dryRunMode {
-		nostrPostToRelays(ev, dedupeRelays(relaysToPublishTo, discoveryRelays, blasterRelays))
+		a.nostrPostToRelays(ev, dedupeRelays(feedItem.publishRelays(), discoveryRelays, blasterRelays))
 	} else {
 		eventJSON, _ := json.Marshal(ev)
 		log.Println("[DEBUG] DRY-RUN: Would publish NIP-65 relay list event:", string(eventJSON))
@@ -126,58 +149,21 @@ func dedupeRelays(lists ...[]string) []string {
 	return result
 }
 
-func nostrPostToRelays(ev nostr.Event, relays []string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	var wg sync.WaitGroup
-	for _, relayURL := range relays {
-		wg.Add(1)
-		go func(u string) {
-			defer wg.Done()
-			relay, err := nostr.RelayConnect(ctx, u)
-			if err != nil {
-				log.Println("[ERROR]", u, err)
-				return
-			}
-			defer relay.Close()
-			if err := relay.Publish(ctx, ev); err != nil {
-				log.Println("[WARN]", u, err)
-				return
-			}
-			log.Printf("[DEBUG] Event published to %s\n", u)
-		}(relayURL)
+// nostrPostToRelays writes ev to the outbox and tries to deliver it right
+// away. Relays that don't accept it are retried by the outbox publisher.
+func (a *Atomstr) nostrPostToRelays(ev nostr.Event, relays []string) {
+	if err := a.dbEnqueueOutbox(ev, relays); err != nil {
+		log.Printf("[ERROR] %v", err)
 	}
-	wg.Wait()
+	a.deliverOutbox(ev, relays)
 }
 
-func nostrPostItem(ev nostr.Event) {
+func (a *Atomstr) nostrPostItem(ev nostr.Event, relays []string) {
 	if dryRunMode {
 		eventJSON, _ := json.Marshal(ev)
 		log.Println("[DEBUG] DRY-RUN: Would publish event to relays:", string(eventJSON))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	var wg sync.WaitGroup
-	for _, relayURL := range relaysToPublishTo {
-		wg.Add(1)
-		go func(u string) {
-			defer wg.Done()
-			relay, err := nostr.RelayConnect(ctx, u)
-			if err != nil {
-				log.Println("[ERROR]", u, err)
-				return
-			}
-			defer relay.Close()
-			if err := relay.Publish(ctx, ev); err != nil {
-				log.Println("[WARN]", u, err)
-				return
-			}
-			log.Printf("[DEBUG] Event published to %s\n", u)
-		}(relayURL)
-	}
-	wg.Wait()
+	a.nostrPostToRelays(ev, relays)
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// builtinNoteTemplates are shipped with atomstr and can be selected by name
+// in NOTE_TEMPLATE or the template setting of a feed.
+var builtinNoteTemplates = map[string]string{
+	// title, summary, enclosures and link
+	"default": `{{.Title}}
+
+{{.Summary}}{{range .Enclosures}}
+
+{{.}}{{end}}{{with .Link}}
+
+{{.}}{{end}}`,
+	// summary, enclosures and link, for feeds without useful titles
+	"no-title": noTitleNoteTemplate,
+	// nitter and telegram feeds repeat the post as its title
+	"nitter":   noTitleNoteTemplate,
+	"telegram": noTitleNoteTemplate,
+	// title and link only
+	"title-link": `{{.Title}}{{with .Link}}
+
+{{.}}{{end}}`,
+}
+
+const noTitleNoteTemplate = `{{.Summary}}{{range .Enclosures}}
+
+{{.}}{{end}}{{with .Link}}
+
+{{.}}{{end}}`
+
+// noteTemplateData is the data available to note content templates.
+type noteTemplateData struct {
+	Title      string
+	Author     string
+	Summary    string // description as plain text
+	Content    string // content as plain text, empty if the feed has none
+	Link       string
+	FeedTitle  string
+	FeedURL    string
+	Categories []string
+	Enclosures []string
+	Date       time.Time
+	// TitleInSummary is set if the summary repeats the title
+	TitleInSummary bool
+}
+
+var noteTemplateFuncs = template.FuncMap{
+	"truncate": truncateText,
+	"join": func(sep string, list []string) string {
+		return strings.Join(list, sep)
+	},
+}
+
+// noteTemplateCache holds parsed templates by their source text.
+var noteTemplateCache = struct {
+	sync.Mutex
+	templates map[string]*template.Template
+}{templates: make(map[string]*template.Template)}
+
+// resolveNoteTemplate returns the text of a builtin template if tmpl is the
+// name of one, otherwise tmpl itself.
+func resolveNoteTemplate(tmpl string) string {
+	if builtin, ok := builtinNoteTemplates[strings.TrimSpace(tmpl)]; ok {
+		return builtin
+	}
+	return tmpl
+}
+
+func parseNoteTemplate(tmpl string) (*template.Template, error) {
+	text := resolveNoteTemplate(tmpl)
+	noteTemplateCache.Lock()
+	defer noteTemplateCache.Unlock()
+	if t, ok := noteTemplateCache.templates[text]; ok {
+		return t, nil
+	}
+	t, err := template.New("note").Funcs(noteTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	noteTemplateCache.templates[text] = t
+	return t, nil
+}
+
+// validateNoteTemplate parses tmpl and renders it with sample data, which
+// also catches unknown fields.
+func validateNoteTemplate(tmpl string) error {
+	t, err := parseNoteTemplate(tmpl)
+	if err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	sample := noteTemplateData{
+		Title:      "Title",
+		Summary:    "Summary",
+		Link:       "https://example.com/post",
+		Categories: []string{"category"},
+		Enclosures: []string{"https://example.com/image.jpg"},
+		Date:       time.Now(),
+	}
+	if err := t.Execute(&strings.Builder{}, sample); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// reNitterTelegram matches nitter and telegram feeds and items, they get the
+// nitter template unless the feed has its own.
+var reNitterTelegram = regexp.MustCompile(`nitter|telegram`)
+
+// noteTemplateFor returns the template of a feed, or the global one.
+func noteTemplateFor(feedItem feedStruct, feedPost *gofeed.Item) string {
+	if feedItem.Settings.Template != "" {
+		return feedItem.Settings.Template
+	}
+	if reNitterTelegram.MatchString(feedItem.URL) || reNitterTelegram.MatchString(feedPost.Link) {
+		return "nitter"
+	}
+	return noteTemplate
+}
+
+// renderNoteTemplate renders the note content of a feed item with a Go
+// text/template or the name of a builtin template.
+func renderNoteTemplate(tmpl string, feedItem feedStruct, feedPost *gofeed.Item) (string, error) {
+	t, err := parseNoteTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	summary := htmlToPlainText(feedPost.Description)
+	data := noteTemplateData{
+		Title:          feedPost.Title,
+		Summary:        summary,
+		Link:           feedPost.Link,
+		FeedTitle:      feedItem.Title,
+		FeedURL:        feedItem.URL,
+		Categories:     feedPost.Categories,
+		TitleInSummary: titleInSummary(feedPost.Title, summary),
+	}
+	if feedPost.Content != "" {
+		data.Content = htmlToPlainText(feedPost.Content)
+	}
+	if feedPost.Author != nil {
+		data.Author = feedPost.Author.Name
+	} else if len(feedPost.Authors) > 0 {
+		data.Author = feedPost.Authors[0].Name
+	}
+	if itemTime, err := parseFeedDate(feedPost); err == nil {
+		data.Date = *itemTime
+	}
+	for _, enclosure := range feedPost.Enclosures {
+		data.Enclosures = append(data.Enclosures, enclosure.URL)
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	// empty fields leave blank lines behind
+	return strings.TrimSpace(reMultiNewline.ReplaceAllString(sb.String(), "\n\n")), nil
+}
+
+// titleInSummary reports whether the summary starts with the (possibly
+// shortened) title.
+func titleInSummary(title, summary string) bool {
+	title = strings.TrimSpace(title)
+	title = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(title, "…"), "..."))
+	return title != "" && strings.HasPrefix(strings.TrimSpace(summary), title)
+}
+
+// truncateText shortens s to at most n characters.
+func truncateText(n int, s string) string {
+	runes := []rune(s)
+	if n < 1 || len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// previewNoteTemplate is the -preview command. It prints the notes of the
+// newest items of a feed, with settings overridden by key=value args.
+func (a *Atomstr) previewNoteTemplate(feedURL string, args []string) error {
+	feedItem := *a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		feedItem.URL = feedURL
+	}
+	if err := parseFeedSettingArgs(&feedItem.Settings, args); err != nil {
+		return err
+	}
+	feed, err := fetchSourceDocument(feedItem)
+	if err != nil {
+		return fmt.Errorf("can't fetch feed: %w", err)
+	}
+	if feedItem.Title == "" {
+		feedItem.Title = feed.Title
+	}
+
+	rules, err := a.dbGetFeedFilters(feedItem.SourcePub)
+	if err != nil {
+		return err
+	}
+	for i, feedPost := range feed.Items {
+		if i == 3 {
+			break
+		}
+		if ok, reason := a.filterAndExpandPost(feedItem, feedPost, rules); !ok {
+			fmt.Printf("--- %s\n(filtered: %s)\n\n", feedPost.Title, reason)
+			continue
+		}
+		text, err := renderNoteTemplate(noteTemplateFor(feedItem, feedPost), feedItem, feedPost)
+		if err != nil {
+			return fmt.Errorf("can't render template: %w", err)
+		}
+		fmt.Printf("--- %s\n%s\n\n", feedPost.Title, text)
+	}
+	return nil
+}
+
+// checkFeedTemplates logs the feeds whose stored template doesn't work, they
+// fall back to the default template.
+func (a *Atomstr) checkFeedTemplates() {
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	for _, feedItem := range *feeds {
+		if feedItem.Settings.Template == "" {
+			continue
+		}
+		if err := validateNoteTemplate(feedItem.Settings.Template); err != nil {
+			log.Printf("[WARN] Template of %s: %v", feedItem.URL, err)
+		}
+	}
+}
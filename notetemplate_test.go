@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestValidateNoteTemplate(t *testing.T) {
+	valid := []string{"default", "nitter", "telegram", "no-title", "title-link",
+		"{{.Title}}: {{truncate 20 .Summary}} {{join \", \" .Categories}}"}
+	for _, tmpl := range valid {
+		if err := validateNoteTemplate(tmpl); err != nil {
+			t.Errorf("validateNoteTemplate(%q): %v", tmpl, err)
+		}
+	}
+	invalid := []string{"{{.Title", "{{.Unknown}}", "{{.Description}}", "{{unknown .Title}}", "{{truncate .Title}}"}
+	for _, tmpl := range invalid {
+		if err := validateNoteTemplate(tmpl); err == nil {
+			t.Errorf("validateNoteTemplate(%q) didn't fail", tmpl)
+		}
+	}
+}
+
+func TestRenderNoteTemplate(t *testing.T) {
+	feedItem := feedStruct{Title: "Feed", URL: "https://example.com/feed"}
+	post := &gofeed.Item{
+		Title:       "Hello world",
+		Description: "<p>Hello world, this is the post.</p>",
+		Link:        "https://example.com/post",
+		Enclosures:  []*gofeed.Enclosure{{URL: "https://example.com/image.jpg"}},
+		Author:      &gofeed.Person{Name: "Alice"},
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"default", "Hello world\n\nHello world, this is the post.\n\nhttps://example.com/image.jpg\n\nhttps://example.com/post"},
+		{"nitter", "Hello world, this is the post.\n\nhttps://example.com/image.jpg\n\nhttps://example.com/post"},
+		{"title-link", "Hello world\n\nhttps://example.com/post"},
+		{"{{.Author}} in {{.FeedTitle}}: {{truncate 12 .Summary}}", "Alice in Feed: Hello world…"},
+		// empty fields don't leave blank lines behind
+		{"{{.Title}}\n\n{{.Content}}\n\n{{.Link}}", "Hello world\n\nhttps://example.com/post"},
+	}
+	for _, tt := range tests {
+		got, err := renderNoteTemplate(tt.tmpl, feedItem, post)
+		if err != nil {
+			t.Errorf("renderNoteTemplate(%q): %v", tt.tmpl, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("renderNoteTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestNoteTemplateFor(t *testing.T) {
+	defer func(tmpl string) { noteTemplate = tmpl }(noteTemplate)
+	noteTemplate = "default"
+	post := &gofeed.Item{Title: "Post", Description: "The post", Link: "https://example.com/post"}
+	tests := []struct {
+		name     string
+		feedURL  string
+		link     string
+		template string
+		want     string
+	}{
+		{"blog", "https://example.com/feed", "https://example.com/post", "", "Post\n\nThe post\n\nhttps://example.com/post"},
+		{"nitter feed", "https://nitter.net/user/rss", "https://example.com/post", "", "The post\n\nhttps://example.com/post"},
+		{"telegram item", "https://rss.example.com/channel", "https://t.me/s/telegram/1", "", "The post\n\nhttps://t.me/s/telegram/1"},
+		{"own template", "https://nitter.net/user/rss", "https://example.com/post", "title-link", "Post\n\nhttps://example.com/post"},
+	}
+	for _, tt := range tests {
+		feedItem := feedStruct{URL: tt.feedURL, Settings: feedSettings{Template: tt.template}}
+		post.Link = tt.link
+		if got := buildNoteEvent(feedItem, post, 0).Content; got != tt.want {
+			t.Errorf("%s: note = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTitleInSummary(t *testing.T) {
+	tests := []struct {
+		title, summary string
+		want           bool
+	}{
+		{"Hello world", "Hello world and more", true},
+		{"Hello wor…", "Hello world and more", true},
+		{"Hello wor...", " Hello world and more", true},
+		{"Hello world", "Something else", false},
+		{"", "Something else", false},
+	}
+	for _, tt := range tests {
+		if got := titleInSummary(tt.title, tt.summary); got != tt.want {
+			t.Errorf("titleInSummary(%q, %q) = %v, want %v", tt.title, tt.summary, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		n    int
+		s    string
+		want string
+	}{
+		{10, "short", "short"},
+		{5, "Hello world", "Hell…"},
+		{6, "Hello world", "Hello…"},
+		{3, "äöüß", "äö…"},
+		{0, "Hello", "Hello"},
+	}
+	for _, tt := range tests {
+		if got := truncateText(tt.n, tt.s); got != tt.want {
+			t.Errorf("truncateText(%d, %q) = %q, want %q", tt.n, tt.s, got, tt.want)
+		}
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPreviewNoteTemplate(t *testing.T) {
+	allowTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Preview</title><link>https://example.org/</link>
+			<item><title>First</title><description>First post</description><link>https://example.org/1</link></item>
+			<item><title>Second</title><description>Second post</description><link>https://example.org/2</link></item>
+			<item><title>Third</title><description>Third post</description><link>https://example.org/3</link></item>
+			<item><title>Fourth</title><description>Fourth post</description><link>https://example.org/4</link></item>
+			</channel></rss>`)
+	}))
+	defer server.Close()
+
+	a := newTestAtomstr(t)
+	var err error
+	out := captureStdout(t, func() {
+		err = a.previewNoteTemplate(server.URL, []string{"template={{.FeedTitle}}: {{.Summary}}"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- First\nPreview: First post\n\n--- Second\nPreview: Second post\n\n--- Third\nPreview: Third post\n\n"
+	if out != want {
+		t.Errorf("preview printed %q, want %q", out, want)
+	}
+
+	if err := a.previewNoteTemplate(server.URL, []string{"template={{.Unknown}}"}); err == nil {
+		t.Error("preview with an invalid template didn't fail")
+	}
+	if err := a.previewNoteTemplate(server.URL+"/missing", []string{"template"}); err == nil {
+		t.Error("preview with an invalid setting didn't fail")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// opmlDocument is an OPML 2.0 subscription list. Outlines can be nested
+// into categories.
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    struct {
+		Title       string `xml:"title,omitempty"`
+		DateCreated string `xml:"dateCreated,omitempty"`
+	} `xml:"head"`
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Npub     string        `xml:"npub,attr,omitempty"` // custom attribute, the nostr profile of the feed
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// opmlImportResult sums up an OPML import.
+type opmlImportResult struct {
+	Total   int
+	Added   int
+	Skipped int
+	Failed  map[string]string // feed URL -> error
+}
+
+func (r opmlImportResult) String() string {
+	return fmt.Sprintf("%d feeds: %d added, %d skipped, %d failed", r.Total, r.Added, r.Skipped, len(r.Failed))
+}
+
+// parseOPML returns the feed URLs of all outlines, including those in
+// categories. Duplicates are dropped.
+func parseOPML(r io.Reader) ([]string, error) {
+	var doc opmlDocument
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("can't parse OPML: %w", err)
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	var walk func([]opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, outline := range outlines {
+			feedURL := strings.TrimSpace(outline.XMLURL)
+			if feedURL != "" && !seen[feedURL] {
+				seen[feedURL] = true
+				urls = append(urls, feedURL)
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(doc.Body.Outlines)
+	return urls, nil
+}
+
+// importOPML adds all feeds of an OPML document with addSource. Feeds that
+// already exist are skipped. progress is called before each feed.
+func (a *Atomstr) importOPML(r io.Reader, progress func(done int, result opmlImportResult)) (opmlImportResult, error) {
+	result := opmlImportResult{Failed: make(map[string]string)}
+	urls, err := parseOPML(r)
+	if err != nil {
+		return result, err
+	}
+	result.Total = len(urls)
+
+	for i, feedURL := range urls {
+		if progress != nil {
+			progress(i, result)
+		}
+		if a.dbFindFeed(feedURL).URL != "" {
+			log.Printf("[DEBUG] Skipping existing feed %s", feedURL)
+			result.Skipped++
+			continue
+		}
+		log.Printf("[INFO] Importing feed %d/%d: %s", i+1, len(urls), feedURL)
+		_, err := a.addSource(feedURL, feedSettings{})
+		if errors.Is(err, errFeedExists) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			log.Printf("[WARN] Can't import %s: %v", feedURL, err)
+			result.Failed[feedURL] = err.Error()
+			continue
+		}
+		result.Added++
+	}
+	return result, nil
+}
+
+// exportOPML writes all feeds as an OPML document.
+func (a *Atomstr) exportOPML(w io.Writer) error {
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		return err
+	}
+
+	doc := opmlDocument{Version: "2.0"}
+	doc.Head.Title = "atomstr feeds"
+	doc.Head.DateCreated = time.Now().UTC().Format(time.RFC1123Z)
+	for _, feedItem := range *feeds {
+		title := feedItem.Title
+		if title == "" {
+			title = feedItem.URL
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Text:    title,
+			Title:   title,
+			Type:    "rss",
+			XMLURL:  feedItem.URL,
+			HTMLURL: feedItem.Link,
+			Npub:    feedItem.Npub,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("can't write OPML: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("can't write OPML: %w", err)
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// importOPMLFile is the -import-opml command.
+func (a *Atomstr) importOPMLFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open OPML file: %w", err)
+	}
+	defer f.Close()
+
+	result, err := a.importOPML(f, nil)
+	if err != nil {
+		return err
+	}
+	for feedURL, reason := range result.Failed {
+		fmt.Printf("failed: %s (%s)\n", feedURL, reason)
+	}
+	log.Printf("[INFO] OPML import finished: %s", result)
+	return nil
+}
+
+// exportOPMLFile is the -export-opml command, "-" writes to stdout.
+func (a *Atomstr) exportOPMLFile(path string) error {
+	if path == "-" {
+		return a.exportOPML(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("can't create OPML file: %w", err)
+	}
+	if err := a.exportOPML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
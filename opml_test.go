@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOPML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="News" title="News">
+      <outline type="rss" text="Example" xmlUrl="https://example.org/feed.xml" htmlUrl="https://example.org/"/>
+      <outline text="Nested">
+        <outline type="rss" text="Blog" xmlUrl=" https://blog.example.org/rss "/>
+      </outline>
+    </outline>
+    <outline type="rss" text="Example again" xmlUrl="https://example.org/feed.xml"/>
+    <outline text="Just a link" htmlUrl="https://example.com/"/>
+  </body>
+</opml>`
+	urls, err := parseOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.org/feed.xml", "https://blog.example.org/rss"}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("parseOPML() = %v, want %v", urls, want)
+	}
+
+	if _, err := parseOPML(strings.NewReader("not xml")); err == nil {
+		t.Errorf("parseOPML() of invalid document didn't fail")
+	}
+}
+
+func TestOPMLRoundTrip(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedURLs := []string{"https://example.org/feed.xml", "https://blog.example.org/rss?lang=en&page=1"}
+	for _, feedURL := range feedURLs {
+		addTestFeed(t, a, feedURL)
+	}
+
+	var buf bytes.Buffer
+	if err := a.exportOPML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `npub="npub1`) {
+		t.Errorf("export has no npub attributes:\n%s", buf.String())
+	}
+	urls, err := parseOPML(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(urls, " ") != strings.Join(feedURLs, " ") {
+		t.Errorf("exported %v, want %v", urls, feedURLs)
+	}
+
+	// importing the export again adds nothing
+	result, err := a.importOPML(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || result.Skipped != 2 || result.Added != 0 || len(result.Failed) != 0 {
+		t.Errorf("import of the export: %s", result)
+	}
+}
+
+func TestImportOPMLJobExpires(t *testing.T) {
+	defer func(retention time.Duration) { jobRetention = retention }(jobRetention)
+	jobRetention = 10 * time.Millisecond
+	a := newTestAtomstr(t)
+	addTestFeed(t, a, "https://example.org/feed.xml")
+
+	bodies := map[string]string{
+		"completed": `<opml version="1.0"><body><outline type="rss" xmlUrl="https://example.org/feed.xml"/></body></opml>`,
+		"failed":    `not OPML`,
+	}
+	for status, body := range bodies {
+		job := &asyncJob{ID: generateJobID(), Status: "processing"}
+		jobsMutex.Lock()
+		jobs[job.ID] = job
+		jobsMutex.Unlock()
+
+		a.importOPMLAsync(job, []byte(body))
+		jobsMutex.RLock()
+		got := job.Status
+		jobsMutex.RUnlock()
+		if got != status {
+			t.Errorf("import job is %s, want %s", got, status)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			jobsMutex.RLock()
+			_, exists := jobs[job.ID]
+			jobsMutex.RUnlock()
+			if !exists {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%s import job wasn't removed", status)
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
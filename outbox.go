@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// outboxBackoff returns the delay before the next delivery attempt of an
+// event that has already failed attempts times.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryInterval
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+// outboxRejectedPrefixes are the NIP-01 OK message prefixes of events a
+// relay will never accept, retrying them is pointless.
+var outboxRejectedPrefixes = []string{"blocked:", "invalid:", "restricted:", "pow:"}
+
+// outboxDone reports whether a delivery is finished: the event was
+// accepted, already known or rejected for good.
+func outboxDone(res publishResult) bool {
+	// relays answer "duplicate:" for events they already have
+	if res.OK || strings.Contains(res.Message, "duplicate:") {
+		return true
+	}
+	for _, prefix := range outboxRejectedPrefixes {
+		if strings.Contains(res.Message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dbEnqueueOutbox stores a signed event once per target relay. Rows are only
+// removed after the relay accepted the event.
+func (a *Atomstr) dbEnqueueOutbox(ev nostr.Event, relays []string) error {
+	eventJSON, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("can't encode event: %w", err)
+	}
+	now := time.Now()
+	// the caller delivers right away, so the background publisher only
+	// picks the event up if that first attempt never finished
+	nextAttempt := now.Add(outboxRetryInterval)
+	for _, relayURL := range relays {
+		_, err := a.db.Exec(`INSERT OR IGNORE INTO outbox (event_id, relay, event, attempts, next_attempt_at, last_error, created_at) VALUES (?, ?, ?, 0, ?, '', ?)`,
+			ev.ID, relayURL, string(eventJSON), nextAttempt, now)
+		if err != nil {
+			return fmt.Errorf("can't add event to outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// deliverOutbox publishes ev to the given relays and updates their outbox
+// rows.
+func (a *Atomstr) deliverOutbox(ev nostr.Event, relays []string) {
+	results := a.pool.publish(ev, relays)
+	a.dbRecordPublishResults(ev.ID, results)
+
+	for _, res := range results {
+		a.dbUpdateOutbox(ev.ID, res)
+	}
+}
+
+// dbUpdateOutbox removes the outbox row of a finished delivery and
+// reschedules a failed one.
+func (a *Atomstr) dbUpdateOutbox(eventID string, res publishResult) {
+	if outboxDone(res) {
+		if !res.OK && !strings.Contains(res.Message, "duplicate:") {
+			log.Printf("[WARN] %s rejected event %s, not retrying: %s", res.RelayURL, eventID, res.Message)
+		}
+		if _, err := a.db.Exec(`DELETE FROM outbox WHERE event_id = ? AND relay = ?`, eventID, res.RelayURL); err != nil {
+			log.Printf("[ERROR] can't remove event from outbox: %v", err)
+		}
+		return
+	}
+	_, err := a.db.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = ?,
+		next_attempt_at = ? WHERE event_id = ? AND relay = ?`,
+		res.Message, time.Now().Add(outboxBackoff(a.dbOutboxAttempts(eventID, res.RelayURL)+1)), eventID, res.RelayURL)
+	if err != nil {
+		log.Printf("[ERROR] can't reschedule outbox event: %v", err)
+	}
+}
+
+// dbExpireOutbox gives up deliveries that failed for longer than
+// OUTBOX_MAX_AGE.
+func (a *Atomstr) dbExpireOutbox() error {
+	res, err := a.db.Exec(`DELETE FROM outbox WHERE created_at < ?`, time.Now().Add(-outboxMaxAge))
+	if err != nil {
+		return fmt.Errorf("can't expire outbox: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[WARN] Gave up %d outbox deliveries older than %v", n, outboxMaxAge)
+	}
+	return nil
+}
+
+func (a *Atomstr) dbOutboxAttempts(eventID, relayURL string) int {
+	var attempts int
+	a.db.QueryRow(`SELECT attempts FROM outbox WHERE event_id = ? AND relay = ?`, eventID, relayURL).Scan(&attempts)
+	return attempts
+}
+
+func (a *Atomstr) dbCountOutbox() (int, error) {
+	var count int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("can't count outbox: %w", err)
+	}
+	return count, nil
+}
+
+// processOutbox retries every outbox delivery that is due.
+func (a *Atomstr) processOutbox() error {
+	if err := a.dbExpireOutbox(); err != nil {
+		return err
+	}
+	rows, err := a.db.Query(`SELECT event_id, relay, event FROM outbox WHERE next_attempt_at <= ? ORDER BY created_at LIMIT 1000`, time.Now())
+	if err != nil {
+		return fmt.Errorf("can't read outbox: %w", err)
+	}
+
+	events := make(map[string]nostr.Event)
+	relays := make(map[string][]string)
+	var order []string
+	for rows.Next() {
+		var eventID, relayURL, eventJSON string
+		if err := rows.Scan(&eventID, &relayURL, &eventJSON); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning outbox failed: %w", err)
+		}
+		if _, ok := events[eventID]; !ok {
+			var ev nostr.Event
+			if err := json.Unmarshal([]byte(eventJSON), &ev); err != nil {
+				log.Printf("[ERROR] can't decode outbox event %s: %v", eventID, err)
+				continue
+			}
+			events[eventID] = ev
+			order = append(order, eventID)
+		}
+		relays[eventID] = append(relays[eventID], relayURL)
+	}
+	rows.Close()
+
+	if len(order) > 0 {
+		log.Printf("[DEBUG] Retrying %d events from outbox", len(order))
+	}
+	for _, eventID := range order {
+		a.deliverOutbox(events[eventID], relays[eventID])
+	}
+	return nil
+}
+
+// outboxPublisher runs in the background and retries failed deliveries.
+func (a *Atomstr) outboxPublisher() {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := a.processOutbox(); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+}
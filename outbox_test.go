@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	defer func(retry, maxBackoff time.Duration) {
+		outboxRetryInterval, outboxMaxBackoff = retry, maxBackoff
+	}(outboxRetryInterval, outboxMaxBackoff)
+	outboxRetryInterval, outboxMaxBackoff = time.Minute, time.Hour
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxDone(t *testing.T) {
+	tests := []struct {
+		res  publishResult
+		want bool
+	}{
+		{publishResult{OK: true}, true},
+		{publishResult{Message: "msg: duplicate: already have this event"}, true},
+		{publishResult{Message: "msg: blocked: you are banned"}, true},
+		{publishResult{Message: "msg: invalid: bad signature"}, true},
+		{publishResult{Message: "msg: restricted: not allowed to write"}, true},
+		{publishResult{Message: "msg: pow: difficulty 20 required"}, true},
+		{publishResult{Message: "msg: rate-limited: slow down"}, false},
+		{publishResult{Message: "msg: error: could not save"}, false},
+		{publishResult{Message: "connect: connection refused"}, false},
+	}
+	for _, tt := range tests {
+		if got := outboxDone(tt.res); got != tt.want {
+			t.Errorf("outboxDone(%+v) = %v, want %v", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestDbUpdateOutbox(t *testing.T) {
+	a := newTestAtomstr(t)
+	ev := nostr.Event{ID: "event", Kind: 1, CreatedAt: nostr.Now()}
+	relays := []string{"wss://ok.example.org", "wss://blocked.example.org", "wss://down.example.org"}
+	if err := a.dbEnqueueOutbox(ev, relays); err != nil {
+		t.Fatal(err)
+	}
+
+	a.dbUpdateOutbox(ev.ID, publishResult{RelayURL: relays[0], OK: true})
+	a.dbUpdateOutbox(ev.ID, publishResult{RelayURL: relays[1], Message: "msg: blocked: no"})
+	a.dbUpdateOutbox(ev.ID, publishResult{RelayURL: relays[2], Message: "connect: connection refused"})
+	a.dbUpdateOutbox(ev.ID, publishResult{RelayURL: relays[2], Message: "connect: connection refused"})
+
+	if count, err := a.dbCountOutbox(); err != nil || count != 1 {
+		t.Fatalf("outbox has %d rows (%v), want only the failed relay", count, err)
+	}
+	if attempts := a.dbOutboxAttempts(ev.ID, relays[2]); attempts != 2 {
+		t.Errorf("failed relay has %d attempts, want 2", attempts)
+	}
+	var next time.Time
+	if err := a.db.QueryRow(`SELECT next_attempt_at FROM outbox WHERE relay = ?`, relays[2]).Scan(&next); err != nil {
+		t.Fatal(err)
+	}
+	if wait := time.Until(next); wait < outboxBackoff(2)-time.Minute || wait > outboxBackoff(2) {
+		t.Errorf("next attempt in %v, want %v", wait, outboxBackoff(2))
+	}
+}
+
+func TestDbExpireOutbox(t *testing.T) {
+	a := newTestAtomstr(t)
+	if err := a.dbEnqueueOutbox(nostr.Event{ID: "new"}, []string{"wss://relay.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.dbEnqueueOutbox(nostr.Event{ID: "old"}, []string{"wss://relay.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(`UPDATE outbox SET created_at = ? WHERE event_id = 'old'`, time.Now().Add(-outboxMaxAge-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.dbExpireOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	rows, err := a.db.Query(`SELECT event_id FROM outbox`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID string
+		rows.Scan(&eventID)
+		left = append(left, eventID)
+	}
+	if len(left) != 1 || left[0] != "new" {
+		t.Errorf("outbox has %v, want the new event only", left)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// podcastInfo holds the channel data of a podcast feed (iTunes or podcast
+// namespace) that applies to all episodes.
+type podcastInfo struct {
+	GUID  string // podcast:guid
+	Image string
+}
+
+// parsePodcastInfo returns the podcast data of a feed, or nil if the feed
+// is not a podcast.
+func parsePodcastInfo(feed *gofeed.Feed) *podcastInfo {
+	podcast := feed.Extensions["podcast"]
+	if feed.ITunesExt == nil && podcast == nil {
+		return nil
+	}
+	info := &podcastInfo{GUID: extensionValue(podcast, "guid")}
+	if feed.ITunesExt != nil && feed.ITunesExt.Image != "" {
+		info.Image = feed.ITunesExt.Image
+	} else if feed.Image != nil {
+		info.Image = feed.Image.URL
+	}
+	return info
+}
+
+func extensionValue(elements map[string][]ext.Extension, name string) string {
+	for _, element := range elements[name] {
+		if value := strings.TrimSpace(element.Value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// episodeEnclosure returns the audio or video enclosure of a podcast
+// episode, or nil if the item is not an episode.
+func episodeEnclosure(feedItem feedStruct, feedPost *gofeed.Item) *gofeed.Enclosure {
+	if feedItem.Podcast == nil && feedPost.ITunesExt == nil && feedPost.Extensions["podcast"] == nil {
+		return nil
+	}
+	for _, enclosure := range feedPost.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "audio/") || strings.HasPrefix(enclosure.Type, "video/") {
+			return enclosure
+		}
+	}
+	return nil
+}
+
+// episodeDuration returns the itunes:duration of an episode in seconds. It
+// is given as seconds, MM:SS or HH:MM:SS.
+func episodeDuration(feedPost *gofeed.Item) int {
+	if feedPost.ITunesExt == nil {
+		return 0
+	}
+	seconds := 0
+	for _, part := range strings.Split(strings.TrimSpace(feedPost.ITunesExt.Duration), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// episodeImage returns the episode artwork, falling back to the artwork of
+// the podcast.
+func episodeImage(feedItem feedStruct, feedPost *gofeed.Item) string {
+	if feedPost.ITunesExt != nil && feedPost.ITunesExt.Image != "" {
+		return feedPost.ITunesExt.Image
+	}
+	if feedPost.Image != nil && feedPost.Image.URL != "" {
+		return feedPost.Image.URL
+	}
+	if feedItem.Podcast != nil {
+		return feedItem.Podcast.Image
+	}
+	return ""
+}
+
+// podcastTags returns the tags of a podcast episode: NIP-73 "i" tags for
+// the podcast and episode GUIDs, and links to transcripts and chapters.
+// The enclosure itself is described by an imeta tag, see mediaTags.
+func podcastTags(feedItem feedStruct, feedPost *gofeed.Item) nostr.Tags {
+	if episodeEnclosure(feedItem, feedPost) == nil {
+		return nil
+	}
+	var tags nostr.Tags
+	if feedPost.GUID != "" {
+		tags = append(tags,
+			nostr.Tag{"i", "podcast:item:guid:" + feedPost.GUID, feedPost.Link},
+			nostr.Tag{"k", "podcast:item:guid"})
+	}
+	if feedItem.Podcast != nil && feedItem.Podcast.GUID != "" {
+		tags = append(tags,
+			nostr.Tag{"i", "podcast:guid:" + feedItem.Podcast.GUID, feedItem.URL},
+			nostr.Tag{"k", "podcast:guid"})
+	}
+	podcast := feedPost.Extensions["podcast"]
+	for _, name := range []string{"transcript", "chapters"} {
+		for _, element := range podcast[name] {
+			if link := element.Attrs["url"]; link != "" {
+				tags = append(tags, nostr.Tag{name, link, element.Attrs["type"]})
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testPodcastRSS = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel><title>Podcast</title><link>https://example.com/</link>
+<podcast:guid>917393e3-1b1e-5cef-ace4-edaa54e1f810</podcast:guid>
+<itunes:image href="https://example.com/cover.jpg"/>
+<item>
+	<title>Episode 1</title>
+	<guid isPermaLink="false">episode-1</guid>
+	<link>https://example.com/episodes/1</link>
+	<itunes:duration>1:02:03</itunes:duration>
+	<enclosure url="https://example.com/episode-1.mp3" type="audio/mpeg" length="1234"/>
+	<podcast:transcript url="https://example.com/episode-1.vtt" type="text/vtt"/>
+	<podcast:chapters url="https://example.com/episode-1.json" type="application/json+chapters"/>
+</item>
+<item>
+	<title>Episode 2</title>
+	<guid isPermaLink="false">episode-2</guid>
+	<link>https://example.com/episodes/2</link>
+	<itunes:duration>95</itunes:duration>
+	<itunes:image href="https://example.com/episode-2.jpg"/>
+	<enclosure url="https://example.com/episode-2.mp4" type="video/mp4" length="5678"/>
+</item>
+<item>
+	<title>Show notes</title>
+	<link>https://example.com/notes</link>
+	<enclosure url="https://example.com/notes.jpg" type="image/jpeg" length="100"/>
+</item>
+</channel></rss>`
+
+func parseTestPodcast(t *testing.T) (feedStruct, []*gofeed.Item) {
+	t.Helper()
+	feed, err := gofeed.NewParser().ParseString(testPodcastRSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedItem := feedStruct{URL: "https://example.com/podcast.xml", Podcast: parsePodcastInfo(feed)}
+	return feedItem, feed.Items
+}
+
+func TestParsePodcastInfo(t *testing.T) {
+	feedItem, _ := parseTestPodcast(t)
+	want := &podcastInfo{GUID: "917393e3-1b1e-5cef-ace4-edaa54e1f810", Image: "https://example.com/cover.jpg"}
+	if !reflect.DeepEqual(feedItem.Podcast, want) {
+		t.Errorf("parsePodcastInfo() = %+v, want %+v", feedItem.Podcast, want)
+	}
+
+	feed, err := gofeed.NewParser().ParseString(testRSS("Blog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := parsePodcastInfo(feed); info != nil {
+		t.Errorf("parsePodcastInfo() of a blog = %+v", info)
+	}
+}
+
+func TestEpisodeDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		want     int
+	}{
+		{"95", 95},
+		{"12:34", 754},
+		{"1:02:03", 3723},
+		{" 5:00 ", 300},
+		{"", 0},
+		{"1h 5m", 0},
+		{"-1:00", 0},
+	}
+	for _, tt := range tests {
+		item := &gofeed.Item{ITunesExt: &ext.ITunesItemExtension{Duration: tt.duration}}
+		if got := episodeDuration(item); got != tt.want {
+			t.Errorf("episodeDuration(%q) = %d, want %d", tt.duration, got, tt.want)
+		}
+	}
+	if got := episodeDuration(&gofeed.Item{}); got != 0 {
+		t.Errorf("episodeDuration() without iTunes data = %d", got)
+	}
+}
+
+func TestPodcastTags(t *testing.T) {
+	feedItem, items := parseTestPodcast(t)
+
+	got := podcastTags(feedItem, items[0])
+	want := nostr.Tags{
+		{"i", "podcast:item:guid:episode-1", "https://example.com/episodes/1"},
+		{"k", "podcast:item:guid"},
+		{"i", "podcast:guid:917393e3-1b1e-5cef-ace4-edaa54e1f810", "https://example.com/podcast.xml"},
+		{"k", "podcast:guid"},
+		{"transcript", "https://example.com/episode-1.vtt", "text/vtt"},
+		{"chapters", "https://example.com/episode-1.json", "application/json+chapters"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("podcastTags(episode 1) = %v, want %v", got, want)
+	}
+
+	// items without audio or video are not episodes
+	if got := podcastTags(feedItem, items[2]); got != nil {
+		t.Errorf("podcastTags(show notes) = %v", got)
+	}
+	if got := podcastTags(feedStruct{}, &gofeed.Item{GUID: "post", Enclosures: items[0].Enclosures}); got != nil {
+		t.Errorf("podcastTags() of a blog post = %v", got)
+	}
+}
+
+func TestEpisodeImeta(t *testing.T) {
+	feedItem, items := parseTestPodcast(t)
+	tests := []struct {
+		item *gofeed.Item
+		want nostr.Tag
+	}{
+		{items[0], nostr.Tag{"imeta", "url https://example.com/episode-1.mp3", "m audio/mpeg", "size 1234",
+			"duration 3723", "image https://example.com/cover.jpg"}},
+		{items[1], nostr.Tag{"imeta", "url https://example.com/episode-2.mp4", "m video/mp4", "size 5678",
+			"duration 95", "image https://example.com/episode-2.jpg"}},
+	}
+	for _, tt := range tests {
+		event := buildNoteEvent(feedItem, tt.item, 0)
+		if tag := event.Tags.GetFirst([]string{"imeta"}); tag == nil || !reflect.DeepEqual(*tag, tt.want) {
+			t.Errorf("imeta of %s = %v, want %v", tt.item.Title, tag, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayPool keeps one long-lived connection per relay, shared by all workers.
+// Dropped connections are re-established on the next publish.
+type relayPool struct {
+	mu       sync.Mutex
+	relays   map[string]*poolRelay
+	onNotice func(relayURL, notice string)
+}
+
+type poolRelay struct {
+	mu    sync.Mutex
+	url   string
+	relay *nostr.Relay
+}
+
+// publishResult is the outcome of sending one event to one relay. OK is only
+// true if the relay answered with an OK message accepting the event.
+type publishResult struct {
+	RelayURL string
+	OK       bool
+	Message  string
+}
+
+func newRelayPool(onNotice func(relayURL, notice string)) *relayPool {
+	return &relayPool{
+		relays:   make(map[string]*poolRelay),
+		onNotice: onNotice,
+	}
+}
+
+// ensureRelay returns a connected relay for url, connecting or reconnecting
+// as needed.
+func (p *relayPool) ensureRelay(ctx context.Context, url string) (*nostr.Relay, error) {
+	nm := nostr.NormalizeURL(url)
+
+	p.mu.Lock()
+	pr, ok := p.relays[nm]
+	if !ok {
+		pr = &poolRelay{url: nm}
+		p.relays[nm] = pr
+	}
+	p.mu.Unlock()
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.relay != nil && pr.relay.IsConnected() {
+		return pr.relay, nil
+	}
+	if pr.relay != nil {
+		log.Printf("[DEBUG] Reconnecting to %s", nm)
+	}
+
+	relay, err := nostr.RelayConnect(ctx, nm, nostr.WithNoticeHandler(func(notice string) {
+		log.Printf("[DEBUG] NOTICE from %s: %s", nm, notice)
+		if p.onNotice != nil {
+			p.onNotice(nm, notice)
+		}
+	}))
+	if err != nil {
+		return nil, err
+	}
+	pr.relay = relay
+	return relay, nil
+}
+
+// publish sends ev to all given relays in parallel and waits for their answers.
+func (p *relayPool) publish(ev nostr.Event, relays []string) []publishResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results := make([]publishResult, len(relays))
+	var wg sync.WaitGroup
+	for i, relayURL := range relays {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i] = publishResult{RelayURL: u}
+			relay, err := p.ensureRelay(ctx, u)
+			if err != nil {
+				log.Println("[ERROR]", u, err)
+				results[i].Message = fmt.Sprintf("connect: %v", err)
+				return
+			}
+			if err := relay.Publish(ctx, ev); err != nil {
+				log.Println("[WARN]", u, err)
+				results[i].Message = err.Error()
+				return
+			}
+			log.Printf("[DEBUG] Event published to %s\n", u)
+			results[i].OK = true
+		}(i, relayURL)
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *relayPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pr := range p.relays {
+		pr.mu.Lock()
+		if pr.relay != nil {
+			pr.relay.Close()
+		}
+		pr.mu.Unlock()
+	}
+}
+
+// dbRecordPublishResults stores which relays accepted or rejected an event.
+func (a *Atomstr) dbRecordPublishResults(eventID string, results []publishResult) {
+	now := time.Now()
+	for _, res := range results {
+		_, err := a.db.Exec(`INSERT INTO publish_results (event_id, relay, type, ok, message, created_at) VALUES (?, ?, 'ok', ?, ?, ?)`,
+			eventID, res.RelayURL, res.OK, res.Message, now)
+		if err != nil {
+			log.Printf("[ERROR] can't record publish result: %v", err)
+		}
+	}
+}
+
+// dbRecordRelayNotice stores a NOTICE message sent by a relay. Notices are
+// not bound to an event, so event_id stays empty.
+func (a *Atomstr) dbRecordRelayNotice(relayURL, notice string) {
+	_, err := a.db.Exec(`INSERT INTO publish_results (event_id, relay, type, ok, message, created_at) VALUES ('', ?, 'notice', 0, ?, ?)`,
+		relayURL, notice, time.Now())
+	if err != nil {
+		log.Printf("[ERROR] can't record relay notice: %v", err)
+	}
+}
+
+func (a *Atomstr) dbPrunePublishResults(maxAge time.Duration) error {
+	_, err := a.db.Exec(`DELETE FROM publish_results WHERE created_at < ?`, time.Now().Add(-maxAge))
+	if err != nil {
+		return fmt.Errorf("can't prune publish results: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testRelay runs a relay that greets with a NOTICE and answers events with
+// accept. It returns the relay URL and the number of connections so far.
+func testRelay(t *testing.T, accept func(ev nostr.Event) (bool, string)) (string, *int64) {
+	t.Helper()
+	var connections int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		atomic.AddInt64(&connections, 1)
+		ctx := r.Context()
+		conn.Write(ctx, websocket.MessageText, []byte(`["NOTICE","welcome"]`))
+		for {
+			_, msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var envelope []json.RawMessage
+			var label string
+			if json.Unmarshal(msg, &envelope) != nil || len(envelope) < 2 || json.Unmarshal(envelope[0], &label) != nil || label != "EVENT" {
+				continue
+			}
+			var ev nostr.Event
+			if json.Unmarshal(envelope[1], &ev) != nil {
+				continue
+			}
+			ok, reason := accept(ev)
+			answer, _ := json.Marshal([]any{"OK", ev.ID, ok, reason})
+			conn.Write(ctx, websocket.MessageText, answer)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), &connections
+}
+
+func testEvent(content string) nostr.Event {
+	ev := nostr.Event{CreatedAt: nostr.Now(), Kind: nostr.KindTextNote, Content: content}
+	ev.Sign(nostr.GeneratePrivateKey())
+	return ev
+}
+
+func TestRelayPoolPublish(t *testing.T) {
+	a := newTestAtomstr(t)
+	a.pool = newRelayPool(a.dbRecordRelayNotice)
+	defer a.pool.close()
+
+	accepting, connections := testRelay(t, func(nostr.Event) (bool, string) { return true, "" })
+	rejecting, _ := testRelay(t, func(nostr.Event) (bool, string) { return false, "blocked: no bots" })
+	unreachable := "ws://127.0.0.1:1"
+
+	ev := testEvent("first")
+	results := a.pool.publish(ev, []string{accepting, rejecting, unreachable})
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if !results[0].OK {
+		t.Errorf("accepting relay: %+v", results[0])
+	}
+	if results[1].OK || !strings.Contains(results[1].Message, "no bots") {
+		t.Errorf("rejecting relay: %+v", results[1])
+	}
+	if results[2].OK || !strings.HasPrefix(results[2].Message, "connect:") {
+		t.Errorf("unreachable relay: %+v", results[2])
+	}
+
+	a.dbRecordPublishResults(ev.ID, results)
+	var accepted, rejected int
+	if err := a.db.QueryRow(`SELECT COALESCE(SUM(ok), 0), COALESCE(SUM(1 - ok), 0) FROM publish_results WHERE event_id = ? AND type = 'ok'`, ev.ID).Scan(&accepted, &rejected); err != nil {
+		t.Fatal(err)
+	}
+	if accepted != 1 || rejected != 2 {
+		t.Errorf("recorded %d accepted and %d rejected, want 1 and 2", accepted, rejected)
+	}
+
+	// the connection is kept for the next event
+	if res := a.pool.publish(testEvent("second"), []string{accepting}); !res[0].OK {
+		t.Errorf("second publish: %+v", res[0])
+	}
+	if n := atomic.LoadInt64(connections); n != 1 {
+		t.Errorf("relay was connected %d times, want 1", n)
+	}
+
+	var notices int
+	for i := 0; i < 50 && notices < 2; i++ {
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM publish_results WHERE type = 'notice' AND message = 'welcome'`).Scan(&notices); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if notices != 2 {
+		t.Errorf("recorded %d notices, want one of each relay", notices)
+	}
+}
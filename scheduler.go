@@ -0,0 +1,322 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// nextFetch is when a feed is fetched next and the polling interval that
+// led to it, kept so a 304 response can reuse it.
+type nextFetch struct {
+	At       time.Time
+	Interval time.Duration
+}
+
+func nextFetchAfter(at time.Time, interval time.Duration) nextFetch {
+	return nextFetch{At: at, Interval: interval}
+}
+
+// scheduleNextFetch decides when a successfully fetched feed is due again.
+// A fetch_interval setting is used as is, otherwise the interval adapts to
+// how often the feed posts, feeds delivered by WebSub are only polled as a
+// safety net. Servers asking for a longer cache lifetime (HTTP
+// caching headers or RSS ttl) are honoured up to maxFetchInterval, and the
+// fetch is moved out of the RSS skipHours and skipDays.
+func scheduleNextFetch(feedItem feedStruct, items []*gofeed.Item, maxAge time.Duration) nextFetch {
+	var interval time.Duration
+	switch {
+	case feedItem.Settings.FetchInterval > 0:
+		interval = feedItem.Settings.FetchInterval
+	case feedItem.pushActive():
+		interval = websubPollInterval
+	case items != nil:
+		interval = adaptiveInterval(items)
+	case feedItem.PollInterval > 0:
+		interval = feedItem.PollInterval
+	default:
+		interval = fetchInterval
+	}
+
+	wait := interval
+	maxAge = max(maxAge, feedItem.Hints.TTL)
+	if maxAge > wait {
+		wait = min(maxAge, max(maxFetchInterval, interval))
+	}
+	at := feedItem.Hints.nextAllowed(time.Now().Add(wait))
+	return nextFetch{At: at, Interval: interval}
+}
+
+// adaptiveInterval derives a polling interval from the average gap between
+// the newest items: half the gap, clamped to [fetchInterval, maxFetchInterval].
+func adaptiveInterval(items []*gofeed.Item) time.Duration {
+	var times []time.Time
+	for _, item := range items {
+		if itemTime, err := parseFeedDate(item); err == nil {
+			times = append(times, *itemTime)
+		}
+	}
+	if len(times) < 2 {
+		return fetchInterval
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+	if len(times) > 10 {
+		times = times[:10]
+	}
+
+	// count the silence since the newest item, a feed that went quiet
+	// posts less often than its history suggests
+	newest := times[0]
+	if now := time.Now(); newest.Before(now) {
+		newest = now
+	}
+
+	avgGap := newest.Sub(times[len(times)-1]) / time.Duration(len(times)-1)
+	interval := avgGap / 2
+	if interval < fetchInterval {
+		interval = fetchInterval
+	}
+	if interval > maxFetchInterval && maxFetchInterval > fetchInterval {
+		interval = maxFetchInterval
+	}
+	return interval
+}
+
+// queuedFeed is a feed waiting in the scheduler queue.
+type queuedFeed struct {
+	URL       string
+	NextFetch time.Time
+	index     int
+}
+
+// feedQueue is a priority queue of feeds ordered by next fetch time.
+type feedQueue []*queuedFeed
+
+func (q feedQueue) Len() int           { return len(q) }
+func (q feedQueue) Less(i, j int) bool { return q[i].NextFetch.Before(q[j].NextFetch) }
+func (q feedQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *feedQueue) Push(x any) {
+	item := x.(*queuedFeed)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *feedQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
+
+// scheduler hands due feeds to the scrape workers. Feeds being fetched are
+// not in the queue; the worker schedules them again when it is done. known
+// holds the queued and the in-flight feeds.
+type scheduler struct {
+	mu     sync.Mutex
+	queue  feedQueue
+	queued map[string]*queuedFeed
+	known  map[string]bool
+	wake   chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		queued: make(map[string]*queuedFeed),
+		known:  make(map[string]bool),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// schedule adds a feed to the queue or moves it to a new fetch time.
+func (s *scheduler) schedule(feedURL string, at time.Time) {
+	s.mu.Lock()
+	s.known[feedURL] = true
+	if item, ok := s.queued[feedURL]; ok {
+		item.NextFetch = at
+		heap.Fix(&s.queue, item.index)
+	} else {
+		item := &queuedFeed{URL: feedURL, NextFetch: at}
+		heap.Push(&s.queue, item)
+		s.queued[feedURL] = item
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) remove(feedURL string) {
+	s.mu.Lock()
+	if item, ok := s.queued[feedURL]; ok {
+		heap.Remove(&s.queue, item.index)
+		delete(s.queued, feedURL)
+	}
+	delete(s.known, feedURL)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// isKnown reports whether the feed is queued or being fetched.
+func (s *scheduler) isKnown(feedURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.known[feedURL]
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until a feed is due and removes it from the queue.
+func (s *scheduler) next() string {
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].NextFetch)
+			if wait <= 0 {
+				item := heap.Pop(&s.queue).(*queuedFeed)
+				delete(s.queued, item.URL)
+				s.mu.Unlock()
+				return item.URL
+			}
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// feedLocks keeps a feed from being processed by a scrape worker and a
+// WebSub push at the same time.
+type feedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks the feed with the source key feedPub and returns the function
+// that unlocks it.
+func (l *feedLocks) lock(feedPub string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	feedLock := l.locks[feedPub]
+	if feedLock == nil {
+		feedLock = &sync.Mutex{}
+		l.locks[feedPub] = feedLock
+	}
+	l.mu.Unlock()
+	feedLock.Lock()
+	return feedLock.Unlock
+}
+
+// runScheduler loads all feeds into the scheduler and keeps the scrape
+// workers busy with due feeds. It never returns.
+func (a *Atomstr) runScheduler() {
+	a.syncScheduler()
+
+	stats := a.stats
+	go a.schedulerMaintenance(stats)
+
+	ch := make(chan feedStruct)
+	wg := sync.WaitGroup{}
+	for t := 0; t < maxWorkers; t++ {
+		wg.Add(1)
+		go a.processFeedURL(ch, &wg, stats)
+	}
+
+	for {
+		feedURL := a.scheduler.next()
+		feedItem := a.dbGetFeed(feedURL)
+		if feedItem.URL == "" {
+			a.scheduler.remove(feedURL) // deleted in the meantime
+			continue
+		}
+		ch <- *feedItem
+	}
+}
+
+// syncScheduler queues all feeds the scheduler doesn't know yet, e.g. feeds
+// added with the CLI while the daemon is running.
+func (a *Atomstr) syncScheduler() {
+	feeds, err := a.dbGetAllFeeds()
+	if err != nil {
+		log.Printf("[ERROR] failed to get feeds: %v", err)
+		return
+	}
+	if len(*feeds) == 0 {
+		log.Println("[WARN] No feeds found")
+	}
+	now := time.Now()
+	added := 0
+	for _, feedItem := range *feeds {
+		if a.scheduler.isKnown(feedItem.URL) {
+			continue
+		}
+		at := now
+		if feedItem.NextFetchAt != nil && feedItem.NextFetchAt.After(now) {
+			at = *feedItem.NextFetchAt
+		}
+		a.scheduler.schedule(feedItem.URL, at)
+		added++
+	}
+	if added > 0 {
+		log.Printf("[DEBUG] Scheduled %d feeds", added)
+	}
+}
+
+// schedulerMaintenance logs and resets the scrape statistics every
+// fetchInterval, picks up new feeds and prunes old bookkeeping data.
+func (a *Atomstr) schedulerMaintenance(stats *scrapeStats) {
+	ticker := time.NewTicker(fetchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		log.Printf("[INFO] Scrape summary for the last %v: %d feeds (%d cached, %d errors, %d skipped), %d posts published, %d filtered",
+			fetchInterval, atomic.SwapInt64(&stats.feedsProcessed, 0), atomic.SwapInt64(&stats.feedsCached, 0),
+			atomic.SwapInt64(&stats.feedsErrored, 0), atomic.SwapInt64(&stats.feedsSkipped, 0), atomic.SwapInt64(&stats.postsPublished, 0),
+			atomic.SwapInt64(&stats.postsFiltered, 0))
+
+		a.syncScheduler()
+
+		if err := a.dbPrunePublishedPosts(publishedRetention); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if err := a.dbPrunePublishResults(publishedRetention); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if err := a.dbPruneArticleCache(publishedRetention); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+}
+
+// scheduleNewFeed schedules the first regular fetch of a newly added feed.
+func (a *Atomstr) scheduleNewFeed(feedItem *feedStruct) {
+	next := scheduleNextFetch(*feedItem, feedItem.Posts, 0)
+	if err := a.dbUpdateFeedSchedule(feedItem.URL, next.At, next.Interval); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	if a.scheduler != nil {
+		a.scheduler.schedule(feedItem.URL, next.At)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// itemsEvery returns n items published every gap, the newest at newest.
+func itemsEvery(n int, gap time.Duration, newest time.Time) []*gofeed.Item {
+	var items []*gofeed.Item
+	for i := 0; i < n; i++ {
+		published := newest.Add(-time.Duration(i) * gap)
+		items = append(items, &gofeed.Item{PublishedParsed: &published})
+	}
+	return items
+}
+
+func setTestFetchIntervals(t *testing.T) {
+	t.Helper()
+	interval, maxInterval := fetchInterval, maxFetchInterval
+	t.Cleanup(func() { fetchInterval, maxFetchInterval = interval, maxInterval })
+	fetchInterval, maxFetchInterval = 15*time.Minute, 4*time.Hour
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	setTestFetchIntervals(t)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		items []*gofeed.Item
+		want  time.Duration
+	}{
+		{"no items", nil, 15 * time.Minute},
+		{"single item", itemsEvery(1, time.Hour, now), 15 * time.Minute},
+		{"hourly", itemsEvery(5, time.Hour, now), 30 * time.Minute},
+		{"frequent", itemsEvery(5, 5*time.Minute, now), 15 * time.Minute},
+		{"daily", itemsEvery(5, 24*time.Hour, now), 4 * time.Hour},
+		{"gone quiet", itemsEvery(3, time.Hour, now.Add(-10*time.Hour)), 3 * time.Hour},
+		{"newest ten", append(itemsEvery(10, time.Hour, now), itemsEvery(5, 24*time.Hour, now.Add(-30*24*time.Hour))...), 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := adaptiveInterval(tt.items).Round(time.Minute); got != tt.want {
+				t.Errorf("adaptiveInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextFetch(t *testing.T) {
+	setTestFetchIntervals(t)
+	hourly := itemsEvery(5, time.Hour, time.Now())
+
+	tests := []struct {
+		name         string
+		feedItem     feedStruct
+		items        []*gofeed.Item
+		maxAge       time.Duration
+		wantWait     time.Duration
+		wantInterval time.Duration
+	}{
+		{"adaptive", feedStruct{}, hourly, 0, 30 * time.Minute, 30 * time.Minute},
+		{"fetch_interval setting", feedStruct{Settings: feedSettings{FetchInterval: time.Hour}}, hourly, 0, time.Hour, time.Hour},
+		{"not modified", feedStruct{PollInterval: 2 * time.Hour}, nil, 0, 2 * time.Hour, 2 * time.Hour},
+		{"default", feedStruct{}, nil, 0, 15 * time.Minute, 15 * time.Minute},
+		{"max-age", feedStruct{}, hourly, 2 * time.Hour, 2 * time.Hour, 30 * time.Minute},
+		{"max-age capped", feedStruct{}, hourly, 24 * time.Hour, 4 * time.Hour, 30 * time.Minute},
+		{"max-age shorter", feedStruct{}, hourly, 10 * time.Minute, 30 * time.Minute, 30 * time.Minute},
+		{"rss ttl", feedStruct{Hints: feedHints{TTL: 3 * time.Hour}}, hourly, 0, 3 * time.Hour, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := scheduleNextFetch(tt.feedItem, tt.items, tt.maxAge)
+			if wait := time.Until(next.At).Round(time.Minute); wait != tt.wantWait {
+				t.Errorf("next fetch in %v, want %v", wait, tt.wantWait)
+			}
+			if next.Interval.Round(time.Minute) != tt.wantInterval {
+				t.Errorf("interval %v, want %v", next.Interval, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestScheduleNextFetchSkipHours(t *testing.T) {
+	setTestFetchIntervals(t)
+	skipped := time.Now().UTC().Add(time.Hour).Hour()
+	feedItem := feedStruct{Settings: feedSettings{FetchInterval: time.Hour}, Hints: feedHints{SkipHours: []int{skipped, (skipped + 1) % 24}}}
+
+	next := scheduleNextFetch(feedItem, nil, 0)
+	if hour := next.At.UTC().Hour(); hour == skipped || hour == (skipped+1)%24 {
+		t.Errorf("next fetch at %v is in a skipped hour", next.At.UTC())
+	}
+	if next.At.Before(time.Now().Add(time.Hour)) || next.At.After(time.Now().Add(3*time.Hour)) {
+		t.Errorf("next fetch at %v, want after the skipped hours", next.At.UTC())
+	}
+}
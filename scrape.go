@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// scrapeConfig holds the CSS selectors that turn a web page without a feed
+// into feed items. Title, link, date and body selectors are evaluated
+// inside each element matched by Item.
+type scrapeConfig struct {
+	Item       string
+	Title      string
+	Link       string
+	Date       string
+	DateFormat string // Go time layout, the usual feed formats if empty
+	Body       string
+}
+
+// scrapeConfigKeys are the arguments of -scrape.
+var scrapeConfigKeys = []string{"item", "title", "link", "date", "date_format", "body"}
+
+// set parses and validates value for the key.
+func (c *scrapeConfig) set(key, value string) error {
+	value = strings.TrimSpace(value)
+	if key != "date_format" && value != "" {
+		if _, err := cascadia.Compile(value); err != nil {
+			return fmt.Errorf("invalid %s selector %q: %w", key, value, err)
+		}
+	}
+	switch key {
+	case "item":
+		c.Item = value
+	case "title":
+		c.Title = value
+	case "link":
+		c.Link = value
+	case "date":
+		c.Date = value
+	case "date_format":
+		c.DateFormat = value
+	case "body":
+		c.Body = value
+	default:
+		return fmt.Errorf("unknown scrape selector %q", key)
+	}
+	return nil
+}
+
+func (c scrapeConfig) get(key string) string {
+	switch key {
+	case "item":
+		return c.Item
+	case "title":
+		return c.Title
+	case "link":
+		return c.Link
+	case "date":
+		return c.Date
+	case "date_format":
+		return c.DateFormat
+	case "body":
+		return c.Body
+	}
+	return ""
+}
+
+// parseScrapeArgs applies key=selector arguments to the config.
+func parseScrapeArgs(config *scrapeConfig, args []string) error {
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid selector %q, use key=selector", arg)
+		}
+		if err := config.set(key, value); err != nil {
+			return err
+		}
+	}
+	if config.Item == "" {
+		return fmt.Errorf("an item selector is required")
+	}
+	return nil
+}
+
+// scrapeFeed builds a feed from the items found on an HTML page. Items are
+// identified by their link.
+func scrapeFeed(pageURL string, body []byte, config scrapeConfig) (*gofeed.Feed, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse page: %w", err)
+	}
+	base := pageURL
+	if href, ok := doc.Find("base[href]").Attr("href"); ok {
+		base = resolveURL(pageURL, href)
+	}
+
+	feed := &gofeed.Feed{
+		Title:       strings.TrimSpace(doc.Find("title").First().Text()),
+		Description: doc.Find(`meta[name="description"]`).AttrOr("content", ""),
+		Link:        pageURL,
+		FeedType:    "html",
+	}
+	if image := doc.Find(`meta[property="og:image"]`).AttrOr("content", ""); image != "" {
+		feed.Image = &gofeed.Image{URL: resolveURL(base, image)}
+	}
+
+	seen := make(map[string]bool)
+	doc.Find(config.Item).Each(func(_ int, s *goquery.Selection) {
+		item := scrapeItem(base, s, config)
+		if item == nil || seen[item.Link] {
+			return
+		}
+		seen[item.Link] = true
+		feed.Items = append(feed.Items, item)
+	})
+	if len(feed.Items) == 0 {
+		return nil, fmt.Errorf("no items with a link match %q", config.Item)
+	}
+	return feed, nil
+}
+
+// scrapeItem returns the item of an element matched by the item selector,
+// or nil if it has no link.
+func scrapeItem(base string, s *goquery.Selection, config scrapeConfig) *gofeed.Item {
+	linkElement := s.Find("a[href]").First()
+	if config.Link != "" {
+		linkElement = s.Find(config.Link).First()
+	} else if s.Is("a[href]") {
+		linkElement = s
+	}
+	href, ok := linkElement.Attr("href")
+	if !ok {
+		href = linkElement.Find("a[href]").AttrOr("href", "")
+	}
+	link := resolveURL(base, strings.TrimSpace(href))
+	if !strings.HasPrefix(link, "http") {
+		return nil
+	}
+
+	title := linkElement.Text()
+	if config.Title != "" {
+		title = s.Find(config.Title).First().Text()
+	}
+	item := &gofeed.Item{
+		Title: strings.Join(strings.Fields(title), " "),
+		Link:  link,
+		Links: []string{link},
+		GUID:  link,
+	}
+
+	if config.Body != "" {
+		content := s.Find(config.Body).First()
+		// relative URLs would break outside of the page
+		content.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+			img.SetAttr("src", resolveURL(base, img.AttrOr("src", "")))
+		})
+		content.Find("a[href]").Each(func(_ int, anchor *goquery.Selection) {
+			anchor.SetAttr("href", resolveURL(base, anchor.AttrOr("href", "")))
+		})
+		item.Description, _ = content.Html()
+		item.Description = strings.TrimSpace(item.Description)
+	}
+
+	if config.Date != "" {
+		dateElement := s.Find(config.Date).First()
+		value := dateElement.AttrOr("datetime", dateElement.AttrOr("content", dateElement.Text()))
+		item.Published = strings.Join(strings.Fields(value), " ")
+		if itemTime, ok := parseScrapedDate(item.Published, config.DateFormat); ok {
+			item.PublishedParsed = &itemTime
+		} else if item.Published != "" {
+			log.Printf("[DEBUG] Can't parse date %q of %s", item.Published, link)
+		}
+	}
+	return item
+}
+
+func parseScrapedDate(value, layout string) (time.Time, bool) {
+	layouts := getDateFormats()
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkValidScrapedSource scrapes a web page and returns it as a feed.
+func checkValidScrapedSource(pageURL string, config scrapeConfig) (*feedStruct, error) {
+	feedItem := feedStruct{URL: pageURL, Link: pageURL, State: "active"}
+	body, err := fetchPage(pageURL)
+	if err != nil {
+		return &feedItem, err
+	}
+	feed, err := scrapeFeed(pageURL, body, config)
+	if err != nil {
+		return &feedItem, err
+	}
+	feedItem.Scrape = &config
+	feedItem.Title = feed.Title
+	if feedItem.Title == "" {
+		feedItem.Title = pageURL
+	}
+	feedItem.Description = feed.Description
+	if feed.Image != nil {
+		feedItem.Image = feed.Image.URL
+	} else {
+		feedItem.Image = fetchFavicon(pageURL)
+	}
+	feedItem.Posts = feed.Items
+	return &feedItem, nil
+}
+
+// fetchSourceDocument fetches the items of a feed or scraped source.
+func fetchSourceDocument(feedItem feedStruct) (*gofeed.Feed, error) {
+	if feedItem.Scrape == nil {
+		return fetchFeedDocument(feedItem.URL)
+	}
+	body, err := fetchPage(feedItem.URL)
+	if err != nil {
+		return nil, err
+	}
+	return scrapeFeed(feedItem.URL, body, *feedItem.Scrape)
+}
+
+// dateScrapedItems dates the items of a page that shows no dates by the time
+// they were first seen. Items that were on the page when the source was
+// added (initial) are remembered without a date and dropped, they are not
+// new.
+func (a *Atomstr) dateScrapedItems(feedPub string, items []*gofeed.Item, initial bool) []*gofeed.Item {
+	now := time.Now().UTC()
+	current := make(map[string]bool)
+	var dated []*gofeed.Item
+	for _, item := range items {
+		current[item.GUID] = true
+		if item.PublishedParsed != nil {
+			dated = append(dated, item)
+			continue
+		}
+		firstSeen, err := a.dbScrapedItemFirstSeen(feedPub, item.GUID, now, initial)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+		if firstSeen != nil {
+			item.PublishedParsed = firstSeen
+			dated = append(dated, item)
+		}
+	}
+	if !dryRunMode {
+		if err := a.dbPruneScrapedItems(feedPub, current); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+	return dated
+}
+
+// dbScrapedItemFirstSeen returns when an item was first seen, recording now
+// for new items, or nil for new items of the initial scrape and items
+// recorded by it.
+func (a *Atomstr) dbScrapedItemFirstSeen(feedPub, itemKey string, now time.Time, initial bool) (*time.Time, error) {
+	var firstSeen *time.Time
+	err := a.db.QueryRow(`SELECT first_seen FROM scraped_items WHERE feed_pub = ? AND item_key = ?`, feedPub, itemKey).Scan(&firstSeen)
+	if err == nil {
+		return firstSeen, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("can't read scraped items: %w", err)
+	}
+	if !initial {
+		firstSeen = &now
+	}
+	if dryRunMode {
+		return firstSeen, nil
+	}
+	if _, err := a.db.Exec(`INSERT INTO scraped_items (feed_pub, item_key, first_seen) VALUES (?, ?, ?)`, feedPub, itemKey, firstSeen); err != nil {
+		return nil, fmt.Errorf("can't write scraped items: %w", err)
+	}
+	return firstSeen, nil
+}
+
+// dbPruneScrapedItems forgets the items that are no longer on the page.
+func (a *Atomstr) dbPruneScrapedItems(feedPub string, current map[string]bool) error {
+	rows, err := a.db.Query(`SELECT item_key FROM scraped_items WHERE feed_pub = ?`, feedPub)
+	if err != nil {
+		return fmt.Errorf("can't read scraped items: %w", err)
+	}
+	var gone []string
+	for rows.Next() {
+		var itemKey string
+		if err := rows.Scan(&itemKey); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning for scraped items failed: %w", err)
+		}
+		if !current[itemKey] {
+			gone = append(gone, itemKey)
+		}
+	}
+	rows.Close()
+	for _, itemKey := range gone {
+		if _, err := a.db.Exec(`DELETE FROM scraped_items WHERE feed_pub = ? AND item_key = ?`, feedPub, itemKey); err != nil {
+			return fmt.Errorf("can't prune scraped items: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *Atomstr) dbSaveScrapeConfig(feedPub string, config scrapeConfig) error {
+	_, err := a.db.Exec(`INSERT OR REPLACE INTO scrape_sources (feed_pub, item_selector, title_selector, link_selector, date_selector, date_format, body_selector) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		feedPub, config.Item, config.Title, config.Link, config.Date, config.DateFormat, config.Body)
+	if err != nil {
+		return fmt.Errorf("can't save scrape selectors: %w", err)
+	}
+	return nil
+}
+
+// scrapeSource is the -scrape command. With selector arguments it adds the
+// page as a scraped source, or changes the selectors of an existing one.
+// Without arguments it shows the selectors.
+func (a *Atomstr) scrapeSource(pageURL string, args []string) error {
+	feedItem := a.dbGetFeed(pageURL)
+	if feedItem.URL != "" && feedItem.Scrape == nil {
+		return fmt.Errorf("%s is a feed, not a scraped source", pageURL)
+	}
+	if len(args) == 0 {
+		if feedItem.URL == "" {
+			return fmt.Errorf("feed not found")
+		}
+		for _, key := range scrapeConfigKeys {
+			value := feedItem.Scrape.get(key)
+			if value == "" {
+				value = "(default)"
+			}
+			fmt.Printf("%s = %s\n", key, value)
+		}
+		return nil
+	}
+
+	var config scrapeConfig
+	if feedItem.Scrape != nil {
+		config = *feedItem.Scrape
+	}
+	if err := parseScrapeArgs(&config, args); err != nil {
+		return err
+	}
+	// the selectors must find something before they are saved
+	source, err := checkValidScrapedSource(pageURL, config)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Found %d items on %s", len(source.Posts), pageURL)
+	if feedItem.URL != "" {
+		if err := a.dbSaveScrapeConfig(feedItem.SourcePub, config); err != nil {
+			return err
+		}
+		log.Println("[INFO] Updated selectors of", pageURL)
+		return nil
+	}
+	_, err = a.addFeed(source, feedSettings{})
+	return err
+}
+
+// proxyTag returns the NIP-48 proxy tag of an item. Items of scraped
+// sources come from a web page instead of a feed.
+func (f feedStruct) proxyTag(feedPost *gofeed.Item) nostr.Tag {
+	if f.Scrape != nil {
+		return nostr.Tag{"proxy", feedPost.Link, "web"}
+	}
+	return nostr.Tag{"proxy", f.URL + `#` + url.QueryEscape(feedPost.Link), "rss"}
+}
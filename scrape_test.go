@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testScrapePage = `<html><head>
+<title> Example News </title>
+<meta name="description" content="News from example.org">
+<meta property="og:image" content="/logo.png">
+<base href="https://example.org/news/">
+</head><body>
+<article>
+  <h2><a href="first">First   story</a></h2>
+  <time datetime="2026-10-15T08:00:00Z">yesterday</time>
+  <div class="summary">Read <a href="/more">more</a> <img src="img/1.png"></div>
+</article>
+<article>
+  <h2><a href="https://other.example.org/second">Second story</a></h2>
+  <span class="date">15.10.2026</span>
+</article>
+<article><h2><a href="first">Teaser of the first story again</a></h2></article>
+<article><h2>No link</h2></article>
+</body></html>`
+
+func TestScrapeFeed(t *testing.T) {
+	config := scrapeConfig{Item: "article", Title: "h2", Date: "time, .date", DateFormat: "", Body: ".summary"}
+	feed, err := scrapeFeed("https://example.org/news/index.html", []byte(testScrapePage), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Example News" || feed.Description != "News from example.org" || feed.Image == nil || feed.Image.URL != "https://example.org/logo.png" {
+		t.Errorf("feed info = %q, %q, %+v", feed.Title, feed.Description, feed.Image)
+	}
+	if len(feed.Items) != 2 {
+		t.Fatalf("found %d items, want 2 (duplicate and link-less dropped)", len(feed.Items))
+	}
+
+	first := feed.Items[0]
+	if first.Title != "First story" || first.Link != "https://example.org/news/first" || first.GUID != first.Link {
+		t.Errorf("first item = %q, %q, %q", first.Title, first.Link, first.GUID)
+	}
+	if first.PublishedParsed == nil || !first.PublishedParsed.Equal(time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("first item date = %v, want the datetime attribute", first.PublishedParsed)
+	}
+	wantBody := `Read <a href="https://example.org/more">more</a> <img src="https://example.org/news/img/1.png"/>`
+	if first.Description != wantBody {
+		t.Errorf("first item body = %q, want %q", first.Description, wantBody)
+	}
+
+	second := feed.Items[1]
+	if second.Link != "https://other.example.org/second" || second.Published != "15.10.2026" || second.PublishedParsed != nil {
+		t.Errorf("second item = %q, %q, %v", second.Link, second.Published, second.PublishedParsed)
+	}
+
+	// with a layout the date parses
+	config.DateFormat = "02.01.2006"
+	feed, err = scrapeFeed("https://example.org/news/index.html", []byte(testScrapePage), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed := feed.Items[1].PublishedParsed; parsed == nil || !parsed.Equal(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("second item date with layout = %v", parsed)
+	}
+}
+
+func TestScrapeFeedLinkItems(t *testing.T) {
+	page := `<ul><li><a class="post" href="/a">A</a></li><li><a class="post" href="/b">B</a></li><li><a href="/about">About</a></li></ul>`
+	feed, err := scrapeFeed("https://example.org/", []byte(page), scrapeConfig{Item: "a.post"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 2 || feed.Items[0].Link != "https://example.org/a" || feed.Items[1].Title != "B" {
+		t.Errorf("items = %+v", feed.Items)
+	}
+
+	if _, err := scrapeFeed("https://example.org/", []byte(page), scrapeConfig{Item: "article"}); err == nil {
+		t.Errorf("page without matching items didn't fail")
+	}
+}
+
+func TestParseScrapeArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{[]string{"item=article", "title=h2", "date_format=2006-01-02"}, false},
+		{[]string{"title=h2"}, true},
+		{[]string{"item=article", "title"}, true},
+		{[]string{"item=article", "color=red"}, true},
+		{[]string{"item=[broken"}, true},
+	}
+	for _, tt := range tests {
+		var config scrapeConfig
+		if err := parseScrapeArgs(&config, tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("parseScrapeArgs(%v) error %v, want error %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
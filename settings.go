@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedSettings holds the per-feed overrides of the global configuration.
+// Zero values mean "use the global default".
+type feedSettings struct {
+	PublishMode     string
+	TrackChanges    bool
+	FullText        bool
+	FetchInterval   time.Duration
+	Relays          []string
+	Template        string
+	MaxItems        int
+	HistoryInterval time.Duration
+}
+
+// feedSettingKeys describes the settings that can be changed with -set and
+// the web settings page.
+var feedSettingKeys = map[string]string{
+	"publish_mode":     "note, article, article+teaser, video or video+note",
+	"track_changes":    "publish updates and deletions of changed items (true/false)",
+	"full_text":        "fetch the full article from the item link (true/false)",
+	"fetch_interval":   "fixed fetch interval instead of the adaptive one, e.g. 6h",
+	"relays":           "comma separated relays to publish to",
+	"template":         "Go text/template or builtin template name for the note content",
+	"max_items":        "maximum number of new items published per fetch",
+	"history_interval": "max age of items published when the feed is added",
+}
+
+func sortedFeedSettingKeys() []string {
+	keys := make([]string, 0, len(feedSettingKeys))
+	for key := range feedSettingKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set parses and validates value for the setting key. An empty value resets
+// the setting to its default.
+func (s *feedSettings) set(key, value string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "publish_mode":
+		if value != "" && !validPublishMode(value) {
+			return fmt.Errorf("invalid publish mode %q, use one of %s", value, strings.Join(publishModes, ", "))
+		}
+		s.PublishMode = value
+	case "track_changes", "full_text":
+		enabled := false
+		if value != "" {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid value %q for %s", value, key)
+			}
+		}
+		if key == "track_changes" {
+			s.TrackChanges = enabled
+		} else {
+			s.FullText = enabled
+		}
+	case "fetch_interval", "history_interval":
+		var d time.Duration
+		if value != "" {
+			var err error
+			if d, err = time.ParseDuration(value); err != nil || d < 0 {
+				return fmt.Errorf("invalid duration %q for %s", value, key)
+			}
+		}
+		if key == "fetch_interval" {
+			s.FetchInterval = d
+		} else {
+			s.HistoryInterval = d
+		}
+	case "relays":
+		relays := splitAndTrim(value)
+		for _, relay := range relays {
+			if !strings.HasPrefix(relay, "wss://") && !strings.HasPrefix(relay, "ws://") {
+				return fmt.Errorf("invalid relay URL %q", relay)
+			}
+		}
+		s.Relays = relays
+	case "template":
+		if value != "" {
+			if err := validateNoteTemplate(value); err != nil {
+				return err
+			}
+		}
+		s.Template = value
+	case "max_items":
+		n := 0
+		if value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("invalid number %q for max_items", value)
+			}
+		}
+		s.MaxItems = n
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// get returns the setting key formatted as it is accepted by set.
+func (s feedSettings) get(key string) string {
+	switch key {
+	case "publish_mode":
+		return s.PublishMode
+	case "track_changes":
+		return formatBool(s.TrackChanges)
+	case "full_text":
+		return formatBool(s.FullText)
+	case "fetch_interval":
+		return formatDuration(s.FetchInterval)
+	case "history_interval":
+		return formatDuration(s.HistoryInterval)
+	case "relays":
+		return strings.Join(s.Relays, ",")
+	case "template":
+		return s.Template
+	case "max_items":
+		if s.MaxItems == 0 {
+			return ""
+		}
+		return strconv.Itoa(s.MaxItems)
+	}
+	return ""
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// publishMode returns the publish mode of the feed, defaulting to notes.
+func (f feedStruct) publishMode() string {
+	if f.Settings.PublishMode == "" {
+		return "note"
+	}
+	return f.Settings.PublishMode
+}
+
+// postPublishMode returns the publish mode of an item, video is nil unless
+// the item is a video. Videos use VIDEO_PUBLISH_MODE unless the feed has a
+// publish mode.
+func (f feedStruct) postPublishMode(video *videoInfo) string {
+	mode := f.publishMode()
+	switch {
+	case video != nil && f.Settings.PublishMode == "":
+		return videoPublishMode
+	case video == nil && strings.HasPrefix(mode, "video"):
+		return "note"
+	}
+	return mode
+}
+
+// publishRelays returns the relays events of the feed are published to.
+func (f feedStruct) publishRelays() []string {
+	if len(f.Settings.Relays) > 0 {
+		return f.Settings.Relays
+	}
+	return relaysToPublishTo
+}
+
+func (f feedStruct) effectiveFetchInterval() time.Duration {
+	if f.Settings.FetchInterval > 0 {
+		return f.Settings.FetchInterval
+	}
+	return fetchInterval
+}
+
+func (f feedStruct) effectiveHistoryInterval() time.Duration {
+	if f.Settings.HistoryInterval > 0 {
+		return f.Settings.HistoryInterval
+	}
+	return historyInterval
+}
+
+// parseFeedSettingArgs applies key=value arguments to the settings.
+func parseFeedSettingArgs(settings *feedSettings, args []string) error {
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q, use key=value", arg)
+		}
+		if err := settings.set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Atomstr) dbGetFeedSettings(feedPub string) (feedSettings, error) {
+	var settings feedSettings
+	var fetch, history, relays string
+	err := a.db.QueryRow(`SELECT publish_mode, track_changes, full_text, fetch_interval, relays, template, max_items, history_interval FROM feed_settings WHERE feed_pub = ?`, feedPub).
+		Scan(&settings.PublishMode, &settings.TrackChanges, &settings.FullText, &fetch, &relays, &settings.Template, &settings.MaxItems, &history)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("can't read feed settings: %w", err)
+	}
+	settings.FetchInterval, _ = time.ParseDuration(fetch)
+	settings.HistoryInterval, _ = time.ParseDuration(history)
+	settings.Relays = splitAndTrim(relays)
+	return settings, nil
+}
+
+func (a *Atomstr) dbSaveFeedSettings(feedPub string, settings feedSettings) error {
+	_, err := a.db.Exec(`INSERT OR REPLACE INTO feed_settings (feed_pub, publish_mode, track_changes, full_text, fetch_interval, relays, template, max_items, history_interval) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		feedPub, settings.PublishMode, settings.TrackChanges, settings.FullText, formatDuration(settings.FetchInterval), strings.Join(settings.Relays, ","),
+		settings.Template, settings.MaxItems, formatDuration(settings.HistoryInterval))
+	if err != nil {
+		return fmt.Errorf("can't save feed settings: %w", err)
+	}
+	return nil
+}
+
+// updateFeedSettings applies key=value arguments to the settings of a feed.
+func (a *Atomstr) updateFeedSettings(feedURL string, args []string) error {
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed not found")
+	}
+	settings, err := a.dbGetFeedSettings(feedItem.SourcePub)
+	if err != nil {
+		return err
+	}
+	if err := parseFeedSettingArgs(&settings, args); err != nil {
+		return err
+	}
+	if err := a.dbSaveFeedSettings(feedItem.SourcePub, settings); err != nil {
+		return err
+	}
+	log.Println("[INFO] Updated settings of", feedURL)
+	return nil
+}
+
+func (a *Atomstr) printFeedSettings(feedURL string) error {
+	feedItem := a.dbGetFeed(feedURL)
+	if feedItem.URL == "" {
+		return fmt.Errorf("feed not found")
+	}
+	settings, err := a.dbGetFeedSettings(feedItem.SourcePub)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedFeedSettingKeys() {
+		value := settings.get(key)
+		if value == "" {
+			value = "(default)"
+		}
+		fmt.Printf("%s = %s\n", key, value)
+	}
+	return nil
+}
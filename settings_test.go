@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeedSettingArgs(t *testing.T) {
+	var settings feedSettings
+	err := parseFeedSettingArgs(&settings, []string{
+		"publish_mode=article", "track_changes=true", "fetch_interval=6h", "relays=wss://a.example.org, wss://b.example.org",
+		"template=no-title", "max_items=5", "history_interval=48h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"publish_mode":     "article",
+		"track_changes":    "true",
+		"full_text":        "",
+		"fetch_interval":   "6h0m0s",
+		"relays":           "wss://a.example.org,wss://b.example.org",
+		"template":         "no-title",
+		"max_items":        "5",
+		"history_interval": "48h0m0s",
+	}
+	for key, value := range want {
+		if got := settings.get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	// an empty value resets the setting
+	if err := parseFeedSettingArgs(&settings, []string{"fetch_interval=", "relays="}); err != nil {
+		t.Fatal(err)
+	}
+	if settings.FetchInterval != 0 || settings.Relays != nil {
+		t.Errorf("settings weren't reset: %+v", settings)
+	}
+
+	for _, arg := range []string{
+		"publish_mode=blog", "track_changes=maybe", "fetch_interval=-1h", "fetch_interval=often",
+		"relays=https://relay.example.org", "template={{.Nope}}", "max_items=-1", "unknown=1", "publish_mode",
+	} {
+		if err := parseFeedSettingArgs(&feedSettings{}, []string{arg}); err == nil {
+			t.Errorf("%q was accepted", arg)
+		}
+	}
+}
+
+func TestFeedSettingsOverrides(t *testing.T) {
+	defer func(interval, history time.Duration, relays []string) {
+		fetchInterval, historyInterval, relaysToPublishTo = interval, history, relays
+	}(fetchInterval, historyInterval, relaysToPublishTo)
+	fetchInterval, historyInterval = 15*time.Minute, time.Hour
+	relaysToPublishTo = []string{"wss://default.example.org"}
+
+	var defaults feedStruct
+	if defaults.publishMode() != "note" || defaults.effectiveFetchInterval() != fetchInterval ||
+		defaults.effectiveHistoryInterval() != historyInterval || strings.Join(defaults.publishRelays(), ",") != "wss://default.example.org" {
+		t.Errorf("feed without settings doesn't use the defaults")
+	}
+
+	overridden := feedStruct{Settings: feedSettings{PublishMode: "article", FetchInterval: 6 * time.Hour, HistoryInterval: 48 * time.Hour, Relays: []string{"wss://own.example.org"}}}
+	if overridden.publishMode() != "article" || overridden.effectiveFetchInterval() != 6*time.Hour ||
+		overridden.effectiveHistoryInterval() != 48*time.Hour || strings.Join(overridden.publishRelays(), ",") != "wss://own.example.org" {
+		t.Errorf("feed settings don't override the defaults")
+	}
+}
+
+func TestUpdateFeedSettings(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+
+	if err := a.updateFeedSettings(feedItem.URL, []string{"publish_mode=article", "max_items=3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.updateFeedSettings(feedItem.URL, []string{"relays=wss://own.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	// invalid arguments change nothing
+	if err := a.updateFeedSettings(feedItem.URL, []string{"max_items=10", "publish_mode=blog"}); err == nil {
+		t.Errorf("invalid setting was accepted")
+	}
+
+	settings := testFeed(t, a, feedItem.URL).Settings
+	if settings.PublishMode != "article" || settings.MaxItems != 3 {
+		t.Errorf("settings are %+v", settings)
+	}
+	stored, err := a.dbGetFeedSettings(feedItem.SourcePub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(stored.Relays, ",") != "wss://own.example.org" {
+		t.Errorf("stored relays are %v", stored.Relays)
+	}
+	if err := a.updateFeedSettings("https://example.org/unknown.xml", []string{"max_items=1"}); err == nil {
+		t.Errorf("settings of an unknown feed were changed")
+	}
+}
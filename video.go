@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// NIP-71 video event kinds
+const (
+	kindVideo      = 21
+	kindShortVideo = 22
+)
+
+// videoInfo describes the video of a feed item, taken from yt:videoId and
+// Media RSS (YouTube and PeerTube feeds).
+type videoInfo struct {
+	Title       string
+	Description string
+	Files       []mediaItem // video files, the first is the preferred one
+	Page        string      // watch page of videos without files (YouTube)
+	Thumbnail   mediaItem
+	Duration    int // seconds
+	Short       bool
+}
+
+// parseVideoInfo returns the video of a feed item, or nil if the item is
+// not a video.
+func parseVideoInfo(feedPost *gofeed.Item) *videoInfo {
+	media := feedPost.Extensions["media"]
+	youtubeID := extensionValue(feedPost.Extensions["yt"], "videoId")
+
+	var video videoInfo
+	elements := []map[string][]ext.Extension{media}
+	for _, group := range media["group"] {
+		elements = append(elements, group.Children)
+	}
+	for _, element := range elements {
+		if title := extensionValue(element, "title"); title != "" {
+			video.Title = title
+		}
+		if description := extensionValue(element, "description"); description != "" {
+			video.Description = description
+		}
+		for _, content := range element["content"] {
+			isVideo := content.Attrs["medium"] == "video" || strings.HasPrefix(content.Attrs["type"], "video/")
+			if !isVideo || content.Attrs["url"] == "" {
+				continue
+			}
+			file := mediaItem{URL: content.Attrs["url"], MimeType: content.Attrs["type"]}
+			file.Width, _ = strconv.Atoi(content.Attrs["width"])
+			file.Height, _ = strconv.Atoi(content.Attrs["height"])
+			file.Size, _ = strconv.ParseInt(content.Attrs["fileSize"], 10, 64)
+			if duration, err := strconv.ParseFloat(content.Attrs["duration"], 64); err == nil && video.Duration == 0 {
+				video.Duration = int(duration)
+			}
+			video.Files = append(video.Files, file)
+		}
+		for _, thumbnail := range element["thumbnail"] {
+			if video.Thumbnail.URL == "" && thumbnail.Attrs["url"] != "" {
+				video.Thumbnail.URL = thumbnail.Attrs["url"]
+				video.Thumbnail.Width, _ = strconv.Atoi(thumbnail.Attrs["width"])
+				video.Thumbnail.Height, _ = strconv.Atoi(thumbnail.Attrs["height"])
+			}
+		}
+	}
+
+	if youtubeID != "" {
+		// YouTube doesn't offer video files, clients open the watch page
+		video.Page = youtubeWatchURL(youtubeID, feedPost.Link)
+		video.Short = strings.Contains(feedPost.Link, "/shorts/")
+	}
+	if len(video.Files) == 0 && video.Page == "" {
+		return nil
+	}
+	if video.Title == "" {
+		video.Title = feedPost.Title
+	}
+	if video.Description == "" {
+		video.Description = htmlToPlainText(feedPost.Description)
+	}
+	if video.Thumbnail.URL == "" && feedPost.Image != nil {
+		video.Thumbnail.URL = feedPost.Image.URL
+	}
+	return &video
+}
+
+func youtubeWatchURL(videoID, link string) string {
+	if link != "" {
+		return link
+	}
+	return "https://www.youtube.com/watch?v=" + url.QueryEscape(videoID)
+}
+
+// imetaTag returns the NIP-71 imeta tag of the video: the preferred file
+// with the others as fallbacks. Videos without files only get the
+// thumbnail, the watch page is in the "r" tag.
+func (v videoInfo) imetaTag() nostr.Tag {
+	tag := nostr.Tag{"imeta"}
+	if len(v.Files) > 0 {
+		file := v.Files[0]
+		tag = append(tag, "url "+file.URL)
+		if file.MimeType != "" {
+			tag = append(tag, "m "+file.MimeType)
+		}
+		if file.Width > 0 && file.Height > 0 {
+			tag = append(tag, "dim "+strconv.Itoa(file.Width)+"x"+strconv.Itoa(file.Height))
+		}
+		if file.Size > 0 {
+			tag = append(tag, "size "+strconv.FormatInt(file.Size, 10))
+		}
+	}
+	if v.Duration > 0 {
+		tag = append(tag, "duration "+strconv.Itoa(v.Duration))
+	}
+	if v.Thumbnail.URL != "" {
+		tag = append(tag, "image "+v.Thumbnail.URL)
+	}
+	if len(v.Files) > 1 {
+		for _, fallback := range v.Files[1:] {
+			tag = append(tag, "fallback "+fallback.URL)
+		}
+	}
+	return tag
+}
+
+// buildVideoEvent creates an unsigned NIP-71 video event for a feed item.
+func buildVideoEvent(feedItem feedStruct, feedPost *gofeed.Item, video *videoInfo, itemTime int64) nostr.Event {
+	kind := kindVideo
+	if video.Short {
+		kind = kindShortVideo
+	}
+	tags := nostr.Tags{
+		{"title", video.Title},
+		{"published_at", strconv.FormatInt(itemTime, 10)},
+		{"alt", "Video: " + video.Title},
+	}
+	if imeta := video.imetaTag(); len(imeta) > 1 {
+		tags = append(tags, imeta)
+	}
+	if video.Duration > 0 {
+		tags = append(tags, nostr.Tag{"duration", strconv.Itoa(video.Duration)})
+	}
+	for _, category := range feedPost.Categories {
+		tags = append(tags, nostr.Tag{"t", category})
+	}
+	if feedPost.Link != "" {
+		tags = append(tags, nostr.Tag{"r", feedPost.Link})
+	} else if video.Page != "" {
+		tags = append(tags, nostr.Tag{"r", video.Page})
+	}
+	tags = append(tags, feedItem.proxyTag(feedPost))
+
+	return nostr.Event{
+		PubKey:    feedItem.Pub,
+		CreatedAt: nostr.Timestamp(itemTime),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   video.Description,
+	}
+}
+
+// buildVideoNoteEvent creates an unsigned kind 1 note announcing a video
+// event, quoting it with a "q" tag and an nevent link.
+func buildVideoNoteEvent(feedItem feedStruct, feedPost *gofeed.Item, video nostr.Event, itemTime int64) nostr.Event {
+	note := buildNoteEvent(feedItem, feedPost, itemTime)
+	nevent, err := nip19.EncodeEvent(video.ID, feedItem.publishRelays(), feedItem.Pub)
+	if err != nil {
+		log.Printf("[WARN] Can't encode nevent for %s: %v", feedPost.Link, err)
+		return note
+	}
+	note.Content = note.Content + "\n\nnostr:" + nevent
+	note.Tags = append(note.Tags, nostr.Tag{"q", video.ID, firstOrEmpty(feedItem.publishRelays()), feedItem.Pub})
+	return note
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const testYouTubeFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+<title>Channel</title>
+<entry>
+	<id>yt:video:abc123</id>
+	<yt:videoId>abc123</yt:videoId>
+	<title>A video</title>
+	<link rel="alternate" href="https://www.youtube.com/watch?v=abc123"/>
+	<published>2026-01-02T03:04:05+00:00</published>
+	<media:group>
+		<media:title>A video</media:title>
+		<media:content url="https://www.youtube.com/v/abc123?version=3" type="application/x-shockwave-flash" width="640" height="390"/>
+		<media:thumbnail url="https://i.ytimg.com/vi/abc123/hqdefault.jpg" width="480" height="360"/>
+		<media:description>About the video</media:description>
+	</media:group>
+</entry>
+<entry>
+	<id>yt:video:short1</id>
+	<yt:videoId>short1</yt:videoId>
+	<title>A short</title>
+	<link rel="alternate" href="https://www.youtube.com/shorts/short1"/>
+	<published>2026-01-02T03:04:05+00:00</published>
+</entry>
+</feed>`
+
+const testPeerTubeFeed = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+<channel><title>PeerTube</title><link>https://tube.example.com/</link>
+<item>
+	<title>Talk</title>
+	<link>https://tube.example.com/w/talk</link>
+	<description>&lt;p&gt;The talk&lt;/p&gt;</description>
+	<category>conference</category>
+	<media:group>
+		<media:content url="https://tube.example.com/talk-1080.mp4" type="video/mp4" medium="video" width="1920" height="1080" fileSize="1000" duration="1234.5"/>
+		<media:content url="https://tube.example.com/talk-480.mp4" type="video/mp4" medium="video" width="854" height="480" fileSize="300" duration="1234.5"/>
+		<media:content url="https://tube.example.com/talk.torrent" type="application/x-bittorrent"/>
+	</media:group>
+	<media:thumbnail url="https://tube.example.com/talk.jpg"/>
+</item>
+<item>
+	<title>Blog post</title>
+	<link>https://tube.example.com/blog</link>
+	<media:content url="https://tube.example.com/photo.jpg" type="image/jpeg" medium="image"/>
+</item>
+</channel></rss>`
+
+func parseTestItems(t *testing.T, doc string) []*gofeed.Item {
+	t.Helper()
+	feed, err := gofeed.NewParser().ParseString(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed.Items
+}
+
+func TestParseVideoInfo(t *testing.T) {
+	youtube := parseTestItems(t, testYouTubeFeed)
+	peertube := parseTestItems(t, testPeerTubeFeed)
+	tests := []struct {
+		name string
+		item *gofeed.Item
+		want *videoInfo
+	}{
+		{"youtube", youtube[0], &videoInfo{
+			Title:       "A video",
+			Description: "About the video",
+			Page:        "https://www.youtube.com/watch?v=abc123",
+			Thumbnail:   mediaItem{URL: "https://i.ytimg.com/vi/abc123/hqdefault.jpg", Width: 480, Height: 360},
+		}},
+		{"youtube short", youtube[1], &videoInfo{
+			Title: "A short",
+			Page:  "https://www.youtube.com/shorts/short1",
+			Short: true,
+		}},
+		{"peertube", peertube[0], &videoInfo{
+			Title:       "Talk",
+			Description: "The talk",
+			Files: []mediaItem{
+				{URL: "https://tube.example.com/talk-1080.mp4", MimeType: "video/mp4", Width: 1920, Height: 1080, Size: 1000},
+				{URL: "https://tube.example.com/talk-480.mp4", MimeType: "video/mp4", Width: 854, Height: 480, Size: 300},
+			},
+			Thumbnail: mediaItem{URL: "https://tube.example.com/talk.jpg"},
+			Duration:  1234,
+		}},
+		{"image", peertube[1], nil},
+	}
+	for _, tt := range tests {
+		if got := parseVideoInfo(tt.item); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseVideoInfo() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPostEventsVideo(t *testing.T) {
+	feedItem := generateKeysForURL("https://tube.example.com/feeds/videos.xml")
+	feedItem.Settings.Relays = []string{"wss://relay.example.com"}
+	items := parseTestItems(t, testPeerTubeFeed)
+
+	// videos are published as notes unless the feed or VIDEO_PUBLISH_MODE
+	// asks for video events
+	defer func(mode string) { videoPublishMode = mode }(videoPublishMode)
+	videoPublishMode = "note"
+	events := buildPostEvents(*feedItem, items[0], "talk", 1700000000)
+	if len(events) != 1 || events[0].Kind != nostr.KindTextNote {
+		t.Fatalf("video published as %d events of kind %d by default", len(events), events[0].Kind)
+	}
+
+	feedItem.Settings.PublishMode = "video+note"
+	events = buildPostEvents(*feedItem, items[0], "talk", 1700000000)
+	if len(events) != 2 {
+		t.Fatalf("%d events in video+note mode, want 2", len(events))
+	}
+	video, note := events[0], events[1]
+	if video.Kind != kindVideo || video.Content != "The talk" {
+		t.Errorf("video event of kind %d with content %q", video.Kind, video.Content)
+	}
+	wantTags := nostr.Tags{
+		{"title", "Talk"},
+		{"published_at", "1700000000"},
+		{"alt", "Video: Talk"},
+		{"imeta", "url https://tube.example.com/talk-1080.mp4", "m video/mp4", "dim 1920x1080", "size 1000",
+			"duration 1234", "image https://tube.example.com/talk.jpg", "fallback https://tube.example.com/talk-480.mp4"},
+		{"duration", "1234"},
+		{"t", "conference"},
+		{"r", "https://tube.example.com/w/talk"},
+		feedItem.proxyTag(items[0]),
+	}
+	if !reflect.DeepEqual(video.Tags, wantTags) {
+		t.Errorf("video tags = %v, want %v", video.Tags, wantTags)
+	}
+	if ok, err := video.CheckSignature(); !ok || err != nil {
+		t.Errorf("video event isn't signed: %v", err)
+	}
+
+	// the note quotes the video event
+	if note.Kind != nostr.KindTextNote {
+		t.Errorf("note of kind %d", note.Kind)
+	}
+	if q := note.Tags.GetFirst([]string{"q", video.ID}); q == nil || (*q)[2] != "wss://relay.example.com" || (*q)[3] != feedItem.Pub {
+		t.Errorf("note doesn't quote the video: %v", note.Tags)
+	}
+	_, link, _ := strings.Cut(note.Content, "nostr:")
+	if prefix, data, err := nip19.Decode(link); err != nil || prefix != "nevent" || data.(nostr.EventPointer).ID != video.ID {
+		t.Errorf("note doesn't link the video: %q", note.Content)
+	}
+
+	// shorts are kind 22, and "video" mode leaves out the note
+	feedItem.Settings.PublishMode = "video"
+	youtube := parseTestItems(t, testYouTubeFeed)
+	events = buildPostEvents(*feedItem, youtube[1], "short1", 1700000000)
+	if len(events) != 1 || events[0].Kind != kindShortVideo {
+		t.Errorf("short published as %d events of kind %d", len(events), events[0].Kind)
+	}
+	if imeta := events[0].Tags.GetFirst([]string{"imeta"}); imeta != nil {
+		t.Errorf("short without thumbnail has imeta %v", imeta)
+	}
+	// YouTube offers no video file, the imeta only has the thumbnail
+	events = buildPostEvents(*feedItem, youtube[0], "abc123", 1700000000)
+	if imeta := events[0].Tags.GetFirst([]string{"imeta"}); imeta == nil ||
+		!reflect.DeepEqual(*imeta, nostr.Tag{"imeta", "image https://i.ytimg.com/vi/abc123/hqdefault.jpg"}) {
+		t.Errorf("YouTube imeta = %v", imeta)
+	}
+	if r := events[0].Tags.GetFirst([]string{"r", "https://www.youtube.com/watch?v=abc123"}); r == nil {
+		t.Errorf("YouTube video doesn't link the watch page: %v", events[0].Tags)
+	}
+	// items without a video fall back to notes
+	events = buildPostEvents(*feedItem, items[1], "blog", 1700000000)
+	if len(events) != 1 || events[0].Kind != nostr.KindTextNote {
+		t.Errorf("blog post published as %d events of kind %d", len(events), events[0].Kind)
+	}
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr/nip05"
-	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 func (a *Atomstr) webMain(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +32,7 @@ func (a *Atomstr) webMain(w http.ResponseWriter, r *http.Request) {
 		Relays:  relaysToPublishTo,
 		Feeds:   *feeds,
 		Version: atomstrVersion,
+		Admin:   adminToken != "",
 	}
 	tmpl.Execute(w, data)
 }
@@ -34,10 +40,18 @@ func (a *Atomstr) webMain(w http.ResponseWriter, r *http.Request) {
 func (a *Atomstr) webAdd(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFiles("templates/add.tmpl"))
 	url := r.FormValue("url")
-	feedItem, err := a.addSource(url)
+	feedItem, err := a.addSource(url, feedSettings{})
+	if errors.Is(err, errFeedExists) {
+		// show the existing feed
+		err = nil
+	}
 
 	var status string
-	if err != nil {
+	var multiple *multipleFeedsError
+	if errors.As(err, &multiple) {
+		status = "Multiple feeds found, please choose one."
+		feedItem = &feedStruct{}
+	} else if err != nil {
 		status = "No feed found or feed already exists."
 	} else {
 		// If npub is provided in query params (from async redirect), use it
@@ -45,11 +59,6 @@ func (a *Atomstr) webAdd(w http.ResponseWriter, r *http.Request) {
 		if npubParam := r.FormValue("npub"); npubParam != "" {
 			feedItem.Npub = npubParam
 			log.Printf("[DEBUG] Using npub from query parameter: %s", npubParam)
-		} else {
-			feedItem.Npub, err = nip19.EncodePublicKey(feedItem.Pub)
-			if err != nil {
-				log.Fatal("Error encoding public key:", err)
-			}
 		}
 		status = "Success! Check your feed below and open it with your preferred app."
 	}
@@ -57,6 +66,9 @@ func (a *Atomstr) webAdd(w http.ResponseWriter, r *http.Request) {
 		Status: status,
 		Feed:   *feedItem,
 	}
+	if multiple != nil {
+		data.Candidates = multiple.Candidates
+	}
 
 	tmpl.Execute(w, data)
 }
@@ -86,10 +98,16 @@ func (a *Atomstr) webStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	outboxPending, err := a.dbCountOutbox()
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+
 	response := map[string]interface{}{
-		"total_feeds":   totalFeeds,
-		"broken_feeds":  brokenFeeds,
-		"failing_feeds": failingFeeds,
+		"total_feeds":    totalFeeds,
+		"broken_feeds":   brokenFeeds,
+		"failing_feeds":  failingFeeds,
+		"outbox_pending": outboxPending,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -110,13 +128,14 @@ func (a *Atomstr) webNip05(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, feed := range *feeds {
-			if feedURLToNip05Name(feed.URL) == name {
+			// joined sources keep their own name as an alias of the identity
+			if feedURLToNip05Name(feed.URL) == name || feed.Profile.Nip05 == name {
 				nip05WellKnownResponse := nip05.WellKnownResponse{
 					Names: map[string]string{
 						name: feed.Pub,
 					},
 					Relays: map[string][]string{
-						feed.Pub: relaysToPublishTo,
+						feed.Pub: feed.publishRelays(),
 					},
 				}
 				response, _ = json.Marshal(nip05WellKnownResponse)
@@ -124,6 +143,180 @@ func (a *Atomstr) webNip05(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+
+		// names of feeds that moved to another URL
+		feed, err := a.dbGetNip05Alias(name)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		if feed != nil {
+			response, _ = json.Marshal(nip05.WellKnownResponse{
+				Names:  map[string]string{name: feed.Pub},
+				Relays: map[string][]string{feed.Pub: feed.publishRelays()},
+			})
+			_, _ = w.Write(response)
+		}
+	}
+}
+
+// requireAdmin checks the admin token sent as basic auth password. Admin
+// pages are disabled unless ADMIN_TOKEN is set.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		http.Error(w, "Admin pages are disabled", http.StatusForbidden)
+		return false
+	}
+	_, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="atomstr"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (a *Atomstr) webSettings(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	tmpl := template.Must(template.ParseFiles("templates/settings.tmpl"))
+
+	feedItem := a.dbGetFeed(r.FormValue("url"))
+	if feedItem.URL == "" {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+	settings, err := a.dbGetFeedSettings(feedItem.SourcePub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "Failed to get feed settings", http.StatusInternalServerError)
+		return
+	}
+
+	var status string
+	if r.Method == "POST" && r.FormValue("move_to") != "" {
+		if moved, err := a.moveSource(feedItem.URL, r.FormValue("move_to"), true); err != nil {
+			status = "Error: " + err.Error()
+		} else {
+			if a.scheduler != nil {
+				a.scheduler.schedule(moved.URL, time.Now())
+			}
+			feedItem = moved
+			status = "Feed moved."
+		}
+	} else if r.Method == "POST" && r.FormValue("add_filter") != "" {
+		if err := a.updateFeedFilters(feedItem.URL, []string{"add", r.FormValue("add_filter")}); err != nil {
+			status = "Error: " + err.Error()
+		} else {
+			status = "Filter added."
+		}
+	} else if r.Method == "POST" && r.FormValue("remove_filter") != "" {
+		if err := a.updateFeedFilters(feedItem.URL, []string{"remove", r.FormValue("remove_filter")}); err != nil {
+			status = "Error: " + err.Error()
+		} else {
+			status = "Filter removed."
+		}
+	} else if r.Method == "POST" {
+		var args []string
+		for _, key := range sortedFeedSettingKeys() {
+			args = append(args, key+"="+r.FormValue(key))
+		}
+		if err := parseFeedSettingArgs(&settings, args); err != nil {
+			status = "Error: " + err.Error()
+		} else if err := a.dbSaveFeedSettings(feedItem.SourcePub, settings); err != nil {
+			log.Printf("[ERROR] %v", err)
+			status = "Failed to save settings."
+		} else {
+			status = "Settings saved."
+		}
+	}
+
+	data := webFeedSettings{
+		Status: status,
+		Feed:   *feedItem,
+	}
+	for _, key := range sortedFeedSettingKeys() {
+		data.Settings = append(data.Settings, webFeedSetting{
+			Key:         key,
+			Description: feedSettingKeys[key],
+			Value:       settings.get(key),
+		})
+	}
+	if data.Filters, err = a.dbGetFeedFilters(feedItem.SourcePub); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	tmpl.Execute(w, data)
+}
+
+// webOPML exports all feeds on GET. A POST with an OPML file (form field
+// "opml") imports it in the background, the progress is reported by
+// /add-status/<job id>.
+func (a *Atomstr) webOPML(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="atomstr.opml"`)
+		if err := a.exportOPML(w); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	case "POST":
+		if !requireAdmin(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		file, _, err := r.FormFile("opml")
+		if err != nil {
+			json.NewEncoder(w).Encode(asyncResponse{Error: "OPML file is required"})
+			return
+		}
+		defer file.Close()
+		body, err := io.ReadAll(io.LimitReader(file, 10<<20))
+		if err != nil {
+			json.NewEncoder(w).Encode(asyncResponse{Error: "Can't read OPML file"})
+			return
+		}
+
+		job := &asyncJob{
+			ID:      generateJobID(),
+			Status:  "processing",
+			Message: "Reading OPML file",
+		}
+		jobsMutex.Lock()
+		jobs[job.ID] = job
+		jobsMutex.Unlock()
+
+		go a.importOPMLAsync(job, body)
+		json.NewEncoder(w).Encode(asyncResponse{JobID: job.ID})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Atomstr) importOPMLAsync(job *asyncJob, body []byte) {
+	defer expireJob(job)
+	result, err := a.importOPML(bytes.NewReader(body), func(done int, result opmlImportResult) {
+		jobsMutex.Lock()
+		job.Message = fmt.Sprintf("Importing feed %d of %d (%d added, %d skipped, %d failed)",
+			done+1, result.Total, result.Added, result.Skipped, len(result.Failed))
+		jobsMutex.Unlock()
+	})
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+		return
+	}
+	job.Status = "completed"
+	job.Message = "Imported " + result.String()
+	if len(result.Failed) > 0 {
+		var failed []string
+		for feedURL, reason := range result.Failed {
+			failed = append(failed, feedURL+": "+reason)
+		}
+		sort.Strings(failed)
+		job.Error = strings.Join(failed, "\n")
 	}
 }
 
@@ -131,8 +324,20 @@ func (a *Atomstr) webNip05(w http.ResponseWriter, r *http.Request) {
 var (
 	jobs      = make(map[string]*asyncJob)
 	jobsMutex sync.RWMutex
+	// finished jobs are kept this long for the client to poll the result
+	jobRetention = 5 * time.Minute
 )
 
+// expireJob removes a finished job after jobRetention, whether it
+// succeeded or failed.
+func expireJob(job *asyncJob) {
+	time.AfterFunc(jobRetention, func() {
+		jobsMutex.Lock()
+		delete(jobs, job.ID)
+		jobsMutex.Unlock()
+	})
+}
+
 func generateJobID() string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
@@ -203,10 +408,11 @@ func (a *Atomstr) webAddStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := statusResponse{
-		Status:  job.Status,
-		Message: job.Message,
-		URL:     job.FeedURL,
-		Npub:    job.Npub,
+		Status:     job.Status,
+		Message:    job.Message,
+		URL:        job.FeedURL,
+		Npub:       job.Npub,
+		Candidates: job.Candidates,
 	}
 	log.Printf("[DEBUG] Returning status response for job %s with npub: %s", jobID, job.Npub)
 
@@ -219,86 +425,35 @@ func (a *Atomstr) webAddStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Atomstr) processFeedAsync(job *asyncJob) {
-	// Update status: validating feed
-	jobsMutex.Lock()
-	job.Message = "Validating feed URL"
-	jobsMutex.Unlock()
-
-	// Validate feed source
-	feedItem, err := checkValidFeedSource(job.URL)
-	if err != nil {
-		jobsMutex.Lock()
-		job.Status = "failed"
-		job.Error = "No valid feed found at URL"
-		jobsMutex.Unlock()
-		return
-	}
-
-	// Update status: checking for duplicates
-	jobsMutex.Lock()
-	job.Message = "Checking for duplicate feeds"
-	jobsMutex.Unlock()
-
-	// Check for existing feed
-	feedTest := a.dbGetFeed(job.URL)
-	if feedTest.URL != "" {
-		jobsMutex.Lock()
-		job.Status = "failed"
-		job.Error = "Feed already exists"
-		jobsMutex.Unlock()
-		return
-	}
+	defer expireJob(job)
 
-	// Update status: generating keys
+	// Update status: validating feed
 	jobsMutex.Lock()
-	job.Message = "Generating feed keys"
+	job.Message = "Validating feed URL and processing its history (this may take a while)"
 	jobsMutex.Unlock()
 
-	feedItemKeys := generateKeysForURL(job.URL)
-	feedItem.Pub = feedItemKeys.Pub
-	feedItem.Sec = feedItemKeys.Sec
-	feedItem.Npub, err = nip19.EncodePublicKey(feedItem.Pub)
+	// the same path as -a, discovering the feed if the URL is a web page
+	feedItem, err := a.addSource(job.URL, feedSettings{})
 	if err != nil {
-		log.Fatal("Error encoding public key:", err)
-	}
-	log.Printf("[DEBUG] Generated npub: %s for URL: %s", feedItem.Npub, job.URL)
-
-	// Update status: saving to database
-	jobsMutex.Lock()
-	job.Message = "Saving feed to database"
-	jobsMutex.Unlock()
-
-	if err := a.dbWriteFeed(feedItem); err != nil {
+		var multiple *multipleFeedsError
 		jobsMutex.Lock()
 		job.Status = "failed"
-		job.Error = "Failed to save feed to database"
+		switch {
+		case errors.As(err, &multiple):
+			job.Error = "Multiple feeds found, please choose one"
+			job.Candidates = multiple.Candidates
+		case errors.Is(err, errFeedExists):
+			job.Error = "Feed already exists"
+		case feedItem != nil && feedItem.Pub != "":
+			// keys are only generated for a valid feed
+			job.Error = "Failed to save feed to database"
+		default:
+			job.Error = "No valid feed found at URL"
+		}
 		jobsMutex.Unlock()
 		return
 	}
 
-	// Update status: publishing metadata
-	if !dryRunMode {
-		jobsMutex.Lock()
-		job.Message = "Publishing feed metadata"
-		jobsMutex.Unlock()
-		nostrUpdateFeedMetadata(feedItem)
-	} else {
-		jobsMutex.Lock()
-		job.Message = "Dry-run mode: would publish feed metadata"
-		jobsMutex.Unlock()
-	}
-
-	// Update status: processing history
-	jobsMutex.Lock()
-	job.Message = "Processing feed history (this may take a while)"
-	jobsMutex.Unlock()
-
-	log.Println("[INFO] Parsing post history of new feed")
-	for i := range feedItem.Posts {
-		processFeedPost(*feedItem, feedItem.Posts[i], historyInterval, nil)
-	}
-	log.Println("[INFO] Finished parsing post history of new feed")
-
 	// Success
 	jobsMutex.Lock()
 	job.Status = "completed"
@@ -307,14 +462,6 @@ func (a *Atomstr) processFeedAsync(job *asyncJob) {
 	job.Npub = feedItem.Npub
 	log.Printf("[INFO] Job %s completed with npub: %s", job.ID, job.Npub)
 	jobsMutex.Unlock()
-
-	// Clean up old jobs after 5 minutes
-	go func() {
-		time.Sleep(5 * time.Minute)
-		jobsMutex.Lock()
-		delete(jobs, job.ID)
-		jobsMutex.Unlock()
-	}()
 }
 
 func (a *Atomstr) webserver() {
@@ -323,7 +470,10 @@ func (a *Atomstr) webserver() {
 	http.HandleFunc("/add-async", a.webAddAsync)
 	http.HandleFunc("/add-status/", a.webAddStatus)
 	http.HandleFunc("/api/stats", a.webStats)
+	http.HandleFunc("/settings", a.webSettings)
 	http.HandleFunc("/.well-known/nostr.json", a.webNip05)
+	http.HandleFunc("/websub/", a.webWebSub)
+	http.HandleFunc("/opml", a.webOPML)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	log.Println("[INFO] Starting webserver at port", webserverPort)
 	log.Fatal(http.ListenAndServe(":"+webserverPort, nil))
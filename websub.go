@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	websubLeaseSeconds = 10 * 24 * 60 * 60
+	// websubRetryInterval is how long a pending or denied subscription
+	// waits before it is requested again
+	websubRetryInterval = time.Hour
+	// websubPollInterval is the safety net polling of feeds that push
+	websubPollInterval = 24 * time.Hour
+	maxPushBodySize    = 10 << 20
+)
+
+// websubSubscription is a WebSub subscription of a feed at its hub. State
+// is "" (hub known, not subscribed yet), "pending" (requested, not verified
+// by the hub yet), "active" or "denied". A renewal is pending as well, the
+// lease confirmed before stays valid until it expires.
+type websubSubscription struct {
+	FeedPub      string
+	Hub          string
+	Topic        string
+	Secret       string
+	State        string
+	LeaseSeconds int
+	LeaseExpires *time.Time
+	RequestedAt  *time.Time
+}
+
+// findWebSubLinks returns the hub and self links advertised by a feed, in
+// the HTTP Link header or as link elements of the document.
+func findWebSubLinks(feedURL string, header http.Header, body []byte) (hub, self string) {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			for _, rel := range linkRels(params) {
+				if rel == "hub" && hub == "" {
+					hub = target
+				} else if rel == "self" && self == "" {
+					self = target
+				}
+			}
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	for hub == "" || self == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		el, ok := token.(xml.StartElement)
+		if !ok || el.Name.Local != "link" {
+			continue
+		}
+		var rel, href string
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "rel":
+				rel = attr.Value
+			case "href":
+				href = attr.Value
+			}
+		}
+		for _, r := range strings.Fields(rel) {
+			if strings.EqualFold(r, "hub") && hub == "" {
+				hub = href
+			} else if strings.EqualFold(r, "self") && self == "" {
+				self = href
+			}
+		}
+	}
+
+	hub = resolveURL(feedURL, hub)
+	self = resolveURL(feedURL, self)
+	if self == "" {
+		self = feedURL
+	}
+	return hub, self
+}
+
+// linkRels returns the rel values of the parameters of a Link header entry.
+func linkRels(params string) []string {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(name, "rel") {
+			return strings.Fields(strings.ToLower(strings.Trim(value, `"`)))
+		}
+	}
+	return nil
+}
+
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+// leaseActive reports whether the hub confirmed a lease that hasn't expired
+// yet, a renewal may be pending.
+func (s websubSubscription) leaseActive() bool {
+	return s.LeaseExpires != nil && s.LeaseExpires.After(time.Now())
+}
+
+func websubCallbackURL(feedPub string) string {
+	return strings.TrimSuffix(websubCallbackBase, "/") + "/websub/" + feedPub
+}
+
+// pushActive reports whether the feed has an active WebSub subscription.
+// The lease is only stored when the hub verified a subscription we asked for.
+func (f feedStruct) pushActive() bool {
+	return f.PushLeaseExpires != nil && f.PushLeaseExpires.After(time.Now())
+}
+
+// dbSaveWebSubHub remembers the hub of a feed. A changed hub or topic
+// resets the subscription, so it is requested again.
+func (a *Atomstr) dbSaveWebSubHub(feedPub, hub, topic string) error {
+	if hub == "" {
+		return nil
+	}
+	_, err := a.db.Exec(`INSERT INTO websub_subscriptions (feed_pub, hub, topic) VALUES (?, ?, ?)
+		ON CONFLICT (feed_pub) DO UPDATE SET hub = excluded.hub, topic = excluded.topic, state = '', lease_expires = NULL
+		WHERE hub != excluded.hub OR topic != excluded.topic`, feedPub, hub, topic)
+	if err != nil {
+		return fmt.Errorf("can't save websub hub: %w", err)
+	}
+	return nil
+}
+
+const websubSelectSQL = `SELECT feed_pub, hub, topic, secret, state, lease_seconds, lease_expires, requested_at FROM websub_subscriptions`
+
+func scanWebSubSubscription(row interface{ Scan(...any) error }) (websubSubscription, error) {
+	var sub websubSubscription
+	err := row.Scan(&sub.FeedPub, &sub.Hub, &sub.Topic, &sub.Secret, &sub.State, &sub.LeaseSeconds, &sub.LeaseExpires, &sub.RequestedAt)
+	return sub, err
+}
+
+func (a *Atomstr) dbGetWebSubSubscription(feedPub string) (*websubSubscription, error) {
+	sub, err := scanWebSubSubscription(a.db.QueryRow(websubSelectSQL+` WHERE feed_pub = ?`, feedPub))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read websub subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// dbGetDueWebSubSubscriptions returns the subscriptions that have to be
+// requested or renewed.
+func (a *Atomstr) dbGetDueWebSubSubscriptions() ([]websubSubscription, error) {
+	rows, err := a.db.Query(websubSelectSQL)
+	if err != nil {
+		return nil, fmt.Errorf("returning websub subscriptions from DB failed: %w", err)
+	}
+	defer rows.Close()
+
+	var due []websubSubscription
+	now := time.Now()
+	for rows.Next() {
+		sub, err := scanWebSubSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning for websub subscriptions failed: %w", err)
+		}
+		switch sub.State {
+		case "":
+			due = append(due, sub)
+		case "pending", "denied":
+			if sub.RequestedAt == nil || now.Sub(*sub.RequestedAt) >= websubRetryInterval {
+				due = append(due, sub)
+			}
+		case "active":
+			// renew when less than a day or half of the lease is left
+			renewBefore := min(24*time.Hour, time.Duration(sub.LeaseSeconds)*time.Second/2)
+			retried := sub.RequestedAt == nil || now.Sub(*sub.RequestedAt) >= websubRetryInterval
+			if retried && (sub.LeaseExpires == nil || sub.LeaseExpires.Sub(now) < renewBefore) {
+				due = append(due, sub)
+			}
+		}
+	}
+	return due, nil
+}
+
+func (a *Atomstr) dbUpdateWebSubState(feedPub, state string, leaseSeconds int, leaseExpires *time.Time) error {
+	if leaseExpires != nil {
+		utc := leaseExpires.UTC()
+		leaseExpires = &utc
+	}
+	_, err := a.db.Exec(`UPDATE websub_subscriptions SET state = ?, lease_seconds = ?, lease_expires = ? WHERE feed_pub = ?`,
+		state, leaseSeconds, leaseExpires, feedPub)
+	if err != nil {
+		return fmt.Errorf("can't update websub subscription: %w", err)
+	}
+	return nil
+}
+
+// websubRequest sends a subscribe or unsubscribe request to the hub. New
+// subscriptions get a new secret, renewals keep the current one so pushes
+// stay valid if the renewal fails.
+func (a *Atomstr) websubRequest(sub websubSubscription, mode string) error {
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {sub.Topic},
+		"hub.callback": {websubCallbackURL(sub.FeedPub)},
+	}
+	if mode == "subscribe" {
+		if !sub.leaseActive() || sub.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return fmt.Errorf("can't generate websub secret: %w", err)
+			}
+			sub.Secret = hex.EncodeToString(secret)
+		}
+		form.Set("hub.secret", sub.Secret)
+		form.Set("hub.lease_seconds", strconv.Itoa(websubLeaseSeconds))
+
+		// the hub may verify before it answers, so store the secret first.
+		// Only pending requests are confirmed by the callback.
+		_, err := a.db.Exec(`UPDATE websub_subscriptions SET secret = ?, state = 'pending', requested_at = ? WHERE feed_pub = ?`,
+			sub.Secret, time.Now().UTC(), sub.FeedPub)
+		if err != nil {
+			return fmt.Errorf("can't update websub subscription: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("can't create websub request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "atomstr/"+atomstrVersion)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach websub hub %s: %w", sub.Hub, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("websub hub %s refused %s: HTTP %d %s", sub.Hub, mode, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	log.Printf("[DEBUG] Requested websub %s of %s at %s", mode, sub.Topic, sub.Hub)
+	return nil
+}
+
+// websubManager subscribes feeds with a hub and renews leases before they
+// expire. It never returns.
+func (a *Atomstr) websubManager() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		due, err := a.dbGetDueWebSubSubscriptions()
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+		for _, sub := range due {
+			if err := a.websubRequest(sub, "subscribe"); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+		}
+	}
+}
+
+// websubUnsubscribe ends the subscription of a feed that is removed.
+func (a *Atomstr) websubUnsubscribe(feedPub string) {
+	sub, err := a.dbGetWebSubSubscription(feedPub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	if sub == nil {
+		return
+	}
+	// removed first, the callback only confirms unsubscribing unknown feeds
+	if _, err := a.db.Exec(`DELETE FROM websub_subscriptions WHERE feed_pub = ?`, feedPub); err != nil {
+		log.Printf("[ERROR] can't remove websub subscription: %v", err)
+		return
+	}
+	if sub.leaseActive() && websubCallbackBase != "" {
+		if err := a.websubRequest(*sub, "unsubscribe"); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+}
+
+// webWebSub is the WebSub callback. GET requests are intent verifications
+// of the hub, POST requests deliver new content.
+func (a *Atomstr) webWebSub(w http.ResponseWriter, r *http.Request) {
+	feedPub := strings.TrimPrefix(r.URL.Path, "/websub/")
+	sub, err := a.dbGetWebSubSubscription(feedPub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.websubVerify(w, r, feedPub, sub)
+	case http.MethodPost:
+		if sub == nil {
+			http.Error(w, "Unknown subscription", http.StatusGone)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPushBodySize))
+		if err != nil {
+			http.Error(w, "Can't read body", http.StatusBadRequest)
+			return
+		}
+		// the hub gets a 2xx either way, content with a bad signature is
+		// ignored as required by the spec
+		w.WriteHeader(http.StatusAccepted)
+		if !validHubSignature(sub.Secret, r.Header.Get("X-Hub-Signature"), body) {
+			log.Printf("[WARN] Ignoring websub push for %s with invalid signature", sub.Topic)
+			return
+		}
+		go a.processPushedFeed(feedPub, body)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Atomstr) websubVerify(w http.ResponseWriter, r *http.Request, feedPub string, sub *websubSubscription) {
+	query := r.URL.Query()
+	mode := query.Get("hub.mode")
+	topic := query.Get("hub.topic")
+	challenge := query.Get("hub.challenge")
+
+	switch mode {
+	case "subscribe":
+		// anyone can call the callback, only the request we sent is confirmed
+		if sub == nil || sub.Topic != topic || sub.State != "pending" {
+			http.NotFound(w, r)
+			return
+		}
+		// a longer lease than requested is renewed early
+		leaseSeconds, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil || leaseSeconds <= 0 || leaseSeconds > websubLeaseSeconds {
+			leaseSeconds = websubLeaseSeconds
+		}
+		expires := time.Now().Add(time.Duration(leaseSeconds) * time.Second)
+		if err := a.dbUpdateWebSubState(feedPub, "active", leaseSeconds, &expires); err != nil {
+			log.Printf("[ERROR] %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] WebSub subscription of %s active for %v", topic, time.Duration(leaseSeconds)*time.Second)
+	case "unsubscribe":
+		// only confirm unsubscribing from feeds we no longer want
+		if sub != nil && sub.Topic == topic {
+			http.NotFound(w, r)
+			return
+		}
+	case "denied":
+		if sub != nil && sub.Topic == topic && sub.State == "pending" {
+			log.Printf("[WARN] WebSub subscription of %s denied: %s", topic, query.Get("hub.reason"))
+			if err := a.dbUpdateWebSubState(feedPub, "denied", 0, nil); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "Invalid hub.mode", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(challenge))
+}
+
+// validHubSignature checks the X-Hub-Signature header (method=hexdigest) of
+// a pushed body against the subscription secret.
+func validHubSignature(secret, signature string, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	method, digest, ok := strings.Cut(signature, "=")
+	if !ok {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// processPushedFeed publishes the items of a document pushed by the hub,
+// the same way as items of a polled feed. Pushes and fetches of the same
+// feed don't overlap, so an item isn't published twice.
+func (a *Atomstr) processPushedFeed(feedPub string, body []byte) {
+	defer a.feedLocks.lock(feedPub)()
+
+	feedItem, err := a.dbGetFeedByPub(feedPub)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	if feedItem.URL == "" {
+		log.Printf("[WARN] WebSub push for unknown feed %s", feedPub)
+		return
+	}
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[WARN] Can't parse websub push for %s: %v", feedItem.URL, err)
+		return
+	}
+	log.Printf("[DEBUG] WebSub push for %s with %d items", feedItem.URL, len(feed.Items))
+	// a push may only contain the new items, so removed ones aren't tracked
+	a.publishFeedItems(feedItem, feed, a.stats)
+}
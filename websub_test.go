@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hubSignature(method string, newHash func() hash.Hash, secret string, body []byte) string {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return method + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidHubSignature(t *testing.T) {
+	body := []byte("<feed>pushed</feed>")
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		want      bool
+	}{
+		{"sha1", "secret", hubSignature("sha1", sha1.New, "secret", body), true},
+		{"sha256", "secret", hubSignature("sha256", sha256.New, "secret", body), true},
+		{"upper case method", "secret", hubSignature("SHA256", sha256.New, "secret", body), true},
+		{"wrong secret", "secret", hubSignature("sha256", sha256.New, "other", body), false},
+		{"wrong method", "secret", hubSignature("sha1", sha256.New, "secret", body), false},
+		{"unknown method", "secret", hubSignature("md5", sha256.New, "secret", body), false},
+		{"no method", "secret", strings.TrimPrefix(hubSignature("sha1", sha1.New, "secret", body), "sha1="), false},
+		{"invalid hex", "secret", "sha1=xyz", false},
+		{"no secret", "", hubSignature("sha1", sha1.New, "", body), false},
+		{"empty", "secret", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validHubSignature(tt.secret, tt.signature, body); got != tt.want {
+				t.Errorf("validHubSignature(%q, %q) = %v, want %v", tt.secret, tt.signature, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextFetchPush(t *testing.T) {
+	lease := time.Now().Add(24 * time.Hour)
+	expired := time.Now().Add(-time.Hour)
+	items := itemsEvery(5, time.Hour, time.Now())
+
+	if next := scheduleNextFetch(feedStruct{PushLeaseExpires: &lease}, items, 0); next.Interval != websubPollInterval {
+		t.Errorf("feed with an active subscription is polled every %v, want %v", next.Interval, websubPollInterval)
+	}
+	if next := scheduleNextFetch(feedStruct{PushLeaseExpires: &expired}, items, 0); next.Interval == websubPollInterval {
+		t.Errorf("feed with an expired subscription is polled as a safety net only")
+	}
+}
+
+func TestProcessPushedFeedMaxItems(t *testing.T) {
+	a := newTestAtomstr(t)
+	a.stats = &scrapeStats{}
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	feedItem.Settings.MaxItems = 2
+	if err := a.dbSaveFeedSettings(feedItem.SourcePub, feedItem.Settings); err != nil {
+		t.Fatal(err)
+	}
+
+	var items strings.Builder
+	for i := 1; i <= 3; i++ {
+		date := time.Now().Add(time.Duration(i-4) * time.Minute).Format(time.RFC1123Z)
+		fmt.Fprintf(&items, "<item><title>Item %d</title><guid>item-%d</guid><link>https://example.org/%d</link><pubDate>%s</pubDate></item>", i, i, i, date)
+	}
+	body := `<?xml version="1.0"?><rss version="2.0"><channel><title>Pushed title</title><link>https://example.org/</link>` +
+		`<image><url>https://example.org/logo.png</url></image>` + items.String() + `</channel></rss>`
+	a.processPushedFeed(feedItem.SourcePub, []byte(body))
+
+	var published int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM published_items WHERE source_pub = ?`, feedItem.SourcePub).Scan(&published); err != nil {
+		t.Fatal(err)
+	}
+	if published != 2 {
+		t.Errorf("published %d items, want max_items 2", published)
+	}
+	if a.stats.postsPublished != 2 {
+		t.Errorf("stats count %d published posts, want 2", a.stats.postsPublished)
+	}
+	if a.dbGetPublishedItem(feedItem.Pub, "item-3") != nil {
+		t.Errorf("newest item was published beyond max_items")
+	}
+	if title := testFeed(t, a, feedItem.URL).Title; title != "Pushed title" {
+		t.Errorf("title is %q, want the pushed one", title)
+	}
+}
+
+func TestWebSubVerify(t *testing.T) {
+	a := newTestAtomstr(t)
+	feedItem := addTestFeed(t, a, "https://example.org/feed.xml")
+	topic := feedItem.URL
+	if err := a.dbSaveWebSubHub(feedItem.SourcePub, "https://hub.example.org/", topic); err != nil {
+		t.Fatal(err)
+	}
+	verify := func(mode, lease string) int {
+		query := url.Values{"hub.mode": {mode}, "hub.topic": {topic}, "hub.challenge": {"challenge"}, "hub.lease_seconds": {lease}}
+		w := httptest.NewRecorder()
+		a.webWebSub(w, httptest.NewRequest("GET", "/websub/"+feedItem.SourcePub+"?"+query.Encode(), nil))
+		return w.Code
+	}
+	subscription := func() *websubSubscription {
+		sub, err := a.dbGetWebSubSubscription(feedItem.SourcePub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+
+	if code := verify("subscribe", "3600"); code != http.StatusNotFound {
+		t.Errorf("subscribe without a request answered with %d", code)
+	}
+	if _, err := a.db.Exec(`UPDATE websub_subscriptions SET state = 'pending' WHERE feed_pub = ?`, feedItem.SourcePub); err != nil {
+		t.Fatal(err)
+	}
+	if code := verify("subscribe", "99999999999999"); code != http.StatusOK {
+		t.Fatalf("subscribe of a pending request answered with %d", code)
+	}
+	sub := subscription()
+	if sub.State != "active" || sub.LeaseSeconds != websubLeaseSeconds {
+		t.Errorf("subscription is %s with a lease of %ds, want active with %ds", sub.State, sub.LeaseSeconds, websubLeaseSeconds)
+	}
+
+	// once active, further verifications can't extend or deny the lease
+	if code := verify("subscribe", "3600"); code != http.StatusNotFound {
+		t.Errorf("repeated subscribe answered with %d", code)
+	}
+	verify("denied", "")
+	if after := subscription(); after.State != "active" || !after.LeaseExpires.Equal(*sub.LeaseExpires) {
+		t.Errorf("active subscription changed to %s, lease %v", after.State, after.LeaseExpires)
+	}
+	if code := verify("unsubscribe", ""); code != http.StatusNotFound {
+		t.Errorf("unsubscribe of a wanted feed answered with %d", code)
+	}
+}